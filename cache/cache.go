@@ -8,6 +8,9 @@ The system includes functionality to:
 - Retrieve a CloudCache by cloud name
 - Set a new CloudCache in the backend with a current timestamp
 - Flush CloudCaches that have not been updated within a specified time-to-live (TTL) period.
+- Persist CloudCaches to a directory with the FileCache backend, so they survive restarts.
+- Share CloudCaches between several exporters with the RedisCache backend.
+- Refresh services and metrics of a CloudCache on independent schedules.
 
 An example for using the cloud cache functionality:
 
@@ -32,6 +35,10 @@ FlushExpiredCloudCaches(TTL)
 package cache
 
 import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
@@ -51,19 +58,75 @@ type CacheBackend interface {
 	FlushExpiredCloudCaches(ttl time.Duration)
 }
 
+// CollectLocker is implemented by CacheBackends shared by several exporters,
+// so a single one of them refreshes each job of a cloud per refresh interval.
+type CollectLocker interface {
+	// TryLockCollect reports whether the caller holds the lock of the refresh
+	// job of cloud for ttl and should refresh it.
+	TryLockCollect(cloud, job string, ttl time.Duration) (bool, error)
+}
+
+// JobCacheWriter is implemented by CacheBackends shared by several exporters,
+// so an exporter only writes the refresh jobs it collected and never
+// overwrites the ones another exporter collected meanwhile.
+type JobCacheWriter interface {
+	// SetJobCaches stores the metric families and status of the given jobs
+	// of cloudCache, keyed like CloudCache.Services.
+	SetJobCaches(cloud string, cloudCache CloudCache, jobs []string)
+}
+
 // MetricFamily Cache Data
 type MetricFamilyCache struct {
 	Service string
-	MF      *dto.MetricFamily
+	// Job is the metric refreshed on its own schedule the metric family comes
+	// from, in the service-metric format. Empty for the service job.
+	Job string
+	MF  *dto.MetricFamily
+}
+
+// jobKey returns the key of the refresh job of the metric family.
+func (c *MetricFamilyCache) jobKey() string {
+	if c.Job != "" {
+		return c.Job
+	}
+	return c.Service
+}
+
+// metricFamilyKey returns the key of a metric family in MetricFamilyCaches,
+// prefixed by the key of its refresh job since every service reports some of
+// the same metric families, such as the exporter_collector ones.
+func metricFamilyKey(job, name string) string {
+	return job + "/" + name
 }
 
 // Cloud Cache Data
 type CloudCache struct {
 	// Latest update time.
 	Time time.Time
+	// Stale is set on CloudCaches loaded from a snapshot and not collected
+	// again since.
+	Stale bool
 	// The key of MetricFamilyCaches is metric family name
-	// to avoid duplicate MFs in the map.
+	// to avoid duplicate MFs in the map, prefixed by the service, or by the
+	// metric for the metrics refreshed on their own schedule.
 	MetricFamilyCaches map[string]*MetricFamilyCache
+	// Services holds the collection status of every refresh job, by service
+	// name, or by metric for the metrics refreshed on their own schedule.
+	Services map[string]ServiceCacheStatus
+	// familyIndex lists the keys of MetricFamilyCaches sorted by metric
+	// family name, so every scrape encodes the families in order without
+	// sorting them again.
+	familyIndex []string
+}
+
+// ServiceCacheStatus is the collection status of a service in a CloudCache.
+type ServiceCacheStatus struct {
+	// Time of the collection the cached metric families of the service come
+	// from, zero when none are cached.
+	Time time.Time `json:"time"`
+	// Success of the last collection of the service. After a failure, the
+	// metric families of the previous successful collection are kept.
+	Success bool `json:"success"`
 }
 
 // GetCache return a singleton CacheBackend
@@ -79,6 +142,13 @@ func GetCache() CacheBackend {
 	return singleCache
 }
 
+// SetCache replaces the singleton CacheBackend, e.g. by a FileCache. It must
+// be called before the cache is used.
+func SetCache(backend CacheBackend) {
+	once.Do(func() {})
+	singleCache = backend
+}
+
 // InMemoryCache is a in-memory store based CacheBackend implementation.
 type InMemoryCache struct {
 	mu          sync.Mutex
@@ -119,6 +189,7 @@ func (c *InMemoryCache) SetCloudCache(cloud string, data CloudCache) {
 
 	c.init(&cloud)
 	data.Time = time.Now()
+	data.indexFamilies()
 	c.CloudCaches[cloud] = &data
 }
 
@@ -141,12 +212,88 @@ func NewCloudCache() CloudCache {
 	cloud := CloudCache{
 		Time:               time.Now(),
 		MetricFamilyCaches: make(map[string]*MetricFamilyCache),
+		Services:           make(map[string]ServiceCacheStatus),
 	}
 
 	return cloud
 }
 
-// SetMetricFamilyCache updates the MetricFamilyCaches by associating a key, which is the metric family name.
+// SetMetricFamilyCache updates the MetricFamilyCaches by associating a key, built by metricFamilyKey.
 func (c *CloudCache) SetMetricFamilyCache(mfName string, data MetricFamilyCache) {
 	c.MetricFamilyCaches[mfName] = &data
 }
+
+// indexFamilies builds the familyIndex of the CloudCache. It must be called
+// again after MetricFamilyCaches changes.
+func (c *CloudCache) indexFamilies() {
+	c.familyIndex = slices.SortedFunc(maps.Keys(c.MetricFamilyCaches), func(a, b string) int {
+		return cmp.Or(
+			strings.Compare(c.MetricFamilyCaches[a].MF.GetName(), c.MetricFamilyCaches[b].MF.GetName()),
+			strings.Compare(a, b),
+		)
+	})
+}
+
+// metricFamilies returns the metric families of services sorted by name, the
+// ones reported by several refresh jobs being merged into a copy.
+func (c *CloudCache) metricFamilies(services []string) []*dto.MetricFamily {
+	if len(c.familyIndex) != len(c.MetricFamilyCaches) {
+		c.indexFamilies()
+	}
+
+	var mfs []*dto.MetricFamily
+	copied := false
+	for _, key := range c.familyIndex {
+		mfCache, ok := c.MetricFamilyCaches[key]
+		if !ok || !slices.Contains(services, mfCache.Service) {
+			continue
+		}
+
+		last := len(mfs) - 1
+		if last < 0 || mfs[last].GetName() != mfCache.MF.GetName() {
+			mfs = append(mfs, mfCache.MF)
+			copied = false
+			continue
+		}
+		if !copied {
+			mf := mfs[last]
+			mfs[last] = &dto.MetricFamily{Name: mf.Name, Help: mf.Help, Type: mf.Type, Unit: mf.Unit, Metric: slices.Clone(mf.Metric)}
+			copied = true
+		}
+		mfs[last].Metric = append(mfs[last].Metric, mfCache.MF.Metric...)
+	}
+	return mfs
+}
+
+// SetServiceStatus records the collection status of a service.
+func (c *CloudCache) SetServiceStatus(service string, status ServiceCacheStatus) {
+	if c.Services == nil {
+		c.Services = make(map[string]ServiceCacheStatus)
+	}
+	c.Services[service] = status
+}
+
+// keepJob copies the metric families of the refresh job from previous, after
+// its refresh failed, unless they were collected more than maxStaleness ago.
+// It reports whether they were kept.
+func (c *CloudCache) keepJob(previous CloudCache, job string, maxStaleness time.Duration) bool {
+	status, ok := previous.Services[job]
+	if !ok {
+		// Cached before the status of services was recorded.
+		status.Time = previous.Time
+	}
+
+	keep := !status.Time.IsZero() && time.Since(status.Time) <= maxStaleness
+	if !keep {
+		c.SetServiceStatus(job, ServiceCacheStatus{Success: false})
+		return false
+	}
+
+	for key, mfCache := range previous.MetricFamilyCaches {
+		if mfCache.jobKey() == job {
+			c.MetricFamilyCaches[key] = mfCache
+		}
+	}
+	c.SetServiceStatus(job, ServiceCacheStatus{Time: status.Time, Success: false})
+	return true
+}
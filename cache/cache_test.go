@@ -84,3 +84,21 @@ func TestCloudCacheSetMetricFamilyCache(t *testing.T) {
 	assert.NotZero(cloudCache.Time, "CloudCache.Time was not set")
 	assert.Len(cloudCache.MetricFamilyCaches, 1, "SetMetricFamilyCache value not set")
 }
+
+func TestCloudCacheMetricFamilies(t *testing.T) {
+	cloudCache := NewCloudCache()
+	cloudCache.SetMetricFamilyCache("compute/openstack_nova_up", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_nova_up", 1)})
+	cloudCache.SetMetricFamilyCache("compute/openstack_b", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_b", 1)})
+	cloudCache.SetMetricFamilyCache("nova-agent_state/openstack_b", MetricFamilyCache{Service: "compute", Job: "nova-agent_state", MF: newTestMetricFamily("openstack_b", 2)})
+	cloudCache.SetMetricFamilyCache("compute/openstack_a", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_a", 1)})
+	cloudCache.SetMetricFamilyCache("network/openstack_neutron_up", MetricFamilyCache{Service: "network", MF: newTestMetricFamily("openstack_neutron_up", 1)})
+
+	mfs := cloudCache.metricFamilies([]string{"compute"})
+	var names []string
+	for _, mf := range mfs {
+		names = append(names, mf.GetName())
+	}
+	assert.Equal(t, []string{"openstack_a", "openstack_b", "openstack_nova_up"}, names)
+	assert.Len(t, mfs[1].GetMetric(), 2, "the families of every job are merged")
+	assert.Len(t, cloudCache.MetricFamilyCaches["compute/openstack_b"].MF.GetMetric(), 1, "the cached family is left untouched")
+}
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+const snapshotExtension = ".snapshot"
+
+// FileCache is a CacheBackend keeping the CloudCaches in memory and
+// snapshotting each of them to a directory after every update, so the
+// metrics survive a restart. CloudCaches loaded from the directory are
+// marked as stale until they are collected again.
+type FileCache struct {
+	InMemoryCache
+	dir    string
+	logger *slog.Logger
+}
+
+// cloudSnapshot is the on-disk format of a CloudCache.
+type cloudSnapshot struct {
+	Cloud          string                        `json:"cloud"`
+	Time           time.Time                     `json:"time"`
+	MetricFamilies []metricFamilySnapshot        `json:"metric_families"`
+	Services       map[string]ServiceCacheStatus `json:"services,omitempty"`
+}
+
+// metricFamilySnapshot holds a MetricFamilyCache, the metric family being
+// encoded as protobuf.
+type metricFamilySnapshot struct {
+	Service string `json:"service"`
+	Job     string `json:"job,omitempty"`
+	MF      []byte `json:"mf"`
+}
+
+// NewFileCache returns a FileCache storing its snapshots in dir, created if
+// needed, with the snapshots already present in dir loaded. Snapshots that
+// can't be read are logged and ignored.
+func NewFileCache(dir string, logger *slog.Logger) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	c := &FileCache{
+		InMemoryCache: InMemoryCache{CloudCaches: make(map[string]*CloudCache)},
+		dir:           dir,
+		logger:        logger,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), snapshotExtension) {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		cloud, cloudCache, err := readSnapshot(path)
+		if err != nil {
+			logger.Warn("Ignoring unreadable cache snapshot", "path", path, "err", err)
+			continue
+		}
+
+		// The time of the snapshot is kept, so the TTL still applies.
+		cloudCache.Stale = true
+		c.CloudCaches[cloud] = cloudCache
+		logger.Info("Loaded cache snapshot", "cloud", cloud, "time", cloudCache.Time)
+	}
+
+	return c, nil
+}
+
+// SetCloudCache stores CloudCache in memory then snapshots it to disk.
+// Failing to write the snapshot is logged, the cache is still updated.
+func (c *FileCache) SetCloudCache(cloud string, data CloudCache) {
+	c.InMemoryCache.SetCloudCache(cloud, data)
+
+	cloudCache, _ := c.GetCloudCache(cloud)
+	if err := c.writeSnapshot(cloud, cloudCache); err != nil {
+		c.logger.Error("Failed to write cache snapshot", "cloud", cloud, "err", err)
+	}
+}
+
+// FlushExpiredCloudCaches deletes the expired CloudCaches and their snapshots.
+func (c *FileCache) FlushExpiredCloudCaches(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for cloud, cloudCache := range c.CloudCaches {
+		if !time.Now().After(cloudCache.Time.Add(ttl)) {
+			continue
+		}
+		delete(c.CloudCaches, cloud)
+		if err := os.Remove(c.snapshotPath(cloud)); err != nil && !os.IsNotExist(err) {
+			c.logger.Error("Failed to remove cache snapshot", "cloud", cloud, "err", err)
+		}
+	}
+}
+
+func (c *FileCache) snapshotPath(cloud string) string {
+	return filepath.Join(c.dir, url.PathEscape(cloud)+snapshotExtension)
+}
+
+// writeSnapshot writes the snapshot of cloudCache to a temporary file renamed
+// over the previous snapshot, so a crash never leaves a partial snapshot.
+func (c *FileCache) writeSnapshot(cloud string, cloudCache CloudCache) error {
+	data, err := marshalSnapshot(cloud, cloudCache)
+	if err != nil {
+		return err
+	}
+
+	path := c.snapshotPath(cloud)
+	tmp, err := os.CreateTemp(c.dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func readSnapshot(path string) (string, *CloudCache, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return unmarshalSnapshot(data)
+}
+
+// marshalSnapshot encodes cloudCache, its metric families as protobuf.
+func marshalSnapshot(cloud string, cloudCache CloudCache) ([]byte, error) {
+	snapshot := cloudSnapshot{Cloud: cloud, Time: cloudCache.Time, Services: cloudCache.Services}
+	for _, mfCache := range cloudCache.MetricFamilyCaches {
+		mf, err := proto.Marshal(mfCache.MF)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.MetricFamilies = append(snapshot.MetricFamilies, metricFamilySnapshot{Service: mfCache.Service, Job: mfCache.Job, MF: mf})
+	}
+
+	return json.Marshal(snapshot)
+}
+
+// unmarshalSnapshot decodes a snapshot encoded by marshalSnapshot.
+func unmarshalSnapshot(data []byte) (string, *CloudCache, error) {
+	var snapshot cloudSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return "", nil, err
+	}
+	if snapshot.Cloud == "" {
+		return "", nil, errors.New("missing cloud name")
+	}
+
+	cloudCache := &CloudCache{
+		Time:               snapshot.Time,
+		MetricFamilyCaches: make(map[string]*MetricFamilyCache, len(snapshot.MetricFamilies)),
+		Services:           make(map[string]ServiceCacheStatus, len(snapshot.Services)),
+	}
+	maps.Copy(cloudCache.Services, snapshot.Services)
+	for _, mfSnapshot := range snapshot.MetricFamilies {
+		mf := &dto.MetricFamily{}
+		if err := proto.Unmarshal(mfSnapshot.MF, mf); err != nil {
+			return "", nil, err
+		}
+		mfCache := MetricFamilyCache{Service: mfSnapshot.Service, Job: mfSnapshot.Job, MF: mf}
+		cloudCache.SetMetricFamilyCache(metricFamilyKey(mfCache.jobKey(), mf.GetName()), mfCache)
+	}
+	cloudCache.indexFamilies()
+
+	return snapshot.Cloud, cloudCache, nil
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func newTestMetricFamily(name string, value float64) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String(name),
+		Type: dto.MetricType_GAUGE.Enum(),
+		Metric: []*dto.Metric{
+			{Gauge: &dto.Gauge{Value: proto.Float64(value)}},
+		},
+	}
+}
+
+func TestFileCacheRestoresSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+
+	cache, err := NewFileCache(dir, logger)
+	require.NoError(t, err)
+
+	cloudCache := NewCloudCache()
+	cloudCache.SetMetricFamilyCache("compute/openstack_nova_up", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_nova_up", 1)})
+	cloudCache.SetServiceStatus("compute", ServiceCacheStatus{Time: time.Now(), Success: true})
+	cache.SetCloudCache("test/cloud", cloudCache)
+	stored, _ := cache.GetCloudCache("test/cloud")
+	assert.False(t, stored.Stale)
+
+	restarted, err := NewFileCache(dir, logger)
+	require.NoError(t, err)
+
+	restored, exists := restarted.GetCloudCache("test/cloud")
+	require.True(t, exists)
+	assert.True(t, restored.Stale)
+	assert.True(t, stored.Time.Equal(restored.Time), "the snapshot time must be kept")
+	require.Contains(t, restored.MetricFamilyCaches, "compute/openstack_nova_up")
+	assert.Equal(t, "compute", restored.MetricFamilyCaches["compute/openstack_nova_up"].Service)
+	assert.True(t, restored.Services["compute"].Success)
+	assert.True(t, proto.Equal(newTestMetricFamily("openstack_nova_up", 1), restored.MetricFamilyCaches["compute/openstack_nova_up"].MF))
+
+	// A new collection replaces the stale data.
+	restarted.SetCloudCache("test/cloud", NewCloudCache())
+	refreshed, _ := restarted.GetCloudCache("test/cloud")
+	assert.False(t, refreshed.Stale)
+}
+
+func TestFileCacheFlushRemovesSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+
+	cache, err := NewFileCache(dir, logger)
+	require.NoError(t, err)
+	cache.SetCloudCache("expired", NewCloudCache())
+
+	time.Sleep(2 * time.Millisecond)
+	cache.FlushExpiredCloudCaches(time.Millisecond)
+
+	_, exists := cache.GetCloudCache("expired")
+	assert.False(t, exists)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestFileCacheIgnoresInvalidSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken"+snapshotExtension), []byte("{"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken"+snapshotExtension+".tmp-1"), []byte("{}"), 0o600))
+
+	cache, err := NewFileCache(dir, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	assert.Empty(t, cache.CloudCaches)
+}
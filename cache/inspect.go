@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// CloudCacheInfo describes the content of the CloudCache of a cloud.
+type CloudCacheInfo struct {
+	Cloud string `json:"cloud"`
+	// Time of the last update of the CloudCache.
+	Time     time.Time          `json:"time"`
+	Stale    bool               `json:"stale"`
+	Services []ServiceCacheInfo `json:"services"`
+}
+
+// ServiceCacheInfo describes the cached metrics of a service.
+type ServiceCacheInfo struct {
+	Service        string `json:"service"`
+	MetricFamilies int    `json:"metric_families"`
+	Series         int    `json:"series"`
+	// Time of the collection of the oldest cached metrics of the service,
+	// zero when none are cached.
+	Time    time.Time `json:"time"`
+	Success bool      `json:"success"`
+}
+
+// Inspect describes the CloudCaches of clouds, skipping the clouds without
+// cache.
+func Inspect(clouds []string) []CloudCacheInfo {
+	cacheBackend := GetCache()
+
+	infos := []CloudCacheInfo{}
+	for _, cloud := range clouds {
+		cloudCache, exists := cacheBackend.GetCloudCache(cloud)
+		if !exists {
+			continue
+		}
+		infos = append(infos, inspectCloudCache(cloud, cloudCache))
+	}
+	return infos
+}
+
+func inspectCloudCache(cloud string, cloudCache CloudCache) CloudCacheInfo {
+	services := make(map[string]*ServiceCacheInfo)
+	serviceInfo := func(service string) *ServiceCacheInfo {
+		info, ok := services[service]
+		if !ok {
+			info = &ServiceCacheInfo{Service: service}
+			services[service] = info
+		}
+		return info
+	}
+
+	// The families reported by several refresh jobs of a service are
+	// counted once.
+	families := make(map[string]map[string]bool)
+	for _, mfCache := range cloudCache.MetricFamilyCaches {
+		info := serviceInfo(mfCache.Service)
+		info.Series += len(mfCache.MF.GetMetric())
+		if families[mfCache.Service] == nil {
+			families[mfCache.Service] = make(map[string]bool)
+		}
+		families[mfCache.Service][mfCache.MF.GetName()] = true
+	}
+	for service, names := range families {
+		serviceInfo(service).MetricFamilies = len(names)
+	}
+	for service, status := range serviceStatuses(cloudCache) {
+		info := serviceInfo(service)
+		info.Time = status.Time
+		info.Success = status.Success
+	}
+
+	info := CloudCacheInfo{
+		Cloud:    cloud,
+		Time:     cloudCache.Time,
+		Stale:    cloudCache.Stale,
+		Services: []ServiceCacheInfo{},
+	}
+	for _, service := range slices.Sorted(maps.Keys(services)) {
+		info.Services = append(info.Services, *services[service])
+	}
+	return info
+}
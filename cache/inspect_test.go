@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	cache := GetCache()
+	defer newSingleCache()
+
+	collectedAt := time.Now().Add(-time.Minute)
+	cloudCache := NewCloudCache()
+	cloudCache.SetMetricFamilyCache("compute/openstack_nova_up", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_nova_up", 1)})
+	cloudCache.SetMetricFamilyCache("compute/openstack_b", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_b", 1)})
+	cloudCache.SetMetricFamilyCache("nova-agent_state/openstack_b", MetricFamilyCache{Service: "compute", Job: "nova-agent_state", MF: newTestMetricFamily("openstack_b", 2)})
+	cloudCache.SetMetricFamilyCache("network/openstack_neutron_up", MetricFamilyCache{Service: "network", MF: newTestMetricFamily("openstack_neutron_up", 1)})
+	cloudCache.SetServiceStatus("compute", ServiceCacheStatus{Time: collectedAt, Success: true})
+	cloudCache.SetServiceStatus("nova-agent_state", ServiceCacheStatus{Time: time.Now(), Success: true})
+	cloudCache.SetServiceStatus("network", ServiceCacheStatus{Time: collectedAt, Success: false})
+	cache.SetCloudCache("testCloud", cloudCache)
+
+	infos := Inspect([]string{"testCloud", "missingCloud"})
+	require.Len(t, infos, 1)
+	info := infos[0]
+	assert.Equal(t, "testCloud", info.Cloud)
+	assert.NotZero(t, info.Time)
+	assert.Equal(t, []ServiceCacheInfo{
+		{Service: "compute", MetricFamilies: 2, Series: 3, Time: collectedAt, Success: true},
+		{Service: "network", MetricFamilies: 1, Series: 1, Time: collectedAt, Success: false},
+	}, info.Services)
+}
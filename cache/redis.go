@@ -0,0 +1,214 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const redisTimeout = 5 * time.Second
+
+// RedisCache is a CacheBackend storing the metric families of every cloud and
+// refresh job in a Redis compatible server, so several exporters serve the
+// same cache. Entries expire in the server after the cache TTL, and a lock
+// lets a single exporter refresh each job of a cloud per refresh interval.
+type RedisCache struct {
+	client *redisClient
+	prefix string
+	ttl    time.Duration
+	owner  string
+	logger *slog.Logger
+}
+
+// NewRedisCache returns a RedisCache connected to the server at rawURL,
+// prefixing its keys with prefix. Entries expire after ttl.
+func NewRedisCache(rawURL, prefix string, ttl time.Duration, logger *slog.Logger) (*RedisCache, error) {
+	client, err := newRedisClient(rawURL, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := make([]byte, 16)
+	if _, err := rand.Read(owner); err != nil {
+		return nil, err
+	}
+
+	c := &RedisCache{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+		owner:  hex.EncodeToString(owner),
+		logger: logger,
+	}
+
+	if _, err := client.do("PING"); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *RedisCache) cloudKey(cloud string) string {
+	return c.prefix + "cloud:" + url.QueryEscape(cloud)
+}
+
+// jobsKey holds the refresh jobs of the last collection of cloud.
+func (c *RedisCache) jobsKey(cloud string) string {
+	return c.cloudKey(cloud) + ":jobs"
+}
+
+func (c *RedisCache) jobKey(cloud, job string) string {
+	return c.cloudKey(cloud) + ":job:" + url.QueryEscape(job)
+}
+
+func (c *RedisCache) lockKey(cloud, job string) string {
+	return c.prefix + "lock:" + url.QueryEscape(cloud) + ":" + url.QueryEscape(job)
+}
+
+// SetCloudCache stores the metric families of each refresh job of cloud
+// under their own key, then the list of jobs. Errors are logged.
+func (c *RedisCache) SetCloudCache(cloud string, data CloudCache) {
+	jobs := make(map[string]bool)
+	for _, mfCache := range data.MetricFamilyCaches {
+		jobs[mfCache.jobKey()] = true
+	}
+	for job := range data.Services {
+		jobs[job] = true
+	}
+	c.SetJobCaches(cloud, data, slices.Collect(maps.Keys(jobs)))
+}
+
+// SetJobCaches stores the metric families of the given refresh jobs of cloud
+// under their own key, leaving the other jobs untouched, then the list of
+// jobs of data. Errors are logged.
+func (c *RedisCache) SetJobCaches(cloud string, data CloudCache, jobs []string) {
+	data.Time = time.Now()
+	ttl := strconv.FormatInt(c.ttl.Milliseconds(), 10)
+
+	jobCaches := make(map[string]CloudCache, len(jobs))
+	for _, job := range jobs {
+		jobCaches[job] = CloudCache{Time: data.Time, MetricFamilyCaches: make(map[string]*MetricFamilyCache), Services: make(map[string]ServiceCacheStatus)}
+	}
+	index := make(map[string]bool)
+	for key, mfCache := range data.MetricFamilyCaches {
+		job := mfCache.jobKey()
+		index[job] = true
+		if jobCache, ok := jobCaches[job]; ok {
+			jobCache.MetricFamilyCaches[key] = mfCache
+		}
+	}
+	for job, status := range data.Services {
+		index[job] = true
+		if jobCache, ok := jobCaches[job]; ok {
+			jobCache.Services[job] = status
+		}
+	}
+
+	for job, jobCache := range jobCaches {
+		value, err := marshalSnapshot(cloud, jobCache)
+		if err != nil {
+			c.logger.Error("Failed to encode cache", "cloud", cloud, "job", job, "err", err)
+			return
+		}
+		if _, err := c.client.do("SET", c.jobKey(cloud, job), string(value), "PX", ttl); err != nil {
+			c.logger.Error("Failed to store cache in redis", "cloud", cloud, "job", job, "err", err)
+			return
+		}
+	}
+
+	value, err := json.Marshal(slices.Sorted(maps.Keys(index)))
+	if err != nil {
+		c.logger.Error("Failed to encode cache", "cloud", cloud, "err", err)
+		return
+	}
+	if _, err := c.client.do("SET", c.jobsKey(cloud), string(value), "PX", ttl); err != nil {
+		c.logger.Error("Failed to store cache in redis", "cloud", cloud, "err", err)
+	}
+}
+
+// GetCloudCache reads the metric families of every refresh job of the last
+// collection of cloud still in the server. Errors are logged and reported as
+// a missing cache.
+func (c *RedisCache) GetCloudCache(cloud string) (CloudCache, bool) {
+	reply, err := c.client.do("GET", c.jobsKey(cloud))
+	if err != nil {
+		c.logger.Error("Failed to read cache from redis", "cloud", cloud, "err", err)
+		return CloudCache{}, false
+	}
+	index, ok := reply.([]byte)
+	if !ok {
+		return CloudCache{}, false
+	}
+
+	var jobs []string
+	if err := json.Unmarshal(index, &jobs); err != nil {
+		c.logger.Error("Invalid cache in redis", "cloud", cloud, "err", err)
+		return CloudCache{}, false
+	}
+	if len(jobs) == 0 {
+		return NewCloudCache(), true
+	}
+
+	args := []string{"MGET"}
+	for _, job := range jobs {
+		args = append(args, c.jobKey(cloud, job))
+	}
+	reply, err = c.client.do(args...)
+	if err != nil {
+		c.logger.Error("Failed to read cache from redis", "cloud", cloud, "err", err)
+		return CloudCache{}, false
+	}
+	values, _ := reply.([]any)
+
+	cloudCache := CloudCache{MetricFamilyCaches: make(map[string]*MetricFamilyCache), Services: make(map[string]ServiceCacheStatus)}
+	for i, value := range values {
+		data, ok := value.([]byte)
+		if !ok {
+			// Expired since the list of jobs was read.
+			continue
+		}
+		_, jobCache, err := unmarshalSnapshot(data)
+		if err != nil {
+			c.logger.Error("Invalid cache in redis", "cloud", cloud, "job", jobs[i], "err", err)
+			continue
+		}
+		if cloudCache.Time.IsZero() || jobCache.Time.Before(cloudCache.Time) {
+			cloudCache.Time = jobCache.Time
+		}
+		maps.Copy(cloudCache.MetricFamilyCaches, jobCache.MetricFamilyCaches)
+		maps.Copy(cloudCache.Services, jobCache.Services)
+	}
+	cloudCache.indexFamilies()
+
+	return cloudCache, true
+}
+
+// FlushExpiredCloudCaches does nothing, the server expires the entries.
+func (c *RedisCache) FlushExpiredCloudCaches(ttl time.Duration) {}
+
+// TryLockCollect takes the lock of the refresh job of cloud for ttl, or the
+// cache TTL when not positive, unless another exporter holds it. The lock
+// isn't released after the refresh, so the other exporters skip the job
+// until it expires.
+func (c *RedisCache) TryLockCollect(cloud, job string, ttl time.Duration) (bool, error) {
+	if ttl <= 0 {
+		ttl = c.ttl
+	}
+	reply, err := c.client.do("SET", c.lockKey(cloud, job), c.owner, "NX", "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return false, err
+	}
+	return reply == "OK", nil
+}
+
+// Close closes the connection to the server.
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
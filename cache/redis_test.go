@@ -0,0 +1,300 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openstack-exporter/openstack-exporter/exporters"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is an in-process stand-in of a Redis server, implementing the
+// commands used by RedisCache.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	f := &fakeRedis{
+		listener: listener,
+		password: password,
+		values:   make(map[string]string),
+		expires:  make(map[string]time.Time),
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeRedis) URL() string {
+	if f.password != "" {
+		return fmt.Sprintf("redis://:%s@%s/1", f.password, f.listener.Addr())
+	}
+	return "redis://" + f.listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authenticated := f.password == ""
+
+	for {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		var args []string
+		for _, arg := range reply.([]any) {
+			args = append(args, string(arg.([]byte)))
+		}
+
+		command := strings.ToUpper(args[0])
+		switch {
+		case command == "AUTH":
+			authenticated = args[len(args)-1] == f.password
+			if !authenticated {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			fmt.Fprint(conn, "+OK\r\n")
+		case !authenticated:
+			fmt.Fprint(conn, "-NOAUTH Authentication required.\r\n")
+		case command == "PING":
+			fmt.Fprint(conn, "+PONG\r\n")
+		case command == "SELECT":
+			fmt.Fprint(conn, "+OK\r\n")
+		case command == "SET":
+			fmt.Fprint(conn, f.set(args[1], args[2], args[3:]))
+		case command == "GET":
+			fmt.Fprint(conn, f.bulk(args[1]))
+		case command == "MGET":
+			fmt.Fprintf(conn, "*%d\r\n", len(args)-1)
+			for _, key := range args[1:] {
+				fmt.Fprint(conn, f.bulk(key))
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+func (f *fakeRedis) get(key string) (string, bool) {
+	if expires, ok := f.expires[key]; ok && time.Now().After(expires) {
+		delete(f.values, key)
+		delete(f.expires, key)
+	}
+	value, ok := f.values[key]
+	return value, ok
+}
+
+func (f *fakeRedis) bulk(key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, ok := f.get(key)
+	if !ok {
+		return "$-1\r\n"
+	}
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func (f *fakeRedis) set(key, value string, options []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var expires time.Time
+	for i := 0; i < len(options); i++ {
+		switch strings.ToUpper(options[i]) {
+		case "NX":
+			if _, ok := f.get(key); ok {
+				return "$-1\r\n"
+			}
+		case "PX":
+			i++
+			ms, _ := strconv.Atoi(options[i])
+			expires = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+	}
+
+	f.values[key] = value
+	delete(f.expires, key)
+	if !expires.IsZero() {
+		f.expires[key] = expires
+	}
+	return "+OK\r\n"
+}
+
+func TestRedisCacheSharedBetweenExporters(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+	logger := slog.New(slog.DiscardHandler)
+
+	writer, err := NewRedisCache(server.URL(), "test:", time.Minute, logger)
+	require.NoError(t, err)
+	defer writer.Close()
+	reader, err := NewRedisCache(server.URL(), "test:", time.Minute, logger)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	_, exists := reader.GetCloudCache("test.cloud")
+	assert.False(t, exists)
+
+	cloudCache := NewCloudCache()
+	cloudCache.SetMetricFamilyCache("compute/openstack_nova_up", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_nova_up", 1)})
+	cloudCache.SetMetricFamilyCache("network/openstack_neutron_up", MetricFamilyCache{Service: "network", MF: newTestMetricFamily("openstack_neutron_up", 0)})
+	writer.SetCloudCache("test.cloud", cloudCache)
+
+	stored, exists := reader.GetCloudCache("test.cloud")
+	require.True(t, exists)
+	assert.NotZero(t, stored.Time)
+	require.Len(t, stored.MetricFamilyCaches, 2)
+	assert.Equal(t, "network", stored.MetricFamilyCaches["network/openstack_neutron_up"].Service)
+	assert.Equal(t, 0.0, stored.MetricFamilyCaches["network/openstack_neutron_up"].MF.GetMetric()[0].GetGauge().GetValue())
+}
+
+func TestRedisCacheExpires(t *testing.T) {
+	server := newFakeRedis(t, "")
+
+	cache, err := NewRedisCache(server.URL(), "test:", 10*time.Millisecond, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	defer cache.Close()
+
+	cache.SetCloudCache("test.cloud", NewCloudCache())
+	_, exists := cache.GetCloudCache("test.cloud")
+	assert.True(t, exists)
+
+	time.Sleep(20 * time.Millisecond)
+	_, exists = cache.GetCloudCache("test.cloud")
+	assert.False(t, exists)
+}
+
+func TestRedisCacheRejectsWrongPassword(t *testing.T) {
+	server := newFakeRedis(t, "secret")
+
+	_, err := NewRedisCache(strings.Replace(server.URL(), "secret", "wrong", 1), "test:", time.Minute, slog.New(slog.DiscardHandler))
+	assert.ErrorContains(t, err, "WRONGPASS")
+}
+
+func TestRedisCacheCollectLock(t *testing.T) {
+	server := newFakeRedis(t, "")
+	logger := slog.New(slog.DiscardHandler)
+
+	first, err := NewRedisCache(server.URL(), "test:", time.Minute, logger)
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := NewRedisCache(server.URL(), "test:", time.Minute, logger)
+	require.NoError(t, err)
+	defer second.Close()
+
+	locked, err := first.TryLockCollect("test.cloud", "compute", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, locked)
+
+	locked, err = second.TryLockCollect("test.cloud", "compute", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, locked, "only one exporter refreshes a job per interval")
+
+	locked, err = second.TryLockCollect("test.cloud", "nova-agent_state", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, locked)
+
+	locked, err = second.TryLockCollect("other.cloud", "compute", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, locked)
+
+	time.Sleep(60 * time.Millisecond)
+	locked, err = second.TryLockCollect("test.cloud", "compute", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, locked, "the lock is released after the interval")
+}
+
+func TestCollectCacheSkipsLockedClouds(t *testing.T) {
+	server := newFakeRedis(t, "")
+	logger := slog.New(slog.DiscardHandler)
+
+	other, err := NewRedisCache(server.URL(), "test:", time.Minute, logger)
+	require.NoError(t, err)
+	defer other.Close()
+	cache, err := NewRedisCache(server.URL(), "test:", time.Minute, logger)
+	require.NoError(t, err)
+	defer cache.Close()
+
+	SetCache(cache)
+	defer newSingleCache()
+
+	locked, err := other.TryLockCollect("testCloud", "service-a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, locked)
+
+	builds := 0
+	enableExporter := func(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error) {
+		builds++
+		return mockEnableExporter(service, cloud, opts, logger)
+	}
+	services := func(string) []string { return []string{"service-a"} }
+	options := func(string) exporters.Options { return exporters.Options{} }
+
+	require.NoError(t, CollectCache(enableExporter, false, services, "testCloud", options, logger))
+	assert.Equal(t, 0, builds, "the cloud is collected by the exporter holding the lock")
+}
+
+func TestRedisCacheSetJobCaches(t *testing.T) {
+	server := newFakeRedis(t, "")
+	logger := slog.New(slog.DiscardHandler)
+
+	first, err := NewRedisCache(server.URL(), "test:", time.Minute, logger)
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := NewRedisCache(server.URL(), "test:", time.Minute, logger)
+	require.NoError(t, err)
+	defer second.Close()
+
+	// Both exporters read the cache, then each one refreshes its own job.
+	cloudCache := NewCloudCache()
+	cloudCache.SetMetricFamilyCache("compute/openstack_nova_up", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_nova_up", 1)})
+	cloudCache.SetMetricFamilyCache("nova-agent_state/openstack_nova_agent_state", MetricFamilyCache{Service: "compute", Job: "nova-agent_state", MF: newTestMetricFamily("openstack_nova_agent_state", 1)})
+	first.SetCloudCache("test.cloud", cloudCache)
+
+	fromFirst := NewCloudCache()
+	fromFirst.SetMetricFamilyCache("compute/openstack_nova_up", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_nova_up", 0)})
+	fromFirst.SetMetricFamilyCache("nova-agent_state/openstack_nova_agent_state", MetricFamilyCache{Service: "compute", Job: "nova-agent_state", MF: newTestMetricFamily("openstack_nova_agent_state", 1)})
+	fromSecond := NewCloudCache()
+	fromSecond.SetMetricFamilyCache("compute/openstack_nova_up", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_nova_up", 1)})
+	fromSecond.SetMetricFamilyCache("nova-agent_state/openstack_nova_agent_state", MetricFamilyCache{Service: "compute", Job: "nova-agent_state", MF: newTestMetricFamily("openstack_nova_agent_state", 2)})
+
+	first.SetJobCaches("test.cloud", fromFirst, []string{"compute"})
+	second.SetJobCaches("test.cloud", fromSecond, []string{"nova-agent_state"})
+
+	stored, exists := first.GetCloudCache("test.cloud")
+	require.True(t, exists)
+	require.Len(t, stored.MetricFamilyCaches, 2)
+	assert.Equal(t, 0.0, stored.MetricFamilyCaches["compute/openstack_nova_up"].MF.GetMetric()[0].GetGauge().GetValue())
+	agentState := stored.MetricFamilyCaches["nova-agent_state/openstack_nova_agent_state"]
+	assert.Equal(t, "nova-agent_state", agentState.Job)
+	assert.Equal(t, 2.0, agentState.MF.GetMetric()[0].GetGauge().GetValue())
+}
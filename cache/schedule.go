@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/openstack-exporter/openstack-exporter/exporters"
+	"golang.org/x/sync/errgroup"
+)
+
+// RefreshSchedule holds the refresh intervals of the cache. Services are keyed
+// by exporter service name (i.e: compute) and metrics use the same
+// service-metric format as --disable-metric (i.e: nova-agent_state). A metric
+// with its own interval is refreshed separately from the rest of its service.
+type RefreshSchedule struct {
+	Default  time.Duration
+	Services map[string]time.Duration
+	Metrics  map[string]time.Duration
+	// Jitter delays every refresh by a random duration up to this fraction of
+	// its interval, so the jobs sharing an interval don't hit the APIs at once.
+	Jitter float64
+}
+
+var refreshSchedule RefreshSchedule
+
+// SetRefreshSchedule sets the RefreshSchedule of CollectCache and
+// RunRefreshSchedule.
+func SetRefreshSchedule(schedule RefreshSchedule) {
+	refreshSchedule = schedule
+}
+
+// cacheJob is a part of the collection of a service refreshed on its own
+// schedule: a metric with its own interval, or the rest of the service.
+type cacheJob struct {
+	service string
+	// metric is empty for the job of the service.
+	metric string
+}
+
+// key identifies the job in CloudCache.Services.
+func (j cacheJob) key() string {
+	if j.metric != "" {
+		return j.metric
+	}
+	return j.service
+}
+
+// jobService returns the service of a job key.
+func jobService(job string) string {
+	if exporters.ExporterName(job) != "" {
+		return job
+	}
+	if service, ok := exporters.MetricService(job); ok {
+		return service
+	}
+	return job
+}
+
+// jobs returns the refresh jobs of service: its own job, followed by the ones
+// of its metrics with their own interval.
+func (s RefreshSchedule) jobs(service string) []cacheJob {
+	jobs := []cacheJob{{service: service}}
+	for _, metric := range s.metrics(service) {
+		jobs = append(jobs, cacheJob{service: service, metric: metric})
+	}
+	return jobs
+}
+
+// metrics returns the metrics of service with their own interval.
+func (s RefreshSchedule) metrics(service string) []string {
+	var metrics []string
+	for metric := range s.Metrics {
+		if metricService, ok := exporters.MetricService(metric); ok && metricService == service {
+			metrics = append(metrics, metric)
+		}
+	}
+	slices.Sort(metrics)
+	return metrics
+}
+
+func (s RefreshSchedule) interval(job cacheJob) time.Duration {
+	if interval, ok := s.Metrics[job.metric]; ok {
+		return interval
+	}
+	if interval, ok := s.Services[job.service]; ok {
+		return interval
+	}
+	return s.Default
+}
+
+// collectMetric returns the exporters.Options.CollectMetric of job.
+func (s RefreshSchedule) collectMetric(job cacheJob) func(metric string) bool {
+	if job.metric != "" {
+		return func(metric string) bool { return metric == job.metric }
+	}
+	if len(s.metrics(job.service)) == 0 {
+		return nil
+	}
+	return func(metric string) bool {
+		_, ok := s.Metrics[metric]
+		return !ok
+	}
+}
+
+// delay returns the time until the next refresh of a job refreshed every
+// interval.
+func (s RefreshSchedule) delay(interval time.Duration) time.Duration {
+	jitter := time.Duration(s.Jitter * float64(interval))
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + rand.N(jitter)
+}
+
+// RunRefreshSchedule refreshes every job of the supported services on its own
+// schedule until ctx is done, on the clouds where the service is enabled. The
+// cache is expected to be collected once by CollectCache beforehand. Failures
+// are logged and retried on the next refresh.
+func RunRefreshSchedule(
+	ctx context.Context,
+	enableExporterFunc EnableExporterFunc,
+	multiCloud bool,
+	services ServicesFunc,
+	cloud string,
+	options OptionsFunc,
+	logger *slog.Logger,
+) {
+	schedule := refreshSchedule
+	var wg sync.WaitGroup
+
+	for _, service := range exporters.SupportedExporters {
+		for _, job := range schedule.jobs(service) {
+			interval := schedule.interval(job)
+			logger.Debug("Scheduling cache refresh", "job", job.key(), "interval", interval)
+
+			wg.Go(func() {
+				timer := time.NewTimer(schedule.delay(interval))
+				defer timer.Stop()
+
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-timer.C:
+					}
+
+					if err := refreshJob(enableExporterFunc, multiCloud, services, cloud, options, job, logger); err != nil {
+						logger.Error("Failed to load the clouds, refreshing the clouds loaded last", "job", job.key(), "err", err)
+					}
+					timer.Reset(schedule.delay(interval))
+				}
+			})
+		}
+	}
+
+	wg.Wait()
+}
+
+// refreshJob refreshes job concurrently on the clouds where its service is
+// enabled. When clouds.yaml can't be loaded, the clouds loaded last are
+// refreshed and the error is returned.
+func refreshJob(
+	enableExporterFunc EnableExporterFunc,
+	multiCloud bool,
+	services ServicesFunc,
+	cloud string,
+	options OptionsFunc,
+	job cacheJob,
+	logger *slog.Logger,
+) error {
+	clouds, err := Clouds(multiCloud, cloud)
+
+	var g errgroup.Group
+	g.SetLimit(concurrencyLimit(cloudConcurrency))
+	for _, cloud := range clouds {
+		g.Go(func() error {
+			enabledServices := services(cloud)
+			if !slices.Contains(enabledServices, job.service) {
+				return nil
+			}
+			logger.Debug("Run refresh cache job", "cloud", cloud, "job", job.key())
+			refreshCloud(enableExporterFunc, cloud, []cacheJob{job}, enabledServices, options(cloud), false, logger)
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	return err
+}
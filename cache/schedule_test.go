@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openstack-exporter/openstack-exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scheduledExporter is a mock of the compute exporter, collecting the
+// flavors and agent_state metrics accepted by collectMetric.
+type scheduledExporter struct {
+	collectMetric func(metric string) bool
+}
+
+func (e *scheduledExporter) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(e, ch)
+}
+
+func (e *scheduledExporter) Collect(ch chan<- prometheus.Metric) {
+	success := prometheus.NewDesc("openstack_exporter_collector_success", "success", []string{"metric"}, nil)
+	for _, metric := range []string{"flavors", "agent_state"} {
+		if e.collectMetric != nil && !e.collectMetric("nova-"+metric) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("openstack_nova_"+metric, metric, nil, nil), prometheus.GaugeValue, 1)
+		ch <- prometheus.MustNewConstMetric(success, prometheus.GaugeValue, 1, metric)
+	}
+	ch <- prometheus.MustNewConstMetric(prometheus.NewDesc("openstack_nova_up", "up", nil, nil), prometheus.GaugeValue, 1)
+}
+
+func (e *scheduledExporter) GetName() string {
+	return "openstack_nova"
+}
+
+func (e *scheduledExporter) AddMetric(name string, fn exporters.ListFunc, labels []string, deprecatedVersion string, constLabels prometheus.Labels) {
+}
+
+func (e *scheduledExporter) MetricIsDisabled(name string) bool {
+	return false
+}
+
+func enableScheduledExporter(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error) {
+	var exporter exporters.OpenStackExporter = &scheduledExporter{collectMetric: opts.CollectMetric}
+	return &exporter, nil
+}
+
+func TestRefreshScheduleJobs(t *testing.T) {
+	schedule := RefreshSchedule{
+		Default:  time.Minute,
+		Services: map[string]time.Duration{"volume": 5 * time.Minute},
+		Metrics:  map[string]time.Duration{"nova-agent_state": 30 * time.Second},
+	}
+
+	assert.Equal(t, []cacheJob{{service: "compute"}, {service: "compute", metric: "nova-agent_state"}}, schedule.jobs("compute"))
+	assert.Equal(t, []cacheJob{{service: "volume"}}, schedule.jobs("volume"))
+
+	assert.Equal(t, time.Minute, schedule.interval(cacheJob{service: "compute"}))
+	assert.Equal(t, 30*time.Second, schedule.interval(cacheJob{service: "compute", metric: "nova-agent_state"}))
+	assert.Equal(t, 5*time.Minute, schedule.interval(cacheJob{service: "volume"}))
+
+	assert.Nil(t, schedule.collectMetric(cacheJob{service: "volume"}))
+	serviceJob := schedule.collectMetric(cacheJob{service: "compute"})
+	assert.True(t, serviceJob("nova-flavors"))
+	assert.False(t, serviceJob("nova-agent_state"))
+	metricJob := schedule.collectMetric(cacheJob{service: "compute", metric: "nova-agent_state"})
+	assert.False(t, metricJob("nova-flavors"))
+	assert.True(t, metricJob("nova-agent_state"))
+
+	assert.Equal(t, "compute", jobService("compute"))
+	assert.Equal(t, "compute", jobService("nova-agent_state"))
+	assert.Equal(t, "load-balancer", jobService("load-balancer"))
+
+	schedule.Jitter = 0.5
+	for range 100 {
+		delay := schedule.delay(time.Minute)
+		assert.GreaterOrEqual(t, delay, time.Minute)
+		assert.Less(t, delay, 90*time.Second)
+	}
+}
+
+func TestCollectCacheMergesRefreshJobs(t *testing.T) {
+	cache := GetCache()
+	defer newSingleCache()
+	SetRefreshSchedule(RefreshSchedule{Metrics: map[string]time.Duration{"nova-agent_state": time.Second}})
+	defer SetRefreshSchedule(RefreshSchedule{})
+	SetMaxStaleness(time.Hour)
+	defer SetMaxStaleness(0)
+	logger := slog.New(slog.DiscardHandler)
+
+	cloud := "testCloud"
+	services := func(string) []string { return []string{"compute"} }
+	options := func(string) exporters.Options { return exporters.Options{Prefix: "openstack"} }
+
+	require.NoError(t, CollectCache(enableScheduledExporter, false, services, cloud, options, logger))
+	collected, _ := cache.GetCloudCache(cloud)
+	assert.Contains(t, collected.MetricFamilyCaches, "compute/openstack_nova_flavors")
+	assert.Contains(t, collected.MetricFamilyCaches, "nova-agent_state/openstack_nova_agent_state")
+	assert.NotContains(t, collected.MetricFamilyCaches, "nova-agent_state/openstack_nova_up", "the up metric comes from the service job")
+	assert.NotContains(t, collected.MetricFamilyCaches, "compute/openstack_nova_agent_state")
+	assert.True(t, collected.Services["compute"].Success)
+	assert.True(t, collected.Services["nova-agent_state"].Success)
+
+	// A failed refresh of the metric leaves the rest of the service untouched.
+	failingExporter := func(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error) {
+		return nil, errors.New("service unavailable")
+	}
+	require.NoError(t, refreshJob(failingExporter, false, services, cloud, options, cacheJob{service: "compute", metric: "nova-agent_state"}, logger))
+	refreshed, _ := cache.GetCloudCache(cloud)
+	assert.Equal(t, collected.MetricFamilyCaches, refreshed.MetricFamilyCaches)
+	assert.True(t, refreshed.Services["compute"].Success)
+	assert.False(t, refreshed.Services["nova-agent_state"].Success)
+
+	buf, err := BufferFromCache(cloud, "openstack", []string{"compute"}, logger)
+	require.NoError(t, err)
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	metricFamilies, err := parser.TextToMetricFamilies(&buf)
+	require.NoError(t, err)
+	assert.Len(t, metricFamilies["openstack_nova_up"].GetMetric(), 1)
+	assert.Len(t, metricFamilies["openstack_nova_agent_state"].GetMetric(), 1)
+	assert.Len(t, metricFamilies["openstack_exporter_collector_success"].GetMetric(), 2, "the samples of every job are merged")
+	require.Len(t, metricFamilies["openstack_exporter_cache_refresh_success"].GetMetric(), 1)
+	assert.Equal(t, 0.0, metricFamilies["openstack_exporter_cache_refresh_success"].GetMetric()[0].GetGauge().GetValue(), "a service fails when any of its jobs does")
+
+	// Metrics no longer refreshed on their own schedule are dropped.
+	SetRefreshSchedule(RefreshSchedule{})
+	require.NoError(t, refreshJob(enableScheduledExporter, false, services, cloud, options, cacheJob{service: "compute"}, logger))
+	unscheduled, _ := cache.GetCloudCache(cloud)
+	assert.NotContains(t, unscheduled.MetricFamilyCaches, "nova-agent_state/openstack_nova_agent_state")
+	assert.Contains(t, unscheduled.MetricFamilyCaches, "compute/openstack_nova_agent_state")
+	assert.NotContains(t, unscheduled.Services, "nova-agent_state")
+}
+
+func TestRunRefreshSchedule(t *testing.T) {
+	GetCache()
+	defer newSingleCache()
+	SetRefreshSchedule(RefreshSchedule{
+		Default: time.Hour,
+		Metrics: map[string]time.Duration{"nova-agent_state": 10 * time.Millisecond},
+		Jitter:  0.1,
+	})
+	defer SetRefreshSchedule(RefreshSchedule{})
+
+	var mu sync.Mutex
+	refreshes := map[string]int{}
+	enableExporter := func(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		job := service
+		if opts.CollectMetric != nil && !opts.CollectMetric("nova-flavors") {
+			job = "nova-agent_state"
+		}
+		refreshes[job]++
+		return enableScheduledExporter(service, cloud, opts, logger)
+	}
+	services := func(string) []string { return []string{"compute"} }
+	options := func(string) exporters.Options { return exporters.Options{Prefix: "openstack"} }
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	RunRefreshSchedule(ctx, enableExporter, false, services, "testCloud", options, slog.New(slog.DiscardHandler))
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.GreaterOrEqual(t, refreshes["nova-agent_state"], 2, "the metric is refreshed on its own schedule")
+	assert.Zero(t, refreshes["compute"], "the service isn't due yet")
+}
@@ -4,123 +4,360 @@ package cache
 
 import (
 	"bytes"
+	"compress/gzip"
 	"log/slog"
+	"maps"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gophercloud/utils/v2/openstack/clientconfig"
 	"github.com/openstack-exporter/openstack-exporter/exporters"
-	"github.com/openstack-exporter/openstack-exporter/utils"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/proto"
 )
 
+// CollectObserver is notified once the collection of a cloud has finished.
+type CollectObserver func(cloud string, duration time.Duration, failed bool)
+
+var collectObserver CollectObserver
+
+// SetCollectObserver sets the CollectObserver notified by CollectCache.
+func SetCollectObserver(observer CollectObserver) {
+	collectObserver = observer
+}
+
+var maxStaleness time.Duration
+
+// SetMaxStaleness sets how long CollectCache keeps serving the metric
+// families of a service whose refresh fails. Zero drops them on the first
+// failed refresh.
+func SetMaxStaleness(staleness time.Duration) {
+	maxStaleness = staleness
+}
+
+var (
+	cloudConcurrency int
+	jobConcurrency   int
+)
+
+// SetConcurrency sets how many clouds, and how many refresh jobs of a cloud,
+// are collected at once. Zero or less means no limit.
+func SetConcurrency(clouds, jobs int) {
+	cloudConcurrency = clouds
+	jobConcurrency = jobs
+}
+
+// concurrencyLimit returns the errgroup limit of a concurrency setting.
+func concurrencyLimit(n int) int {
+	if n <= 0 {
+		return -1
+	}
+	return n
+}
+
+// EnableExporterFunc builds the exporter of a service for a cloud.
+type EnableExporterFunc func(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error)
+
+// OptionsFunc returns the Options the exporters of cloud are built with.
+type OptionsFunc func(cloud string) exporters.Options
+
+// ServicesFunc returns the services collected for cloud.
+type ServicesFunc func(cloud string) []string
+
 // CollectCache collects the MetricsFamily for required clouds and services and stores in the cache.
+// Every refresh job of the services is collected, whatever its schedule. Clouds
+// are collected concurrently and a cloud failing to collect doesn't affect the
+// other ones. When clouds.yaml can't be loaded, the clouds loaded last are
+// collected and the error is returned.
 func CollectCache(
-	enableExporterFunc func(
-		string, string, string, []string, string, bool, bool, bool, bool, string, string, *utils.LabelMappingFlag, int, func() (string, error), *slog.Logger,
-	) (*exporters.OpenStackExporter, error),
+	enableExporterFunc EnableExporterFunc,
 	multiCloud bool,
-	services []string, prefix,
+	services ServicesFunc,
 	cloud string,
-	disabledMetrics []string,
-	endpointType string,
-	collectTime bool,
-	disableSlowMetrics bool,
-	disableDeprecatedMetrics bool,
-	disableCinderAgentUUID bool,
-	domainID string,
-	tenantID string,
-	novaMetadataMapping *utils.LabelMappingFlag,
-	dnsConcurrentCount int,
-	uuidGenFunc func() (string, error),
+	options OptionsFunc,
 	logger *slog.Logger,
 ) error {
 	logger.Info("Run collect cache job")
-	cacheBackend := GetCache()
 
-	clouds := []string{}
+	clouds, err := Clouds(multiCloud, cloud)
+	collectClouds(enableExporterFunc, clouds, services, options, false, logger)
+	collected.Store(true)
 
-	if multiCloud {
-		cloudsConfig, err := clientconfig.LoadCloudsYAML()
-		if err != nil {
-			return err
-		}
+	return err
+}
+
+// collected is set once a CollectCache has completed.
+var collected atomic.Bool
+
+// Collected reports whether a CollectCache has completed, successfully or
+// not.
+func Collected() bool {
+	return collected.Load()
+}
+
+// RefreshCache collects every refresh job of the services of clouds right
+// away, even when another exporter sharing the cache holds their lock.
+func RefreshCache(enableExporterFunc EnableExporterFunc, clouds []string, services ServicesFunc, options OptionsFunc, logger *slog.Logger) {
+	logger.Info("Run cache refresh", "clouds", clouds)
+	collectClouds(enableExporterFunc, clouds, services, options, true, logger)
+}
+
+// collectClouds collects every refresh job of the services of clouds
+// concurrently, skipping the jobs locked by another exporter unless force is
+// set.
+func collectClouds(enableExporterFunc EnableExporterFunc, clouds []string, services ServicesFunc, options OptionsFunc, force bool, logger *slog.Logger) {
+	var g errgroup.Group
+	g.SetLimit(concurrencyLimit(cloudConcurrency))
+	for _, cloud := range clouds {
+		g.Go(func() error {
+			enabledServices := services(cloud)
+			var jobs []cacheJob
+			for _, service := range enabledServices {
+				jobs = append(jobs, refreshSchedule.jobs(service)...)
+			}
+			refreshCloud(enableExporterFunc, cloud, jobs, enabledServices, options(cloud), force, logger)
+			return nil
+		})
+	}
+	_ = g.Wait()
+}
 
-		for cloud := range cloudsConfig {
-			clouds = append(clouds, cloud)
+var (
+	lastCloudsMu sync.Mutex
+	lastClouds   []string
+)
+
+// Clouds returns the clouds collected in cache mode: the clouds of clouds.yaml
+// in multi-cloud mode, otherwise cloud. When clouds.yaml can't be loaded, the
+// clouds loaded last are returned with the error.
+func Clouds(multiCloud bool, cloud string) ([]string, error) {
+	if !multiCloud {
+		if cloud == "" {
+			return nil, nil
 		}
+		return []string{cloud}, nil
 	}
-	if cloud != "" && !multiCloud {
+
+	lastCloudsMu.Lock()
+	defer lastCloudsMu.Unlock()
+
+	cloudsConfig, err := clientconfig.LoadCloudsYAML()
+	if err != nil {
+		return lastClouds, err
+	}
+
+	clouds := []string{}
+	for cloud := range cloudsConfig {
 		clouds = append(clouds, cloud)
 	}
+	lastClouds = clouds
 
-	for _, cloud := range clouds {
-		lg := logger.With("cloud", cloud)
-		lg.Info("Start update cache data")
-		// Update cloud's cache once finish all exporters' collection job. so we won't mix the old
-		// and new metrics in the cache and confuse users.
-		cloudCache := NewCloudCache()
-
-		for _, service := range services {
-			lg2 := lg.With("service", service)
+	return clouds, nil
+}
+
+// mergeLocks holds a *sync.Mutex per cloud, serializing the merges of
+// refresh jobs into its CloudCache.
+var mergeLocks sync.Map
+
+// refreshCloud collects the refresh jobs of cloud, then merges their metric
+// families with the ones of the other jobs of enabledServices in its
+// CloudCache. Jobs failing to refresh keep their last-known-good metric
+// families. Unless force is set, the jobs locked by another exporter are
+// skipped.
+func refreshCloud(
+	enableExporterFunc EnableExporterFunc,
+	cloud string,
+	jobs []cacheJob,
+	enabledServices []string,
+	opts exporters.Options,
+	force bool,
+	logger *slog.Logger,
+) {
+	cacheBackend := GetCache()
+	lg := logger.With("cloud", cloud)
+
+	if locker, ok := cacheBackend.(CollectLocker); ok && !force {
+		jobs = slices.DeleteFunc(slices.Clone(jobs), func(job cacheJob) bool {
+			// The lock expires a bit before the next refresh of the job, so
+			// the exporter holding it can take it again.
+			interval := refreshSchedule.interval(job)
+			locked, err := locker.TryLockCollect(cloud, job.key(), interval-interval/10)
+			if err != nil {
+				lg.Error("Failed to take the collection lock", "job", job.key(), "error", err)
+				return true
+			}
+			if !locked {
+				lg.Info("Job refreshed by another exporter, skipping", "job", job.key())
+			}
+			return !locked
+		})
+	}
+	if len(jobs) == 0 {
+		return
+	}
+
+	lg.Info("Start update cache data")
+	start := time.Now()
+	failed := false
+	// Update cloud's cache once finish all exporters' collection job. so we won't mix the old
+	// and new metrics in the cache and confuse users.
+	collected := NewCloudCache()
+	var failedJobs []cacheJob
+	var mu sync.Mutex
+	jobFailed := func(job cacheJob) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = true
+		failedJobs = append(failedJobs, job)
+	}
+
+	var g errgroup.Group
+	g.SetLimit(concurrencyLimit(jobConcurrency))
+	for _, job := range jobs {
+		g.Go(func() error {
+			lg2 := lg.With("service", job.service)
+			if job.metric != "" {
+				lg2 = lg2.With("metric", job.metric)
+			}
 			lg2.Info("Start collect cache data")
 
-			exp, err := enableExporterFunc(service, prefix, cloud, disabledMetrics, endpointType, collectTime, disableSlowMetrics, disableDeprecatedMetrics, disableCinderAgentUUID, domainID, tenantID, novaMetadataMapping, dnsConcurrentCount, uuidGenFunc, logger)
+			jobOpts := opts
+			jobOpts.CollectMetric = refreshSchedule.collectMetric(job)
+			exp, err := enableExporterFunc(job.service, cloud, jobOpts, logger)
 			if err != nil {
 				// Log error and continue with enabling other exporters
 				lg2.Error("enabling exporter for service failed", "error", err)
-				continue
+				jobFailed(job)
+				return nil
 			}
 
 			registry := prometheus.NewPedanticRegistry()
-			registry.MustRegister(*exp)
+			if err := registry.Register(*exp); err != nil {
+				lg2.Error("Registering exporter failed", "error", err)
+				jobFailed(job)
+				return nil
+			}
 
 			metricFamilies, err := registry.Gather()
 			if err != nil {
 				lg2.Error("Create gather failed", "error", err)
-				continue
+				jobFailed(job)
+				return nil
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if exporters.CollectorFailures(opts.Prefix, metricFamilies) > 0 {
+				failed = true
 			}
 
+			// The up metric of a service is reported by its service job only.
+			up := prometheus.BuildFQName(opts.Prefix, exporters.ExporterName(job.service), "up")
 			for _, mf := range metricFamilies {
-				cloudCache.SetMetricFamilyCache(
-					*mf.Name,
+				if job.metric != "" && mf.GetName() == up {
+					continue
+				}
+				collected.SetMetricFamilyCache(
+					metricFamilyKey(job.key(), mf.GetName()),
 					MetricFamilyCache{
-						Service: service,
+						Service: job.service,
+						Job:     job.metric,
 						MF:      mf,
 					},
 				)
 				lg2.Debug("Update cache data", "MetricsFamily", mf.Name)
 			}
+			collected.SetServiceStatus(job.key(), ServiceCacheStatus{Time: time.Now(), Success: true})
 
 			lg2.Info("Finish update cache data")
+			return nil
+		})
+	}
+	_ = g.Wait()
+
+	mergeLock, _ := mergeLocks.LoadOrStore(cloud, &sync.Mutex{})
+	mergeLock.(*sync.Mutex).Lock()
+	defer mergeLock.(*sync.Mutex).Unlock()
+
+	previous, _ := cacheBackend.GetCloudCache(cloud)
+	cloudCache := NewCloudCache()
+
+	// Keep the other jobs of the enabled services.
+	current := make(map[string]bool)
+	for _, service := range enabledServices {
+		for _, job := range refreshSchedule.jobs(service) {
+			current[job.key()] = true
 		}
+	}
+	for _, job := range jobs {
+		delete(current, job.key())
+	}
+	for key, mfCache := range previous.MetricFamilyCaches {
+		if current[mfCache.jobKey()] {
+			cloudCache.MetricFamilyCaches[key] = mfCache
+		}
+	}
+	for job, status := range previous.Services {
+		if current[job] {
+			cloudCache.SetServiceStatus(job, status)
+		}
+	}
 
-		cacheBackend.SetCloudCache(cloud, cloudCache)
+	for _, job := range failedJobs {
+		if cloudCache.keepJob(previous, job.key(), maxStaleness) {
+			lg.Warn("Serving the previous cache data of the job", "job", job.key(), "collected_at", cloudCache.Services[job.key()].Time)
+		}
 	}
+	maps.Copy(cloudCache.MetricFamilyCaches, collected.MetricFamilyCaches)
+	maps.Copy(cloudCache.Services, collected.Services)
 
-	return nil
+	if writer, ok := cacheBackend.(JobCacheWriter); ok {
+		keys := make([]string, 0, len(jobs))
+		for _, job := range jobs {
+			keys = append(keys, job.key())
+		}
+		writer.SetJobCaches(cloud, cloudCache, keys)
+	} else {
+		cacheBackend.SetCloudCache(cloud, cloudCache)
+	}
+	if collectObserver != nil {
+		collectObserver(cloud, time.Since(start), failed)
+	}
 }
 
-// BufferFromCache reads cloud's MetricsFamily data from cache and writes into a buffer.
-func BufferFromCache(cloud string, services []string, logger *slog.Logger) (bytes.Buffer, error) {
+// MetricFamiliesFromCache reads cloud's MetricsFamily data of services from cache, sorted by
+// name, followed by the age and refresh status of the services, prefixed by prefix.
+func MetricFamiliesFromCache(cloud, prefix string, services []string, logger *slog.Logger) []*dto.MetricFamily {
 	cacheBackend := GetCache()
-	var buf bytes.Buffer
 
 	cloudCache, exists := cacheBackend.GetCloudCache(cloud)
 	if !exists {
 		logger.Debug("Cache not exists", "cloud", cloud)
-		return buf, nil
+		return nil
+	}
+	if cloudCache.Stale {
+		logger.Debug("Serving cache loaded from disk until the next collection", "cloud", cloud, "time", cloudCache.Time)
 	}
 
-	for _, mfCache := range cloudCache.MetricFamilyCaches {
-		if !slices.Contains(services, mfCache.Service) {
-			continue
-		}
+	mfs := cloudCache.metricFamilies(services)
+	return append(mfs, serviceStatusMetricFamilies(cloud, prefix, services, cloudCache)...)
+}
+
+// BufferFromCache reads cloud's MetricsFamily data from cache and writes into a buffer,
+// followed by the age and refresh status of the services, prefixed by prefix.
+func BufferFromCache(cloud, prefix string, services []string, logger *slog.Logger) (bytes.Buffer, error) {
+	var buf bytes.Buffer
 
-		if _, err := expfmt.MetricFamilyToText(&buf, mfCache.MF); err != nil {
+	for _, mf := range MetricFamiliesFromCache(cloud, prefix, services, logger) {
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
 			return buf, err
 		}
 	}
@@ -128,35 +365,147 @@ func BufferFromCache(cloud string, services []string, logger *slog.Logger) (byte
 	return buf, nil
 }
 
+// serviceStatusMetricFamilies returns the cache age and refresh status of the
+// given services of cloudCache.
+func serviceStatusMetricFamilies(cloud, prefix string, services []string, cloudCache CloudCache) []*dto.MetricFamily {
+	statuses := serviceStatuses(cloudCache)
+	age := &dto.MetricFamily{
+		Name: proto.String(prometheus.BuildFQName(prefix, "exporter", "cache_age_seconds")),
+		Help: proto.String("Time since the collection of the cached metrics of the service"),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	success := &dto.MetricFamily{
+		Name: proto.String(prometheus.BuildFQName(prefix, "exporter", "cache_refresh_success")),
+		Help: proto.String("Whether the last refresh of the cached metrics of the service succeeded"),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+
+	for _, service := range slices.Sorted(maps.Keys(statuses)) {
+		if !slices.Contains(services, service) {
+			continue
+		}
+		status := statuses[service]
+		labels := []*dto.LabelPair{
+			{Name: proto.String("cloud"), Value: proto.String(cloud)},
+			{Name: proto.String("service"), Value: proto.String(service)},
+		}
+
+		successValue := 0.0
+		if status.Success {
+			successValue = 1
+		}
+		success.Metric = append(success.Metric, &dto.Metric{Label: labels, Gauge: &dto.Gauge{Value: proto.Float64(successValue)}})
+		if !status.Time.IsZero() {
+			age.Metric = append(age.Metric, &dto.Metric{Label: labels, Gauge: &dto.Gauge{Value: proto.Float64(time.Since(status.Time).Seconds())}})
+		}
+	}
+
+	var mfs []*dto.MetricFamily
+	for _, mf := range []*dto.MetricFamily{age, success} {
+		if len(mf.Metric) > 0 {
+			mfs = append(mfs, mf)
+		}
+	}
+	return mfs
+}
+
+// serviceStatuses aggregates the status of the refresh jobs of cloudCache by
+// service: the age of a service is the one of its oldest job, and its refresh
+// succeeded when the ones of all its jobs did.
+func serviceStatuses(cloudCache CloudCache) map[string]ServiceCacheStatus {
+	statuses := make(map[string]ServiceCacheStatus)
+	for job, status := range cloudCache.Services {
+		service := jobService(job)
+		aggregated, ok := statuses[service]
+		if !ok {
+			statuses[service] = status
+			continue
+		}
+		if aggregated.Time.IsZero() || !status.Time.IsZero() && status.Time.Before(aggregated.Time) {
+			aggregated.Time = status.Time
+		}
+		aggregated.Success = aggregated.Success && status.Success
+		statuses[service] = aggregated
+	}
+	return statuses
+}
+
 // FlushExpiredCloudCaches flush expired caches based on cloud's update time
 func FlushExpiredCloudCaches(ttl time.Duration) {
 	cacheBackend := GetCache()
 	cacheBackend.FlushExpiredCloudCaches(ttl)
 }
 
-// WriteCacheToResponse read cache and write to the connection as part of an HTTP reply.
-func WriteCacheToResponse(w http.ResponseWriter, r *http.Request, cloud string, enabledServices []string, logger *slog.Logger) error {
-	buf, err := BufferFromCache(cloud, enabledServices, logger)
-	if err != nil {
-		http.Error(w, "Failed to encode metrics", http.StatusInternalServerError)
-		return err
+// WriteCacheToResponse read cache and write to the connection as part of an HTTP reply,
+// followed by the metrics of gatherers, such as the exporter's own ones, encoded in the
+// format negotiated with the client and gzipped when it accepts it.
+func WriteCacheToResponse(w http.ResponseWriter, r *http.Request, cloud, prefix string, enabledServices []string, logger *slog.Logger, gatherers ...prometheus.Gatherer) error {
+	mfs := MetricFamiliesFromCache(cloud, prefix, enabledServices, logger)
+	for _, gatherer := range gatherers {
+		gathered, err := gatherer.Gather()
+		if err != nil {
+			http.Error(w, "Failed to gather metrics", http.StatusInternalServerError)
+			return err
+		}
+		mfs = append(mfs, gathered...)
 	}
 
-	opts := promhttp.HandlerOpts{}
+	contentType := expfmt.NegotiateIncludingOpenMetrics(r.Header)
 
-	// Follow the way how promehttp package set up the contentType
-	var contentType expfmt.Format
-	if opts.EnableOpenMetrics {
-		contentType = expfmt.NegotiateIncludingOpenMetrics(r.Header)
-	} else {
-		contentType = expfmt.Negotiate(r.Header)
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, contentType)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			http.Error(w, "Failed to encode metrics", http.StatusInternalServerError)
+			return err
+		}
+	}
+	// Terminates OpenMetrics with # EOF.
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			http.Error(w, "Failed to encode metrics", http.StatusInternalServerError)
+			return err
+		}
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", string(contentType))
+	header.Add("Vary", "Accept-Encoding")
+
+	if !acceptsGzip(r.Header) {
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			http.Error(w, "Failed to write cached metrics to response", http.StatusInternalServerError)
+			return err
+		}
+		return nil
 	}
-	w.Header().Set("Content-Type", string(contentType))
 
-	if _, err = w.Write(buf.Bytes()); err != nil {
-		http.Error(w, "Failed to write cached metrics to response", http.StatusInternalServerError)
+	header.Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(buf.Bytes()); err != nil {
 		return err
 	}
+	return gz.Close()
+}
 
-	return nil
+// acceptsGzip reports whether the Accept-Encoding header of a request allows
+// gzip.
+func acceptsGzip(header http.Header) bool {
+	for _, values := range header.Values("Accept-Encoding") {
+		for _, value := range strings.Split(values, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(value), ";")
+			if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+				continue
+			}
+			// gzip;q=0 refuses it.
+			q := 1.0
+			if name, value, ok := strings.Cut(params, "="); ok && strings.TrimSpace(name) == "q" {
+				if weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = weight
+				}
+			}
+			return q > 0
+		}
+	}
+	return false
 }
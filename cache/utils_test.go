@@ -2,38 +2,30 @@ package cache
 
 import (
 	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/openstack-exporter/openstack-exporter/exporters"
 	"github.com/openstack-exporter/openstack-exporter/utils"
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func mockEnableExporter(
-	service,
-	prefix,
-	cloud string,
-	disabledMetrics []string,
-	endpointType string,
-	collectTime bool,
-	disableSlowMetrics bool,
-	disableDeprecatedMetrics bool,
-	disableCinderAgentUUID bool,
-	domainID string,
-	tenantID string,
-	novaMetadataMapping *utils.LabelMappingFlag,
-	dnsConcurrentCount int,
-	uuidGenFunc func() (string, error),
-	logger *slog.Logger,
-) (*exporters.OpenStackExporter, error) {
+func mockEnableExporter(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error) {
 	var exporter exporters.OpenStackExporter = &mockOpenStackExporter{
 		cnt: prometheus.NewCounter(prometheus.CounterOpts{Name: "c1", Help: "Help c1"}),
 		gge: prometheus.NewGauge(prometheus.GaugeOpts{Name: "g1", Help: "Help g1"}),
@@ -75,40 +67,37 @@ func TestCollectCache(t *testing.T) {
 
 	multiCloud := false
 	services := []string{"service-a"}
-	prefix := "testPrefix"
 	cloud := "testCloud"
-	disabledMetrics := []string{}
-	endpointType := "public"
-	collectTime := true
-	disableSlowMetrics := false
-	disableDeprecatedMetrics := true
-	disableCinderAgentUUID := false
-	domainID := ""
-	tenantID := ""
-	novaMetadataMapping := new(utils.LabelMappingFlag)
-	dnsConcurrentCount := 10
+	opts := exporters.Options{
+		Prefix:                   "testPrefix",
+		DisabledMetrics:          []string{},
+		EndpointType:             "public",
+		CollectTime:              true,
+		DisableDeprecatedMetrics: true,
+		NovaMetadataMapping:      new(utils.LabelMappingFlag),
+		DnsConcurrentCount:       10,
+		ProjectConcurrentCount:   10,
+	}
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
 
+	observed := []string{}
+	SetCollectObserver(func(cloud string, duration time.Duration, failed bool) {
+		observed = append(observed, cloud)
+		assert.False(failed, "Collection should not be reported as failed")
+	})
+	defer SetCollectObserver(nil)
+
 	err := CollectCache(
 		mockEnableExporter,
 		multiCloud,
-		services,
-		prefix,
+		func(string) []string { return services },
 		cloud,
-		disabledMetrics,
-		endpointType,
-		collectTime,
-		disableSlowMetrics,
-		disableDeprecatedMetrics,
-		disableCinderAgentUUID,
-		domainID,
-		tenantID,
-		novaMetadataMapping,
-		dnsConcurrentCount,
-		nil,
+		func(string) exporters.Options { return opts },
 		logger,
 	)
 	assert.NoError(err, "Collect cache failed")
+	assert.Equal([]string{cloud}, observed, "Collection of the cloud was not observed")
+	assert.True(Collected(), "Completed collection was not reported")
 
 	cloudCache, exists := cache.GetCloudCache(cloud)
 	assert.True(exists, "Cloud cache was not set or retrieved properly")
@@ -147,7 +136,7 @@ func TestBufferFromCache(t *testing.T) {
 	}
 	cache.SetCloudCache(cloudName, cloudCache)
 
-	buf, err := BufferFromCache(cloudName, []string{serviceName}, slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})))
+	buf, err := BufferFromCache(cloudName, "openstack", []string{serviceName}, slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})))
 	assert.NoError(err)
 
 	parser := expfmt.NewTextParser(model.UTF8Validation)
@@ -194,7 +183,7 @@ func TestWriteCacheToResponse(t *testing.T) {
 
 	rr := httptest.NewRecorder()
 	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
-		err := WriteCacheToResponse(w, r, cloudName, []string{serviceName}, slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})))
+		err := WriteCacheToResponse(w, r, cloudName, "openstack", []string{serviceName}, slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})))
 		assert.NoError(err, "WriteCacheToResponse failed")
 	}
 	handler := http.HandlerFunc(handlerFunc)
@@ -232,3 +221,214 @@ func TestFlushExpiredCloudCaches(t *testing.T) {
 	_, exists := cache.GetCloudCache(cloudName)
 	assert.False(exists, "Expired cloud cache was not flushed")
 }
+
+func TestCollectCacheKeepsFailedServices(t *testing.T) {
+	cache := GetCache()
+	defer newSingleCache()
+	defer SetMaxStaleness(0)
+	logger := slog.New(slog.DiscardHandler)
+
+	cloud := "testCloud"
+	services := func(string) []string { return []string{"service-a"} }
+	options := func(string) exporters.Options { return exporters.Options{Prefix: "openstack"} }
+	failingExporter := func(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error) {
+		return nil, errors.New("service unavailable")
+	}
+
+	SetMaxStaleness(time.Hour)
+	require.NoError(t, CollectCache(mockEnableExporter, false, services, cloud, options, logger))
+	collected, _ := cache.GetCloudCache(cloud)
+	require.True(t, collected.Services["service-a"].Success)
+
+	require.NoError(t, CollectCache(failingExporter, false, services, cloud, options, logger))
+	kept, _ := cache.GetCloudCache(cloud)
+	assert.Len(t, kept.MetricFamilyCaches, 2, "the last-known-good metrics are kept")
+	assert.False(t, kept.Services["service-a"].Success)
+	assert.Equal(t, collected.Services["service-a"].Time, kept.Services["service-a"].Time)
+
+	buf, err := BufferFromCache(cloud, "openstack", []string{"service-a"}, logger)
+	require.NoError(t, err)
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	metricFamilies, err := parser.TextToMetricFamilies(&buf)
+	require.NoError(t, err)
+	require.Contains(t, metricFamilies, "openstack_exporter_cache_age_seconds")
+	require.Contains(t, metricFamilies, "openstack_exporter_cache_refresh_success")
+	assert.Equal(t, 0.0, metricFamilies["openstack_exporter_cache_refresh_success"].GetMetric()[0].GetGauge().GetValue())
+
+	// Past the max staleness, the service is dropped.
+	SetMaxStaleness(0)
+	require.NoError(t, CollectCache(failingExporter, false, services, cloud, options, logger))
+	dropped, _ := cache.GetCloudCache(cloud)
+	assert.Empty(t, dropped.MetricFamilyCaches)
+	assert.Equal(t, ServiceCacheStatus{}, dropped.Services["service-a"])
+
+	buf, err = BufferFromCache(cloud, "openstack", []string{"service-a"}, logger)
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "openstack_exporter_cache_age_seconds")
+	assert.Contains(t, buf.String(), `openstack_exporter_cache_refresh_success{cloud="testCloud",service="service-a"} 0`)
+}
+
+func TestCollectCacheKeepsFamiliesSharedByServices(t *testing.T) {
+	cache := GetCache()
+	defer newSingleCache()
+	logger := slog.New(slog.DiscardHandler)
+
+	cloud := "testCloud"
+	services := func(string) []string { return []string{"compute", "network"} }
+	options := func(string) exporters.Options { return exporters.Options{Prefix: "openstack"} }
+	// Every service reports the exporter_collector families, with its own
+	// service label.
+	enableExporter := func(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error) {
+		var exporter exporters.OpenStackExporter = &mockOpenStackExporter{
+			cnt: prometheus.NewCounter(prometheus.CounterOpts{Name: "c1", Help: "Help c1", ConstLabels: prometheus.Labels{"service": service}}),
+			gge: prometheus.NewGauge(prometheus.GaugeOpts{Name: "openstack_exporter_collector_success", Help: "Help success", ConstLabels: prometheus.Labels{"service": service}}),
+		}
+		return &exporter, nil
+	}
+
+	require.NoError(t, CollectCache(enableExporter, false, services, cloud, options, logger))
+	cloudCache, exists := cache.GetCloudCache(cloud)
+	require.True(t, exists)
+	assert.Len(t, cloudCache.MetricFamilyCaches, 4)
+
+	buf, err := BufferFromCache(cloud, "openstack", []string{"compute", "network"}, logger)
+	require.NoError(t, err)
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	metricFamilies, err := parser.TextToMetricFamilies(&buf)
+	require.NoError(t, err)
+	require.Contains(t, metricFamilies, "openstack_exporter_collector_success")
+	var collected []string
+	for _, metric := range metricFamilies["openstack_exporter_collector_success"].GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "service" {
+				collected = append(collected, label.GetValue())
+			}
+		}
+	}
+	assert.ElementsMatch(t, []string{"compute", "network"}, collected, "the family of every service is served")
+}
+
+func TestCollectCacheIsolatesFailingClouds(t *testing.T) {
+	cache := GetCache()
+	defer newSingleCache()
+	defer SetCollectObserver(nil)
+	SetConcurrency(2, 2)
+	defer SetConcurrency(0, 0)
+	logger := slog.New(slog.DiscardHandler)
+
+	cloudsYAML := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(cloudsYAML, []byte("clouds:\n  cloud-a: {}\n  cloud-b: {}\n  cloud-c: {}\n  cloud-d: {}\n"), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsYAML)
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	builds := map[string]int{}
+	enableExporter := func(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		builds[cloud]++
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if cloud == "cloud-a" {
+			return nil, errors.New("cloud unreachable")
+		}
+		return mockEnableExporter(service, cloud, opts, logger)
+	}
+	failed := map[string]bool{}
+	SetCollectObserver(func(cloud string, duration time.Duration, collectFailed bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed[cloud] = collectFailed
+	})
+	services := func(string) []string { return []string{"service-a"} }
+	options := func(string) exporters.Options { return exporters.Options{} }
+
+	require.NoError(t, CollectCache(enableExporter, true, services, "", options, logger))
+	assert.Equal(t, 2, maxInFlight, "clouds are collected concurrently up to the limit")
+	assert.Equal(t, map[string]bool{"cloud-a": true, "cloud-b": false, "cloud-c": false, "cloud-d": false}, failed)
+	for _, cloud := range []string{"cloud-b", "cloud-c", "cloud-d"} {
+		cloudCache, exists := cache.GetCloudCache(cloud)
+		require.True(t, exists, cloud)
+		assert.True(t, cloudCache.Services["service-a"].Success, cloud)
+	}
+
+	// A broken clouds.yaml keeps the clouds loaded last.
+	require.NoError(t, os.WriteFile(cloudsYAML, []byte("clouds: ["), 0o600))
+	assert.Error(t, CollectCache(enableExporter, true, services, "", options, logger))
+	assert.Equal(t, 2, builds["cloud-b"])
+}
+
+func TestWriteCacheToResponseNegotiation(t *testing.T) {
+	cache := GetCache()
+	defer newSingleCache()
+	logger := slog.New(slog.DiscardHandler)
+
+	cloudCache := NewCloudCache()
+	cloudCache.SetMetricFamilyCache("compute/openstack_nova_up", MetricFamilyCache{Service: "compute", MF: newTestMetricFamily("openstack_nova_up", 1)})
+	cache.SetCloudCache("testCloud", cloudCache)
+
+	serve := func(accept, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		req.Header.Set("Accept", accept)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+		require.NoError(t, WriteCacheToResponse(rr, req, "testCloud", "openstack", []string{"compute"}, logger))
+		return rr
+	}
+
+	t.Run("openmetrics", func(t *testing.T) {
+		rr := serve("application/openmetrics-text;version=1.0.0", "")
+		assert.Contains(t, rr.Header().Get("Content-Type"), "application/openmetrics-text")
+		assert.Contains(t, rr.Body.String(), "openstack_nova_up 1")
+		assert.True(t, strings.HasSuffix(rr.Body.String(), "# EOF\n"))
+	})
+
+	t.Run("protobuf", func(t *testing.T) {
+		rr := serve("application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited", "")
+		assert.Contains(t, rr.Header().Get("Content-Type"), "application/vnd.google.protobuf")
+
+		dec := expfmt.NewDecoder(rr.Body, expfmt.Format(rr.Header().Get("Content-Type")))
+		mf := &dto.MetricFamily{}
+		require.NoError(t, dec.Decode(mf))
+		assert.Equal(t, "openstack_nova_up", mf.GetName())
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		rr := serve("text/plain", "deflate, gzip;q=0.5")
+		assert.Equal(t, "gzip", rr.Header().Get("Content-Encoding"))
+		gz, err := gzip.NewReader(rr.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "openstack_nova_up 1")
+
+		rr = serve("text/plain", "gzip;q=0")
+		assert.Empty(t, rr.Header().Get("Content-Encoding"))
+		assert.Contains(t, rr.Body.String(), "openstack_nova_up 1")
+	})
+
+	t.Run("gatherers", func(t *testing.T) {
+		registry := prometheus.NewPedanticRegistry()
+		registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Name: "openstack_exporter_build_info", Help: "Build info"}, func() float64 { return 1 }))
+
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		rr := httptest.NewRecorder()
+		require.NoError(t, WriteCacheToResponse(rr, req, "testCloud", "openstack", []string{"compute"}, logger, registry))
+		body := rr.Body.String()
+		assert.Contains(t, body, "openstack_exporter_build_info 1")
+		assert.Less(t, strings.Index(body, "openstack_nova_up 1"), strings.Index(body, "openstack_exporter_build_info 1"), "the cached metrics come first")
+
+		failing := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return nil, errors.New("gather failed") })
+		rr = httptest.NewRecorder()
+		assert.Error(t, WriteCacheToResponse(rr, req, "testCloud", "openstack", []string{"compute"}, logger, failing))
+		assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	})
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// CloudsYAMLKey is the key of the exporter settings block of a cloud entry in
+// clouds.yaml.
+const CloudsYAMLKey = "openstack_exporter"
+
+// LoadCloudsYAMLSettings reads the exporter settings blocks of the clouds.yaml
+// used by the OpenStack clients. It returns the path of the file, which is
+// empty when no clouds.yaml was found.
+func LoadCloudsYAMLSettings() (string, map[string]Settings, error) {
+	path, content, err := clientconfig.FindAndReadCloudsYAML()
+	if path == "" {
+		return "", nil, nil
+	}
+	if err != nil {
+		return path, nil, err
+	}
+
+	settings, err := ParseCloudsYAMLSettings(content)
+	if err != nil {
+		return path, nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+
+	return path, settings, nil
+}
+
+// ParseCloudsYAMLSettings reads the exporter settings blocks of a clouds.yaml,
+// rejecting unknown settings. Clouds without a block are left out.
+func ParseCloudsYAMLSettings(content []byte) (map[string]Settings, error) {
+	var cloudsYAML struct {
+		Clouds map[string]map[string]yaml.Node `yaml:"clouds"`
+	}
+	if err := yaml.Unmarshal(content, &cloudsYAML); err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]Settings)
+	for _, cloud := range slices.Sorted(maps.Keys(cloudsYAML.Clouds)) {
+		node, ok := cloudsYAML.Clouds[cloud][CloudsYAMLKey]
+		if !ok {
+			continue
+		}
+
+		// yaml.Node.Decode cannot reject unknown fields, decode the block again.
+		raw, err := yaml.Marshal(&node)
+		if err != nil {
+			return nil, err
+		}
+
+		var s Settings
+		decoder := yaml.NewDecoder(bytes.NewReader(raw))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&s); err != nil {
+			return nil, fmt.Errorf("clouds.%s.%s: %w", cloud, CloudsYAMLKey, err)
+		}
+		settings[cloud] = s
+	}
+
+	var errs []error
+	for _, cloud := range slices.Sorted(maps.Keys(settings)) {
+		s := settings[cloud]
+		errs = append(errs, s.validate(fmt.Sprintf("clouds.%s.%s", cloud, CloudsYAMLKey))...)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// loadCloudEntries reads the entries of the clouds in clouds.yaml and
+// secure.yaml, without their exporter settings blocks, so a change of their
+// authentication settings or credentials can be detected. It returns the path
+// of secure.yaml, which is empty when there is none.
+func loadCloudEntries() (string, map[string]string, error) {
+	entries := make(map[string]string)
+	if path, content, err := clientconfig.FindAndReadCloudsYAML(); path != "" {
+		if err != nil {
+			return "", nil, err
+		}
+		if err := parseCloudEntries(content, entries); err != nil {
+			return "", nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+	}
+
+	securePath, content, err := clientconfig.FindAndReadSecureCloudsYAML()
+	if securePath == "" {
+		return "", entries, nil
+	}
+	if err != nil {
+		return securePath, nil, err
+	}
+	if err := parseCloudEntries(content, entries); err != nil {
+		return securePath, nil, fmt.Errorf("invalid %s: %w", securePath, err)
+	}
+
+	return securePath, entries, nil
+}
+
+// parseCloudEntries appends the entries of the clouds of content to entries.
+func parseCloudEntries(content []byte, entries map[string]string) error {
+	var cloudsYAML struct {
+		Clouds map[string]map[string]yaml.Node `yaml:"clouds"`
+	}
+	if err := yaml.Unmarshal(content, &cloudsYAML); err != nil {
+		return err
+	}
+
+	for cloud, entry := range cloudsYAML.Clouds {
+		delete(entry, CloudsYAMLKey)
+		// Maps are marshalled with sorted keys, an entry always gives the
+		// same YAML.
+		raw, err := yaml.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		entries[cloud] += string(raw)
+	}
+
+	return nil
+}
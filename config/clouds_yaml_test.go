@@ -0,0 +1,108 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openstack-exporter/openstack-exporter/exporters"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCloudsYAML = `
+clouds:
+  core:
+    auth:
+      auth_url: http://core.cloud:5000/v3
+    openstack_exporter:
+      endpoint_type: internal
+  edge:
+    auth:
+      auth_url: http://edge.cloud:5000/v3
+    openstack_exporter:
+      endpoint_type: public
+      domain_id: edge
+      services: [compute, network]
+  plain:
+    auth:
+      auth_url: http://plain.cloud:5000/v3
+`
+
+func TestParseCloudsYAMLSettings(t *testing.T) {
+	settings, err := ParseCloudsYAMLSettings([]byte(testCloudsYAML))
+	require.NoError(t, err)
+
+	assert.Len(t, settings, 2)
+	assert.Equal(t, "internal", *settings["core"].EndpointType)
+	assert.Equal(t, "public", *settings["edge"].EndpointType)
+	assert.Equal(t, []string{"compute", "network"}, settings["edge"].Services)
+	assert.NotContains(t, settings, "plain")
+}
+
+func TestParseCloudsYAMLSettingsErrors(t *testing.T) {
+	_, err := ParseCloudsYAMLSettings([]byte(`
+clouds:
+  edge:
+    openstack_exporter:
+      endpoint: public
+`))
+	assert.ErrorContains(t, err, "clouds.edge.openstack_exporter")
+	assert.ErrorContains(t, err, "field endpoint not found")
+
+	_, err = ParseCloudsYAMLSettings([]byte(`
+clouds:
+  edge:
+    openstack_exporter:
+      services: [compute, storage]
+`))
+	assert.ErrorContains(t, err, `clouds.edge.openstack_exporter.services: unknown service "storage"`)
+}
+
+func TestCloudsYAMLPrecedence(t *testing.T) {
+	config, err := Parse(strings.NewReader(`
+global:
+  endpoint_type: admin
+  project_id: global-project
+clouds:
+  edge:
+    domain_id: file-domain
+`))
+	require.NoError(t, err)
+	config.CloudsYAML, err = ParseCloudsYAMLSettings([]byte(testCloudsYAML))
+	require.NoError(t, err)
+
+	base := exporters.Options{EndpointType: "public", DomainID: "flag-domain"}
+
+	// The clouds.yaml block overrides the global settings...
+	core := config.Options("core", base)
+	assert.Equal(t, "internal", core.EndpointType)
+	assert.Equal(t, "flag-domain", core.DomainID)
+	assert.Equal(t, "global-project", core.TenantID)
+
+	// ...and is overridden by the cloud settings of the file.
+	edge := config.Options("edge", base)
+	assert.Equal(t, "public", edge.EndpointType)
+	assert.Equal(t, "file-domain", edge.DomainID)
+
+	plain := config.Options("plain", base)
+	assert.Equal(t, "admin", plain.EndpointType)
+
+	configured := []string{"compute", "network", "image"}
+	assert.Equal(t, []string{"compute", "network"}, config.Services("edge", configured))
+	assert.Equal(t, configured, config.Services("core", configured))
+}
+
+func TestStoreLoadsCloudsYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testCloudsYAML), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path)
+
+	store, err := NewStore("", slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	assert.Equal(t, "internal", store.Config().Options("core", exporters.Options{}).EndpointType)
+	assert.Contains(t, store.modTimes, path)
+}
@@ -0,0 +1,312 @@
+// Package config implements the exporter configuration file, which sets the
+// exporter options globally and per cloud, overriding the command line flags.
+//
+// Settings left out of the file keep the value of their flag. The settings of
+// a cloud are applied on top of the global ones, a list or a map set for a
+// cloud replaces the global one. Clouds can also be configured by an
+// openstack_exporter block of their clouds.yaml entry, applied between the
+// global and the cloud settings of the file:
+//
+//	cache:
+//	  enabled: true
+//	  ttl: 10m
+//	global:
+//	  endpoint_type: internal
+//	  disabled_metrics:
+//	    - cinder-snapshots
+//	  collect_timeout: 30s
+//	clouds:
+//	  edge:
+//	    endpoint_type: public
+//	    project_id: 0c4e939acacf4376bdcd1129f1a054ad
+//	    nova_metadata_extra_labels: owner=team
+//
+// The file is loaded on startup and reloaded by a Store on SIGHUP or when it
+// changes.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openstack-exporter/openstack-exporter/exporters"
+	"github.com/openstack-exporter/openstack-exporter/utils"
+	"gopkg.in/yaml.v3"
+)
+
+var validEndpointTypes = []string{"public", "internal", "admin", "publicURL", "internalURL", "adminURL"}
+
+// Config is the content of the exporter configuration file.
+type Config struct {
+	// Cache is only read on startup.
+	Cache  CacheSettings       `yaml:"cache"`
+	Global Settings            `yaml:"global"`
+	Clouds map[string]Settings `yaml:"clouds"`
+	// CloudsYAML holds the openstack_exporter blocks of clouds.yaml.
+	CloudsYAML map[string]Settings `yaml:"-"`
+
+	// cloudEntries holds the rest of the clouds.yaml and secure.yaml entries,
+	// see ChangedClouds.
+	cloudEntries map[string]string
+}
+
+// CacheSettings overrides the --cache and --cache-ttl flags.
+type CacheSettings struct {
+	Enabled *bool          `yaml:"enabled"`
+	TTL     *time.Duration `yaml:"ttl"`
+}
+
+// Settings overrides the exporter options. A nil field keeps the inherited
+// value.
+type Settings struct {
+	// Services replaces the enabled services.
+	Services                 []string                 `yaml:"services"`
+	EndpointType             *string                  `yaml:"endpoint_type"`
+	DisabledMetrics          []string                 `yaml:"disabled_metrics"`
+	DisableSlowMetrics       *bool                    `yaml:"disable_slow_metrics"`
+	DisableDeprecatedMetrics *bool                    `yaml:"disable_deprecated_metrics"`
+	DisableCinderAgentUUID   *bool                    `yaml:"disable_cinder_agent_uuid"`
+	DomainID                 *string                  `yaml:"domain_id"`
+	ProjectID                *string                  `yaml:"project_id"`
+	NovaMetadataExtraLabels  *string                  `yaml:"nova_metadata_extra_labels"`
+	DNSConcurrentCount       *int                     `yaml:"dns_concurrent_count"`
+	ProjectConcurrentCount   *int                     `yaml:"project_concurrent_count"`
+	CollectTimeout           *time.Duration           `yaml:"collect_timeout"`
+	ServiceCollectTimeouts   map[string]time.Duration `yaml:"service_collect_timeouts"`
+	MetricCollectTimeouts    map[string]time.Duration `yaml:"metric_collect_timeouts"`
+	Regions                  []string                 `yaml:"regions"`
+	DiscoverRegions          *bool                    `yaml:"discover_regions"`
+}
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse reads and validates a configuration, rejecting unknown settings.
+func Parse(r io.Reader) (*Config, error) {
+	config := &Config{}
+
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// Validate reports every invalid setting of the configuration.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Cache.TTL != nil && *c.Cache.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("cache.ttl: must be positive, got %s", *c.Cache.TTL))
+	}
+
+	errs = append(errs, c.Global.validate("global")...)
+
+	for _, cloud := range slices.Sorted(maps.Keys(c.Clouds)) {
+		settings := c.Clouds[cloud]
+		errs = append(errs, settings.validate("clouds."+cloud)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+func (s *Settings) validate(path string) []error {
+	var errs []error
+	invalid := func(setting, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", path, setting, fmt.Sprintf(format, args...)))
+	}
+
+	for _, service := range s.Services {
+		if !exporters.IsExporterNameValid(service) {
+			invalid("services", "unknown service %q", service)
+		}
+	}
+
+	if s.EndpointType != nil && !slices.Contains(validEndpointTypes, *s.EndpointType) {
+		invalid("endpoint_type", "invalid endpoint type %q, must be one of %s", *s.EndpointType, strings.Join(validEndpointTypes, ", "))
+	}
+
+	for _, metric := range s.DisabledMetrics {
+		if service, name, ok := strings.Cut(metric, "-"); !ok || service == "" || name == "" {
+			invalid("disabled_metrics", "invalid metric %q, must be in the format service-metric (i.e: cinder-snapshots)", metric)
+		} else if _, ok := exporters.MetricService(metric); !ok {
+			invalid("disabled_metrics", "unknown metric %q", metric)
+		}
+	}
+
+	if s.NovaMetadataExtraLabels != nil {
+		if _, err := parseLabelMapping(*s.NovaMetadataExtraLabels); err != nil {
+			invalid("nova_metadata_extra_labels", "%s", err)
+		}
+	}
+
+	if s.DNSConcurrentCount != nil && *s.DNSConcurrentCount < 1 {
+		invalid("dns_concurrent_count", "must be at least 1, got %d", *s.DNSConcurrentCount)
+	}
+
+	if s.ProjectConcurrentCount != nil && *s.ProjectConcurrentCount < 1 {
+		invalid("project_concurrent_count", "must be at least 1, got %d", *s.ProjectConcurrentCount)
+	}
+
+	if s.CollectTimeout != nil && *s.CollectTimeout < 0 {
+		invalid("collect_timeout", "must not be negative, got %s", *s.CollectTimeout)
+	}
+
+	for _, service := range slices.Sorted(maps.Keys(s.ServiceCollectTimeouts)) {
+		if !exporters.IsExporterNameValid(service) {
+			invalid("service_collect_timeouts", "unknown service %q", service)
+		}
+		if s.ServiceCollectTimeouts[service] < 0 {
+			invalid("service_collect_timeouts", "timeout of %s must not be negative", service)
+		}
+	}
+
+	for _, metric := range slices.Sorted(maps.Keys(s.MetricCollectTimeouts)) {
+		if _, ok := exporters.MetricService(metric); !ok {
+			invalid("metric_collect_timeouts", "unknown metric %q", metric)
+		}
+		if s.MetricCollectTimeouts[metric] < 0 {
+			invalid("metric_collect_timeouts", "timeout of %s must not be negative", metric)
+		}
+	}
+
+	for _, region := range s.Regions {
+		if region == "" {
+			invalid("regions", "region must not be empty")
+		}
+	}
+
+	return errs
+}
+
+// ChangedClouds returns the clouds whose clouds.yaml or secure.yaml entry,
+// besides its openstack_exporter block, was added, removed or modified since
+// previous: their authentication settings or credentials may have changed.
+func (c *Config) ChangedClouds(previous *Config) []string {
+	var changed []string
+	for cloud, entry := range c.cloudEntries {
+		if previousEntry, ok := previous.cloudEntries[cloud]; !ok || previousEntry != entry {
+			changed = append(changed, cloud)
+		}
+	}
+	for cloud := range previous.cloudEntries {
+		if _, ok := c.cloudEntries[cloud]; !ok {
+			changed = append(changed, cloud)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// settings returns the settings applying to cloud, from the lowest to the
+// highest precedence.
+func (c *Config) settings(cloud string) []Settings {
+	settings := []Settings{c.Global}
+	if s, ok := c.CloudsYAML[cloud]; ok {
+		settings = append(settings, s)
+	}
+	if s, ok := c.Clouds[cloud]; ok {
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+// Options returns base with the global settings, the clouds.yaml block of
+// cloud, then the settings of cloud applied.
+func (c *Config) Options(cloud string, base exporters.Options) exporters.Options {
+	opts := base
+	for _, s := range c.settings(cloud) {
+		opts = s.apply(opts)
+	}
+	return opts
+}
+
+// Services returns the services enabled for cloud, base unless overridden.
+func (c *Config) Services(cloud string, base []string) []string {
+	services := base
+	for _, s := range c.settings(cloud) {
+		if s.Services != nil {
+			services = s.Services
+		}
+	}
+	return services
+}
+
+func (s *Settings) apply(opts exporters.Options) exporters.Options {
+	if s.EndpointType != nil {
+		opts.EndpointType = *s.EndpointType
+	}
+	if s.DisabledMetrics != nil {
+		opts.DisabledMetrics = s.DisabledMetrics
+	}
+	if s.DisableSlowMetrics != nil {
+		opts.DisableSlowMetrics = *s.DisableSlowMetrics
+	}
+	if s.DisableDeprecatedMetrics != nil {
+		opts.DisableDeprecatedMetrics = *s.DisableDeprecatedMetrics
+	}
+	if s.DisableCinderAgentUUID != nil {
+		opts.DisableCinderAgentUUID = *s.DisableCinderAgentUUID
+	}
+	if s.DomainID != nil {
+		opts.DomainID = *s.DomainID
+	}
+	if s.ProjectID != nil {
+		opts.TenantID = *s.ProjectID
+	}
+	if s.NovaMetadataExtraLabels != nil {
+		// Validated when the configuration was loaded.
+		opts.NovaMetadataMapping, _ = parseLabelMapping(*s.NovaMetadataExtraLabels)
+	}
+	if s.DNSConcurrentCount != nil {
+		opts.DnsConcurrentCount = *s.DNSConcurrentCount
+	}
+	if s.ProjectConcurrentCount != nil {
+		opts.ProjectConcurrentCount = *s.ProjectConcurrentCount
+	}
+	if s.CollectTimeout != nil {
+		opts.CollectTimeouts.Default = *s.CollectTimeout
+	}
+	if s.ServiceCollectTimeouts != nil {
+		opts.CollectTimeouts.Services = s.ServiceCollectTimeouts
+	}
+	if s.MetricCollectTimeouts != nil {
+		opts.CollectTimeouts.Metrics = s.MetricCollectTimeouts
+	}
+	if s.Regions != nil {
+		opts.Regions = s.Regions
+	}
+	if s.DiscoverRegions != nil {
+		opts.DiscoverRegions = *s.DiscoverRegions
+	}
+
+	return opts
+}
+
+func parseLabelMapping(value string) (*utils.LabelMappingFlag, error) {
+	mapping := new(utils.LabelMappingFlag)
+	if err := mapping.Set(value); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
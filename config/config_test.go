@@ -0,0 +1,127 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openstack-exporter/openstack-exporter/exporters"
+	"github.com/openstack-exporter/openstack-exporter/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+cache:
+  enabled: true
+  ttl: 10m
+global:
+  endpoint_type: internal
+  disabled_metrics:
+    - cinder-snapshots
+  collect_timeout: 30s
+  service_collect_timeouts:
+    compute: 20s
+clouds:
+  edge:
+    endpoint_type: public
+    project_id: 0c4e939acacf4376bdcd1129f1a054ad
+    disabled_metrics: []
+    nova_metadata_extra_labels: owner=team
+    dns_concurrent_count: 2
+`
+
+func TestParse(t *testing.T) {
+	config, err := Parse(strings.NewReader(testConfig))
+	require.NoError(t, err)
+
+	assert.True(t, *config.Cache.Enabled)
+	assert.Equal(t, 10*time.Minute, *config.Cache.TTL)
+	assert.Equal(t, "internal", *config.Global.EndpointType)
+	assert.Equal(t, []string{"cinder-snapshots"}, config.Global.DisabledMetrics)
+	assert.Equal(t, 30*time.Second, *config.Global.CollectTimeout)
+	assert.Contains(t, config.Clouds, "edge")
+}
+
+func TestParseEmpty(t *testing.T) {
+	config, err := Parse(strings.NewReader(""))
+	require.NoError(t, err)
+
+	base := exporters.Options{EndpointType: "public"}
+	assert.Equal(t, base, config.Options("any", base))
+}
+
+func TestParseRejectsUnknownSettings(t *testing.T) {
+	_, err := Parse(strings.NewReader("global:\n  endpoint: public\n"))
+	assert.ErrorContains(t, err, "field endpoint not found")
+}
+
+func TestValidate(t *testing.T) {
+	_, err := Parse(strings.NewReader(`
+global:
+  endpoint_type: private
+  dns_concurrent_count: 0
+clouds:
+  edge:
+    disabled_metrics: [snapshots, cinder-snapshot, nova-flavors]
+    nova_metadata_extra_labels: "__bad=key"
+    service_collect_timeouts:
+      computer: 10s
+    metric_collect_timeouts:
+      nova-server: 10s
+      neutron-ports: 10s
+    regions: [RegionOne, ""]
+`))
+	require.Error(t, err)
+
+	for _, expected := range []string{
+		`global.endpoint_type: invalid endpoint type "private"`,
+		"global.dns_concurrent_count: must be at least 1, got 0",
+		`clouds.edge.disabled_metrics: invalid metric "snapshots"`,
+		`clouds.edge.disabled_metrics: unknown metric "cinder-snapshot"`,
+		"clouds.edge.nova_metadata_extra_labels: bad label name",
+		`clouds.edge.service_collect_timeouts: unknown service "computer"`,
+		`clouds.edge.metric_collect_timeouts: unknown metric "nova-server"`,
+		"clouds.edge.regions: region must not be empty",
+	} {
+		assert.ErrorContains(t, err, expected)
+	}
+	assert.NotContains(t, err.Error(), "nova-flavors")
+	assert.NotContains(t, err.Error(), "neutron-ports")
+}
+
+func TestOptions(t *testing.T) {
+	config, err := Parse(strings.NewReader(testConfig))
+	require.NoError(t, err)
+
+	base := exporters.Options{
+		Prefix:              "openstack",
+		EndpointType:        "public",
+		DisabledMetrics:     []string{"nova-flavors"},
+		NovaMetadataMapping: new(utils.LabelMappingFlag),
+		DnsConcurrentCount:  10,
+		CollectTimeouts:     exporters.CollectTimeouts{Default: time.Minute},
+	}
+
+	core := config.Options("core", base)
+	assert.Equal(t, "openstack", core.Prefix)
+	assert.Equal(t, "internal", core.EndpointType)
+	assert.Equal(t, []string{"cinder-snapshots"}, core.DisabledMetrics)
+	assert.Equal(t, "", core.TenantID)
+	assert.Equal(t, 10, core.DnsConcurrentCount)
+	assert.Equal(t, 30*time.Second, core.CollectTimeouts.Default)
+	assert.Equal(t, map[string]time.Duration{"compute": 20 * time.Second}, core.CollectTimeouts.Services)
+
+	edge := config.Options("edge", base)
+	assert.Equal(t, "public", edge.EndpointType)
+	assert.Empty(t, edge.DisabledMetrics)
+	assert.Equal(t, "0c4e939acacf4376bdcd1129f1a054ad", edge.TenantID)
+	assert.Equal(t, []string{"owner"}, edge.NovaMetadataMapping.Labels)
+	assert.Equal(t, []string{"team"}, edge.NovaMetadataMapping.Keys)
+	assert.Equal(t, 2, edge.DnsConcurrentCount)
+	assert.Equal(t, 30*time.Second, edge.CollectTimeouts.Default)
+
+	// The base options are left untouched.
+	assert.Equal(t, []string{"nova-flavors"}, base.DisabledMetrics)
+	assert.Equal(t, "public", base.EndpointType)
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Store holds the current configuration and reloads it from the configuration
+// file and clouds.yaml. A configuration that fails to load or validate is
+// logged and the previous one is kept.
+type Store struct {
+	path   string
+	logger *slog.Logger
+
+	mu       sync.RWMutex
+	config   *Config
+	modTimes map[string]time.Time
+	onReload []func(*Config)
+}
+
+// NewStore loads the configuration file at path and the openstack_exporter
+// blocks of clouds.yaml. An empty path only loads clouds.yaml. The other
+// settings of the clouds in clouds.yaml and secure.yaml are kept to tell the
+// clouds to authenticate again after a reload, see Config.ChangedClouds.
+func NewStore(path string, logger *slog.Logger) (*Store, error) {
+	s := &Store{path: path, logger: logger}
+
+	config, modTimes, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	s.config = config
+	s.modTimes = modTimes
+	return s, nil
+}
+
+// load reads the configuration, returning the modification time of every
+// file it was read from.
+func (s *Store) load() (*Config, map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	config := &Config{}
+
+	if s.path != "" {
+		info, err := os.Stat(s.path)
+		if err != nil {
+			return nil, modTimes, err
+		}
+		modTimes[s.path] = info.ModTime()
+
+		config, err = Load(s.path)
+		if err != nil {
+			return nil, modTimes, err
+		}
+	}
+
+	cloudsPath, cloudsSettings, err := LoadCloudsYAMLSettings()
+	if cloudsPath != "" {
+		if info, statErr := os.Stat(cloudsPath); statErr == nil {
+			modTimes[cloudsPath] = info.ModTime()
+		}
+	}
+	if err != nil {
+		return nil, modTimes, err
+	}
+	config.CloudsYAML = cloudsSettings
+
+	securePath, cloudEntries, err := loadCloudEntries()
+	if securePath != "" {
+		if info, statErr := os.Stat(securePath); statErr == nil {
+			modTimes[securePath] = info.ModTime()
+		}
+	}
+	if err != nil {
+		return nil, modTimes, err
+	}
+	config.cloudEntries = cloudEntries
+
+	return config, modTimes, nil
+}
+
+// Config returns the current configuration.
+func (s *Store) Config() *Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.config
+}
+
+// OnReload registers fn to be called with the new configuration after every
+// successful reload.
+func (s *Store) OnReload(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onReload = append(s.onReload, fn)
+}
+
+// Reload loads the configuration file and clouds.yaml again.
+func (s *Store) Reload() error {
+	config, modTimes, err := s.load()
+
+	s.mu.Lock()
+	// An invalid file is not retried until it is modified again.
+	s.modTimes = modTimes
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.config = config
+	callbacks := append([]func(*Config){}, s.onReload...)
+	s.mu.Unlock()
+
+	s.logger.Info("Reloaded configuration", "path", s.path)
+	for _, fn := range callbacks {
+		fn(config)
+	}
+
+	return nil
+}
+
+// changed reports whether a file of the configuration was modified since it
+// was last loaded.
+func (s *Store) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for path, modTime := range s.modTimes {
+		info, err := os.Stat(path)
+		if err != nil {
+			s.logger.Error("Failed to stat configuration file", "path", path, "err", err)
+			continue
+		}
+		if !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Watch reloads the configuration on SIGHUP and, when interval is positive,
+// whenever the modification time of one of its files changes, until ctx is
+// done.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	reload := func() {
+		if err := s.Reload(); err != nil {
+			s.logger.Error("Failed to reload configuration, keeping the previous one", "path", s.path, "err", err)
+		}
+	}
+
+	for {
+		select {
+		case <-hup:
+			reload()
+		case <-tick:
+			if s.changed() {
+				reload()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
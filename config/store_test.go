@@ -0,0 +1,115 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, path, content string, modTime time.Time) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+// withoutCloudsYAML points the OpenStack clients to an empty clouds.yaml.
+func withoutCloudsYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("clouds: {}\n"), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path)
+}
+
+func TestStoreWithoutFile(t *testing.T) {
+	withoutCloudsYAML(t)
+
+	store, err := NewStore("", slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	assert.Equal(t, &Config{CloudsYAML: map[string]Settings{}, cloudEntries: map[string]string{}}, store.Config())
+	assert.NoError(t, store.Reload())
+}
+
+func TestNewStoreRejectsInvalidFile(t *testing.T) {
+	withoutCloudsYAML(t)
+	path := filepath.Join(t.TempDir(), "exporter.yaml")
+	writeConfig(t, path, "global:\n  endpoint_type: private\n", time.Now())
+
+	_, err := NewStore(path, slog.New(slog.DiscardHandler))
+	assert.ErrorContains(t, err, "global.endpoint_type")
+}
+
+func TestStoreReload(t *testing.T) {
+	withoutCloudsYAML(t)
+	path := filepath.Join(t.TempDir(), "exporter.yaml")
+	start := time.Now().Add(-time.Hour)
+	writeConfig(t, path, "global:\n  endpoint_type: internal\n", start)
+
+	store, err := NewStore(path, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	assert.Equal(t, "internal", *store.Config().Global.EndpointType)
+
+	reloaded := make(chan *Config, 1)
+	store.OnReload(func(c *Config) { reloaded <- c })
+
+	// An invalid file keeps the previous configuration.
+	writeConfig(t, path, "global:\n  endpoint_type: private\n", start.Add(time.Minute))
+	assert.Error(t, store.Reload())
+	assert.Equal(t, "internal", *store.Config().Global.EndpointType)
+	assert.False(t, store.changed())
+
+	writeConfig(t, path, "global:\n  endpoint_type: admin\n", start.Add(2*time.Minute))
+	assert.True(t, store.changed())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx, 10*time.Millisecond)
+
+	select {
+	case c := <-reloaded:
+		assert.Equal(t, "admin", *c.Global.EndpointType)
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration was not reloaded after the file changed")
+	}
+	assert.Equal(t, "admin", *store.Config().Global.EndpointType)
+}
+
+func TestStoreReloadChangedClouds(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testCloudsYAML), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path)
+
+	store, err := NewStore("", slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	previous := store.Config()
+
+	// The exporter settings of core change, the authentication of edge
+	// changes, plain is removed and new is added.
+	require.NoError(t, os.WriteFile(path, []byte(`
+clouds:
+  core:
+    auth:
+      auth_url: http://core.cloud:5000/v3
+    openstack_exporter:
+      endpoint_type: admin
+  edge:
+    auth:
+      auth_url: http://edge.cloud:5000/v3
+      password: rotated
+    openstack_exporter:
+      endpoint_type: public
+      domain_id: edge
+      services: [compute, network]
+  new:
+    auth:
+      auth_url: http://new.cloud:5000/v3
+`), 0o600))
+	require.NoError(t, store.Reload())
+
+	assert.Equal(t, []string{"edge", "new", "plain"}, store.Config().ChangedClouds(previous))
+	assert.Empty(t, store.Config().ChangedClouds(store.Config()))
+}
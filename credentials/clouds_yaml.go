@@ -0,0 +1,276 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+	"gopkg.in/yaml.v3"
+)
+
+// vaultSecretYAML is the location of the password of a cloud in clouds.yaml.
+type vaultSecretYAML struct {
+	Path           string `yaml:"vault_secret_path"`
+	MountPath      string `yaml:"vault_secret_mount_path"`
+	CredentialName string `yaml:"credential_name_in_vault_secret"`
+}
+
+// vaultYAML is the Vault configuration set at the top of clouds.yaml.
+type vaultYAML struct {
+	vaultSecretYAML     `yaml:",inline"`
+	UseVault            bool           `yaml:"use_vault"`
+	Address             string         `yaml:"vault_address"`
+	AuthMethod          string         `yaml:"vault_auth_method"`
+	AuthMountPath       string         `yaml:"vault_auth_mount_path"`
+	RoleID              string         `yaml:"vault_role_id"`
+	SecretID            string         `yaml:"vault_secret_id"`
+	KubernetesRole      string         `yaml:"vault_kubernetes_role"`
+	KubernetesTokenPath string         `yaml:"vault_kubernetes_token_path"`
+	Token               string         `yaml:"vault_token"`
+	TokenPath           string         `yaml:"vault_token_path"`
+	RefreshInterval     *time.Duration `yaml:"vault_refresh_interval"`
+
+	Clouds map[string]vaultSecretYAML `yaml:"clouds"`
+}
+
+// ParseVaultConfig reads the Vault configuration of a clouds.yaml, set by
+// use_vault and the vault_* keys at its top, and the per cloud secrets set
+// by the vault_secret_path, vault_secret_mount_path and
+// credential_name_in_vault_secret keys of its cloud entries. It returns nil
+// when use_vault isn't set.
+func ParseVaultConfig(content []byte) (*VaultConfig, error) {
+	var raw vaultYAML
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+	if !raw.UseVault {
+		return nil, nil
+	}
+
+	config := &VaultConfig{
+		Address:             raw.Address,
+		AuthMethod:          raw.AuthMethod,
+		AuthMountPath:       raw.AuthMountPath,
+		RoleID:              raw.RoleID,
+		SecretID:            raw.SecretID,
+		KubernetesRole:      raw.KubernetesRole,
+		KubernetesTokenPath: raw.KubernetesTokenPath,
+		Token:               raw.Token,
+		TokenPath:           raw.TokenPath,
+		RefreshInterval:     DefaultVaultRefreshInterval,
+		Secret:              raw.vaultSecretYAML.secret(),
+		CloudSecrets:        make(map[string]VaultSecret),
+	}
+	if config.AuthMethod == "" {
+		config.AuthMethod = VaultAuthAppRole
+	}
+	if raw.RefreshInterval != nil {
+		config.RefreshInterval = *raw.RefreshInterval
+	}
+	for cloud, secret := range raw.Clouds {
+		if secret != (vaultSecretYAML{}) {
+			config.CloudSecrets[cloud] = secret.secret()
+		}
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (s vaultSecretYAML) secret() VaultSecret {
+	return VaultSecret{Path: s.Path, MountPath: s.MountPath, Key: s.CredentialName}
+}
+
+// validate reports every invalid setting of the configuration.
+func (c *VaultConfig) validate() error {
+	var errs []error
+
+	if c.Address == "" {
+		errs = append(errs, errors.New("vault_address: must be set"))
+	}
+
+	switch c.AuthMethod {
+	case VaultAuthAppRole:
+		if c.RoleID == "" {
+			errs = append(errs, errors.New("vault_role_id: must be set with the approle auth method"))
+		}
+	case VaultAuthKubernetes:
+		if c.KubernetesRole == "" {
+			errs = append(errs, errors.New("vault_kubernetes_role: must be set with the kubernetes auth method"))
+		}
+	case VaultAuthToken:
+		if c.Token == "" && c.TokenPath == "" {
+			errs = append(errs, errors.New("vault_token: vault_token or vault_token_path must be set with the token auth method"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("vault_auth_method: unknown auth method %q, must be one of %s, %s, %s", c.AuthMethod, VaultAuthAppRole, VaultAuthKubernetes, VaultAuthToken))
+	}
+
+	if c.RefreshInterval <= 0 {
+		errs = append(errs, fmt.Errorf("vault_refresh_interval: must be positive, got %s", c.RefreshInterval))
+	}
+
+	if c.Secret.Path == "" && len(c.CloudSecrets) == 0 {
+		errs = append(errs, errors.New("vault_secret_path: must be set globally or for a cloud"))
+	}
+	for _, cloud := range slices.Sorted(maps.Keys(c.CloudSecrets)) {
+		if c.CloudSecrets[cloud].Path == "" {
+			errs = append(errs, fmt.Errorf("clouds.%s.vault_secret_path: must be set with the other Vault secret keys of the cloud", cloud))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// The credential providers selectable by the credentials_provider key of a
+// cloud entry.
+const (
+	ProviderCloudsYAML = "clouds_yaml"
+	ProviderVault      = "vault"
+	ProviderFile       = "file"
+	ProviderEnvFile    = "env_file"
+	ProviderCommand    = "command"
+)
+
+// providerYAML is the credential provider of a cloud in clouds.yaml.
+type providerYAML struct {
+	Provider                         string        `yaml:"credentials_provider"`
+	PasswordFile                     string        `yaml:"password_file"`
+	ApplicationCredentialIDFile      string        `yaml:"application_credential_id_file"`
+	ApplicationCredentialNameFile    string        `yaml:"application_credential_name_file"`
+	ApplicationCredentialSecretFile  string        `yaml:"application_credential_secret_file"`
+	EnvFile                          string        `yaml:"env_file"`
+	CredentialProcess                string        `yaml:"credential_process"`
+	CredentialProcessTimeout         time.Duration `yaml:"credential_process_timeout"`
+	CredentialProcessRefreshInterval time.Duration `yaml:"credential_process_refresh_interval"`
+}
+
+// cloudsYAMLProvider keeps the credentials of clouds.yaml.
+type cloudsYAMLProvider struct{}
+
+func (cloudsYAMLProvider) Credentials(context.Context, string) (*Credentials, error) {
+	return nil, nil
+}
+
+// LoadProviders reads the credential providers of the clouds.yaml used by
+// the OpenStack clients. It returns nil when every cloud uses the
+// credentials of clouds.yaml.
+func LoadProviders(logger *slog.Logger) (*Providers, error) {
+	path, content, err := clientconfig.FindAndReadCloudsYAML()
+	if path == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	providers, err := ParseProviders(content, logger)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return providers, nil
+}
+
+// ParseProviders reads the credential providers of a clouds.yaml. The
+// provider of a cloud is set by the credentials_provider key of its entry,
+// with its settings next to it. The clouds without one read their password
+// from Vault when use_vault is set, see ParseVaultConfig, and use the
+// credentials of clouds.yaml otherwise. It returns nil when every cloud uses
+// the credentials of clouds.yaml.
+func ParseProviders(content []byte, logger *slog.Logger) (*Providers, error) {
+	vaultConfig, err := ParseVaultConfig(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw struct {
+		Clouds map[string]providerYAML `yaml:"clouds"`
+	}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+
+	providers := &Providers{clouds: make(map[string]Provider), changes: make(chan struct{}, 1), logger: logger}
+	if vaultConfig != nil {
+		logger.Info("Reading the cloud credentials from Vault", "address", vaultConfig.Address, "auth_method", vaultConfig.AuthMethod)
+		providers.vault, err = NewVaultProvider(*vaultConfig, logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, cloud := range slices.Sorted(maps.Keys(raw.Clouds)) {
+		provider, err := raw.Clouds[cloud].provider(providers.vault, logger)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("clouds.%s.%w", cloud, err))
+			continue
+		}
+		if provider != nil {
+			logger.Info("Reading the cloud credentials from a credentials provider", "cloud", cloud, "provider", raw.Clouds[cloud].Provider)
+			providers.clouds[cloud] = provider
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	if providers.vault == nil && len(providers.clouds) == 0 {
+		return nil, nil
+	}
+	return providers, nil
+}
+
+// provider returns the Provider selected by c, nil when there is none.
+func (c providerYAML) provider(vault *VaultProvider, logger *slog.Logger) (Provider, error) {
+	switch c.Provider {
+	case "":
+		return nil, nil
+	case ProviderCloudsYAML:
+		return cloudsYAMLProvider{}, nil
+	case ProviderVault:
+		if vault == nil {
+			return nil, errors.New("credentials_provider: vault requires use_vault")
+		}
+		return vault, nil
+	case ProviderFile:
+		config := FileConfig{
+			PasswordFile:                    c.PasswordFile,
+			ApplicationCredentialIDFile:     c.ApplicationCredentialIDFile,
+			ApplicationCredentialNameFile:   c.ApplicationCredentialNameFile,
+			ApplicationCredentialSecretFile: c.ApplicationCredentialSecretFile,
+		}
+		if config == (FileConfig{}) {
+			return nil, errors.New("password_file: password_file or application_credential_*_file must be set with the file provider")
+		}
+		return NewFileProvider(config, logger), nil
+	case ProviderEnvFile:
+		if c.EnvFile == "" {
+			return nil, errors.New("env_file: must be set with the env_file provider")
+		}
+		return NewEnvFileProvider(c.EnvFile, logger), nil
+	case ProviderCommand:
+		command := strings.Fields(c.CredentialProcess)
+		if len(command) == 0 {
+			return nil, errors.New("credential_process: must be set with the command provider")
+		}
+		if c.CredentialProcessTimeout < 0 || c.CredentialProcessRefreshInterval < 0 {
+			return nil, errors.New("credential_process_timeout: the timeout and refresh interval must be positive")
+		}
+		return NewCommandProvider(CommandConfig{
+			Command:         command,
+			Timeout:         c.CredentialProcessTimeout,
+			RefreshInterval: c.CredentialProcessRefreshInterval,
+		}, logger)
+	default:
+		return nil, fmt.Errorf("credentials_provider: unknown provider %q, must be one of %s, %s, %s, %s, %s", c.Provider, ProviderCloudsYAML, ProviderVault, ProviderFile, ProviderEnvFile, ProviderCommand)
+	}
+}
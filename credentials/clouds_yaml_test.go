@@ -0,0 +1,189 @@
+package credentials
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVaultConfig(t *testing.T) {
+	config, err := ParseVaultConfig([]byte(`
+clouds:
+  prod:
+    auth:
+      auth_url: https://keystone.example.com:5000/v3
+`))
+	require.NoError(t, err)
+	assert.Nil(t, config, "Vault isn't used without use_vault")
+
+	// The settings of the single cloud AppRole integration keep working.
+	config, err = ParseVaultConfig([]byte(`
+use_vault: true
+vault_address: https://vault.example.com:8200
+vault_role_id: role
+vault_secret_id: secret
+vault_secret_path: openstack
+vault_secret_mount_path: kv
+credential_name_in_vault_secret: os_password
+clouds:
+  prod:
+    auth:
+      auth_url: https://keystone.example.com:5000/v3
+`))
+	require.NoError(t, err)
+	assert.Equal(t, &VaultConfig{
+		Address:         "https://vault.example.com:8200",
+		AuthMethod:      VaultAuthAppRole,
+		RoleID:          "role",
+		SecretID:        "secret",
+		RefreshInterval: DefaultVaultRefreshInterval,
+		Secret:          VaultSecret{Path: "openstack", MountPath: "kv", Key: "os_password"},
+		CloudSecrets:    map[string]VaultSecret{},
+	}, config)
+
+	config, err = ParseVaultConfig([]byte(`
+use_vault: true
+vault_address: https://vault.example.com:8200
+vault_auth_method: kubernetes
+vault_kubernetes_role: exporter
+vault_refresh_interval: 1m
+clouds:
+  prod:
+    vault_secret_path: openstack/prod
+  dev:
+    vault_secret_path: openstack/dev
+    credential_name_in_vault_secret: os_password
+  other: {}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, VaultAuthKubernetes, config.AuthMethod)
+	assert.Equal(t, time.Minute, config.RefreshInterval)
+	assert.Equal(t, map[string]VaultSecret{
+		"prod": {Path: "openstack/prod"},
+		"dev":  {Path: "openstack/dev", Key: "os_password"},
+	}, config.CloudSecrets)
+}
+
+func TestParseVaultConfigErrors(t *testing.T) {
+	_, err := ParseVaultConfig([]byte(`
+use_vault: true
+vault_auth_method: token
+vault_refresh_interval: 0s
+clouds:
+  prod:
+    credential_name_in_vault_secret: os_password
+`))
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "vault_address: must be set")
+	assert.ErrorContains(t, err, "vault_token: vault_token or vault_token_path must be set")
+	assert.ErrorContains(t, err, "vault_refresh_interval: must be positive")
+	assert.ErrorContains(t, err, "clouds.prod.vault_secret_path: must be set")
+
+	_, err = ParseVaultConfig([]byte(`
+use_vault: true
+vault_address: https://vault.example.com:8200
+vault_auth_method: userpass
+`))
+	assert.ErrorContains(t, err, `vault_auth_method: unknown auth method "userpass"`)
+	assert.ErrorContains(t, err, "vault_secret_path: must be set globally or for a cloud")
+}
+
+func TestParseProviders(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+
+	providers, err := ParseProviders([]byte(`
+clouds:
+  prod:
+    auth:
+      auth_url: https://keystone.example.com:5000/v3
+`), logger)
+	require.NoError(t, err)
+	assert.Nil(t, providers, "the credentials of clouds.yaml are used without a provider")
+
+	dir := t.TempDir()
+	passwordPath := filepath.Join(dir, "password")
+	require.NoError(t, os.WriteFile(passwordPath, []byte("file-password\n"), 0o600))
+	envPath := filepath.Join(dir, "openrc")
+	require.NoError(t, os.WriteFile(envPath, []byte("OS_PASSWORD=env-password\n"), 0o600))
+
+	providers, err = ParseProviders([]byte(`
+clouds:
+  file:
+    credentials_provider: file
+    password_file: `+passwordPath+`
+  env:
+    credentials_provider: env_file
+    env_file: `+envPath+`
+  command:
+    credentials_provider: command
+    credential_process: echo {"password":"command-password"}
+    credential_process_timeout: 5s
+  yaml: {}
+`), logger)
+	require.NoError(t, err)
+
+	for cloud, password := range map[string]string{"file": "file-password", "env": "env-password", "command": "command-password"} {
+		creds, err := providers.Credentials(context.Background(), cloud)
+		require.NoError(t, err)
+		assert.Equal(t, &Credentials{Password: password}, creds, cloud)
+	}
+	creds, err := providers.Credentials(context.Background(), "yaml")
+	require.NoError(t, err)
+	assert.Nil(t, creds)
+	assert.Equal(t, 5*time.Second, providers.clouds["command"].(*CommandProvider).config.Timeout)
+}
+
+func TestParseProvidersWithVault(t *testing.T) {
+	providers, err := ParseProviders([]byte(`
+use_vault: true
+vault_address: https://vault.example.com:8200
+vault_role_id: role
+vault_secret_path: openstack
+clouds:
+  prod: {}
+  dev:
+    credentials_provider: vault
+  test:
+    credentials_provider: clouds_yaml
+`), slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	assert.NotNil(t, providers.vault, "the clouds without a provider use Vault")
+	assert.Same(t, providers.vault, providers.clouds["dev"])
+	assert.Equal(t, cloudsYAMLProvider{}, providers.clouds["test"])
+	creds, err := providers.Credentials(context.Background(), "test")
+	require.NoError(t, err)
+	assert.Nil(t, creds)
+}
+
+func TestParseProvidersErrors(t *testing.T) {
+	_, err := ParseProviders([]byte(`
+clouds:
+  a:
+    credentials_provider: vault
+  b:
+    credentials_provider: file
+  c:
+    credentials_provider: env_file
+  d:
+    credentials_provider: command
+  e:
+    credentials_provider: command
+    credential_process: /bin/credentials
+    credential_process_timeout: -1s
+  f:
+    credentials_provider: keyring
+`), slog.New(slog.DiscardHandler))
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "clouds.a.credentials_provider: vault requires use_vault")
+	assert.ErrorContains(t, err, "clouds.b.password_file: password_file or application_credential_*_file must be set")
+	assert.ErrorContains(t, err, "clouds.c.env_file: must be set")
+	assert.ErrorContains(t, err, "clouds.d.credential_process: must be set")
+	assert.ErrorContains(t, err, "clouds.e.credential_process_timeout: the timeout and refresh interval must be positive")
+	assert.ErrorContains(t, err, `clouds.f.credentials_provider: unknown provider "keyring"`)
+}
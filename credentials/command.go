@@ -0,0 +1,156 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCommandTimeout is the time a credentials command is given to
+	// complete when no timeout is configured.
+	DefaultCommandTimeout = 30 * time.Second
+	// DefaultCommandRefreshInterval is the time the credentials of a
+	// command are cached when no refresh interval is configured and the
+	// command returns no expiration.
+	DefaultCommandRefreshInterval = 5 * time.Minute
+
+	// commandExpirationMargin is the time before their expiration at which
+	// the credentials of a command are requested again.
+	commandExpirationMargin = time.Minute
+)
+
+// CommandConfig is the configuration of a CommandProvider.
+type CommandConfig struct {
+	// Command is the program and the arguments run to get the
+	// credentials.
+	Command []string
+	// Timeout is the time the command is given to complete.
+	Timeout time.Duration
+	// RefreshInterval is the time the credentials are cached, unless they
+	// expire before.
+	RefreshInterval time.Duration
+}
+
+// commandOutput is the JSON object written by a credentials command on its
+// standard output.
+type commandOutput struct {
+	Password                    string     `json:"password"`
+	ApplicationCredentialID     string     `json:"application_credential_id"`
+	ApplicationCredentialName   string     `json:"application_credential_name"`
+	ApplicationCredentialSecret string     `json:"application_credential_secret"`
+	Expiration                  *time.Time `json:"expiration"`
+}
+
+// CommandProvider is a Provider running an external command to get the
+// Credentials, as the credential_process of the AWS tools. The command
+// writes a JSON object on its standard output:
+//
+//	{"password": "...", "expiration": "2024-01-02T15:04:05Z"}
+//
+// or, for an application credential:
+//
+//	{"application_credential_id": "...", "application_credential_secret": "..."}
+//
+// The optional expiration, in RFC 3339, shortens the time the credentials are
+// cached.
+type CommandProvider struct {
+	config CommandConfig
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	credentials *Credentials
+	expiry      time.Time
+}
+
+// NewCommandProvider returns a CommandProvider running config.Command.
+func NewCommandProvider(config CommandConfig, logger *slog.Logger) (*CommandProvider, error) {
+	if len(config.Command) == 0 {
+		return nil, errors.New("no credentials command")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultCommandTimeout
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = DefaultCommandRefreshInterval
+	}
+	return &CommandProvider{config: config, logger: logger}, nil
+}
+
+// Credentials returns the cached Credentials, running the command again once
+// they expire. When it fails, the cached ones are returned.
+func (p *CommandProvider) Credentials(ctx context.Context, cloud string) (*Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.credentials != nil && time.Now().Before(p.expiry) {
+		credentials := *p.credentials
+		return &credentials, nil
+	}
+
+	credentials, expiry, err := p.run(ctx, cloud)
+	if err != nil {
+		if p.credentials == nil {
+			return nil, fmt.Errorf("failed to get the credentials of %s from %s: %w", cloud, p.config.Command[0], err)
+		}
+		p.logger.Warn("Failed to run the credentials command, using the cached credentials", "cloud", cloud, "command", p.config.Command[0], "err", err)
+		cached := *p.credentials
+		return &cached, nil
+	}
+
+	p.credentials = &credentials
+	p.expiry = expiry
+	return &credentials, nil
+}
+
+// run runs the command and returns the Credentials it wrote with the time
+// they must be requested again.
+func (p *CommandProvider) run(ctx context.Context, cloud string) (Credentials, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.config.Command[0], p.config.Command[1:]...)
+	// The cloud lets a single command serve several clouds.
+	cmd.Env = append(cmd.Environ(), "OS_CLOUD="+cloud)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Don't wait for the children of a killed command still holding its
+	// output.
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return Credentials{}, time.Time{}, fmt.Errorf("%w: %s", err, message)
+		}
+		return Credentials{}, time.Time{}, err
+	}
+
+	var output commandOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return Credentials{}, time.Time{}, fmt.Errorf("invalid output: %w", err)
+	}
+	credentials := Credentials{
+		Password:                    output.Password,
+		ApplicationCredentialID:     output.ApplicationCredentialID,
+		ApplicationCredentialName:   output.ApplicationCredentialName,
+		ApplicationCredentialSecret: output.ApplicationCredentialSecret,
+	}
+	if credentials == (Credentials{}) {
+		return Credentials{}, time.Time{}, errors.New("invalid output: no password or application credential")
+	}
+
+	expiry := time.Now().Add(p.config.RefreshInterval)
+	if output.Expiration != nil {
+		if expiration := output.Expiration.Add(-commandExpirationMargin); expiration.Before(expiry) {
+			expiry = expiration
+		}
+	}
+	return credentials, expiry, nil
+}
@@ -0,0 +1,104 @@
+package credentials
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeScript writes a shell script to a temporary directory and returns its
+// path.
+func writeScript(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "credentials.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o700))
+	return path
+}
+
+func TestCommandProvider(t *testing.T) {
+	dir := t.TempDir()
+	countPath := filepath.Join(dir, "count")
+	script := writeScript(t, `echo run >> "$1"
+echo "{\"password\": \"password-of-$OS_CLOUD\"}"
+`)
+
+	provider, err := NewCommandProvider(CommandConfig{Command: []string{script, countPath}, RefreshInterval: time.Hour}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	for range 2 {
+		creds, err := provider.Credentials(context.Background(), "prod")
+		require.NoError(t, err)
+		assert.Equal(t, &Credentials{Password: "password-of-prod"}, creds)
+	}
+	count, err := os.ReadFile(countPath)
+	require.NoError(t, err)
+	assert.Equal(t, "run\n", string(count), "the credentials are cached for the refresh interval")
+}
+
+func TestCommandProviderExpiration(t *testing.T) {
+	countPath := filepath.Join(t.TempDir(), "count")
+	// The credentials expire within the expiration margin, so they are
+	// requested again on each call.
+	expiration := time.Now().Add(30 * time.Second).UTC().Format(time.RFC3339)
+	script := writeScript(t, `echo run >> "$1"
+echo '{"application_credential_id": "app-id", "application_credential_secret": "app-secret", "expiration": "`+expiration+`"}'
+`)
+
+	provider, err := NewCommandProvider(CommandConfig{Command: []string{script, countPath}, RefreshInterval: time.Hour}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	for range 2 {
+		creds, err := provider.Credentials(context.Background(), "prod")
+		require.NoError(t, err)
+		assert.Equal(t, &Credentials{ApplicationCredentialID: "app-id", ApplicationCredentialSecret: "app-secret"}, creds)
+	}
+	count, err := os.ReadFile(countPath)
+	require.NoError(t, err)
+	assert.Equal(t, "run\nrun\n", string(count))
+}
+
+func TestCommandProviderFailures(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	failPath := filepath.Join(t.TempDir(), "fail")
+	script := writeScript(t, `if [ -e "$1" ]; then
+  echo "token expired" >&2
+  exit 1
+fi
+echo '{"password": "secret"}'
+`)
+
+	provider, err := NewCommandProvider(CommandConfig{Command: []string{script, failPath}, RefreshInterval: time.Millisecond}, logger)
+	require.NoError(t, err)
+	_, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(failPath, nil, 0o600))
+	time.Sleep(2 * time.Millisecond)
+	creds, err := provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err, "the cached credentials are used while the command fails")
+	assert.Equal(t, &Credentials{Password: "secret"}, creds)
+
+	provider, err = NewCommandProvider(CommandConfig{Command: []string{script, failPath}}, logger)
+	require.NoError(t, err)
+	_, err = provider.Credentials(context.Background(), "prod")
+	assert.ErrorContains(t, err, "token expired")
+
+	provider, err = NewCommandProvider(CommandConfig{Command: []string{writeScript(t, "echo '{}'")}}, logger)
+	require.NoError(t, err)
+	_, err = provider.Credentials(context.Background(), "prod")
+	assert.ErrorContains(t, err, "no password or application credential")
+
+	provider, err = NewCommandProvider(CommandConfig{Command: []string{writeScript(t, "exec sleep 10")}, Timeout: 10 * time.Millisecond}, logger)
+	require.NoError(t, err)
+	_, err = provider.Credentials(context.Background(), "prod")
+	assert.ErrorContains(t, err, "signal: killed")
+
+	_, err = NewCommandProvider(CommandConfig{}, logger)
+	assert.EqualError(t, err, "no credentials command")
+}
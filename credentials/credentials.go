@@ -0,0 +1,139 @@
+// Package credentials provides the OpenStack credentials of the clouds from
+// sources other than clouds.yaml: Vault, files such as Kubernetes secret
+// mounts, environment files and external commands. The credentials of a
+// Provider replace the ones of the cloud entry in clouds.yaml. The exporter
+// reads them when it authenticates a cloud, then periodically, on every
+// re-authentication and as soon as a watched file changes, and authenticates
+// the cloud again when they changed, so a rotated secret is picked up without
+// restarting the exporter or waiting for a scrape to fail.
+//
+// The provider of a cloud is selected by the credentials_provider key of its
+// clouds.yaml entry:
+//
+//	clouds:
+//	  prod:
+//	    credentials_provider: file
+//	    password_file: /var/run/secrets/openstack/password
+//	    auth:
+//	      auth_url: https://keystone.example.com:5000/v3
+//	      username: exporter
+package credentials
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+)
+
+// Credentials are the secrets used to authenticate against Keystone, a
+// password or an application credential.
+type Credentials struct {
+	Password                    string
+	ApplicationCredentialID     string
+	ApplicationCredentialName   string
+	ApplicationCredentialSecret string
+}
+
+// Apply replaces the secrets of authInfo with the non empty Credentials. An
+// application credential replaces the password of clouds.yaml, which would
+// otherwise take precedence, and a password replaces its application
+// credential.
+func (c Credentials) Apply(authInfo *clientconfig.AuthInfo) {
+	applicationCredential := c.ApplicationCredentialID != "" || c.ApplicationCredentialName != "" || c.ApplicationCredentialSecret != ""
+	switch {
+	case applicationCredential:
+		authInfo.Password = ""
+	case c.Password != "":
+		authInfo.ApplicationCredentialID = ""
+		authInfo.ApplicationCredentialName = ""
+		authInfo.ApplicationCredentialSecret = ""
+	}
+
+	if c.Password != "" {
+		authInfo.Password = c.Password
+	}
+	if c.ApplicationCredentialID != "" {
+		authInfo.ApplicationCredentialID = c.ApplicationCredentialID
+	}
+	if c.ApplicationCredentialName != "" {
+		authInfo.ApplicationCredentialName = c.ApplicationCredentialName
+	}
+	if c.ApplicationCredentialSecret != "" {
+		authInfo.ApplicationCredentialSecret = c.ApplicationCredentialSecret
+	}
+}
+
+// Provider returns the Credentials of the clouds.
+type Provider interface {
+	// Credentials returns the Credentials of cloud, nil when the provider
+	// has none for it and the ones of clouds.yaml are used.
+	Credentials(ctx context.Context, cloud string) (*Credentials, error)
+}
+
+// Notifier is implemented by the Providers telling when the Credentials they
+// provide may have changed, so they are read again without waiting.
+type Notifier interface {
+	// Changes returns a channel receiving a value when the Credentials of a
+	// cloud may have changed.
+	Changes() <-chan struct{}
+}
+
+// Providers selects the Provider of each cloud.
+type Providers struct {
+	clouds map[string]Provider
+	// vault provides the credentials of the clouds without a provider of
+	// their own when Vault is used.
+	vault   *VaultProvider
+	changes chan struct{}
+	logger  *slog.Logger
+}
+
+// Changes returns a channel receiving a value when the files of a cloud
+// changed, once Run watches them.
+func (p *Providers) Changes() <-chan struct{} {
+	return p.changes
+}
+
+// notify sends a value to the changes channel, unless one is pending.
+func (p *Providers) notify() {
+	select {
+	case p.changes <- struct{}{}:
+	default:
+	}
+}
+
+// Credentials returns the Credentials of cloud from its provider.
+func (p *Providers) Credentials(ctx context.Context, cloud string) (*Credentials, error) {
+	if provider, ok := p.clouds[cloud]; ok {
+		return provider.Credentials(ctx, cloud)
+	}
+	if p.vault != nil {
+		return p.vault.Credentials(ctx, cloud)
+	}
+	return nil, nil
+}
+
+// Run refreshes the credentials of the providers doing it in the background
+// and watches the files of the file providers until ctx is done. The files
+// that can't be watched are still read again when their modification time
+// changes.
+func (p *Providers) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	if p.vault != nil {
+		wg.Go(func() { p.vault.Run(ctx) })
+	}
+	for cloud, provider := range p.clouds {
+		files, ok := provider.(*watchedFiles)
+		if !ok {
+			continue
+		}
+		wg.Go(func() {
+			if err := files.watch(ctx, p.notify); err != nil {
+				p.logger.Warn("Failed to watch the credentials files, polling them", "cloud", cloud, "err", err)
+			}
+		})
+	}
+	wg.Wait()
+}
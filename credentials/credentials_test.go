@@ -0,0 +1,29 @@
+package credentials
+
+import (
+	"testing"
+
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsApply(t *testing.T) {
+	authInfo := clientconfig.AuthInfo{Username: "exporter", Password: "yaml-password"}
+	Credentials{ApplicationCredentialID: "app-id", ApplicationCredentialSecret: "app-secret"}.Apply(&authInfo)
+	assert.Equal(t, clientconfig.AuthInfo{
+		Username:                    "exporter",
+		ApplicationCredentialID:     "app-id",
+		ApplicationCredentialSecret: "app-secret",
+	}, authInfo, "the application credential replaces the password")
+
+	Credentials{Password: "password"}.Apply(&authInfo)
+	assert.Equal(t, clientconfig.AuthInfo{Username: "exporter", Password: "password"}, authInfo, "the password replaces the application credential")
+
+	authInfo = clientconfig.AuthInfo{Username: "exporter", ApplicationCredentialName: "exporter", ApplicationCredentialSecret: "yaml-secret"}
+	Credentials{ApplicationCredentialSecret: "rotated-secret"}.Apply(&authInfo)
+	assert.Equal(t, clientconfig.AuthInfo{
+		Username:                    "exporter",
+		ApplicationCredentialName:   "exporter",
+		ApplicationCredentialSecret: "rotated-secret",
+	}, authInfo, "the other fields of the application credential are kept")
+}
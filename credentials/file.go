@@ -0,0 +1,237 @@
+package credentials
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// FileConfig lists the files holding the secrets of a cloud, one per secret
+// as in a Kubernetes secret mount. Empty paths are left out.
+type FileConfig struct {
+	PasswordFile                    string
+	ApplicationCredentialIDFile     string
+	ApplicationCredentialNameFile   string
+	ApplicationCredentialSecretFile string
+}
+
+// NewFileProvider returns a Provider reading the Credentials from the files
+// of config, read again when one of them was modified since the last call or,
+// once run by Providers.Run, as soon as one of them changes.
+func NewFileProvider(config FileConfig, logger *slog.Logger) Provider {
+	var paths []string
+	for _, path := range []string{config.PasswordFile, config.ApplicationCredentialIDFile, config.ApplicationCredentialNameFile, config.ApplicationCredentialSecretFile} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return newWatchedFiles(paths, logger, func(read func(path string) ([]byte, error)) (Credentials, error) {
+		var credentials Credentials
+		for _, file := range []struct {
+			path  string
+			field *string
+		}{
+			{config.PasswordFile, &credentials.Password},
+			{config.ApplicationCredentialIDFile, &credentials.ApplicationCredentialID},
+			{config.ApplicationCredentialNameFile, &credentials.ApplicationCredentialName},
+			{config.ApplicationCredentialSecretFile, &credentials.ApplicationCredentialSecret},
+		} {
+			if file.path == "" {
+				continue
+			}
+			content, err := read(file.path)
+			if err != nil {
+				return Credentials{}, err
+			}
+			// Secrets written by hand usually end with a new line.
+			*file.field = strings.TrimRight(string(content), "\r\n")
+		}
+		return credentials, nil
+	})
+}
+
+// envFileKeys maps the variables of an environment file to the Credentials
+// field they set.
+var envFileKeys = map[string]func(*Credentials, string){
+	"OS_PASSWORD":                      func(c *Credentials, v string) { c.Password = v },
+	"OS_APPLICATION_CREDENTIAL_ID":     func(c *Credentials, v string) { c.ApplicationCredentialID = v },
+	"OS_APPLICATION_CREDENTIAL_NAME":   func(c *Credentials, v string) { c.ApplicationCredentialName = v },
+	"OS_APPLICATION_CREDENTIAL_SECRET": func(c *Credentials, v string) { c.ApplicationCredentialSecret = v },
+}
+
+// NewEnvFileProvider returns a Provider reading the Credentials from the
+// OS_PASSWORD and OS_APPLICATION_CREDENTIAL_* variables of the environment
+// file at path, as sourced by a shell or an openrc file, read again whenever
+// it is modified. The other variables are ignored.
+func NewEnvFileProvider(path string, logger *slog.Logger) Provider {
+	return newWatchedFiles([]string{path}, logger, func(read func(path string) ([]byte, error)) (Credentials, error) {
+		content, err := read(path)
+		if err != nil {
+			return Credentials{}, err
+		}
+		return parseEnvFile(content)
+	})
+}
+
+// parseEnvFile reads the Credentials set by the KEY=VALUE lines of content.
+// Values can be quoted, lines can start with export and lines starting with #
+// are comments.
+func parseEnvFile(content []byte) (Credentials, error) {
+	var credentials Credentials
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+		if !ok {
+			return Credentials{}, fmt.Errorf("line %d: expected KEY=VALUE", line)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return Credentials{}, fmt.Errorf("line %d: invalid quoted value of %s", line, key)
+			}
+			value = unquoted
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		}
+
+		if set, ok := envFileKeys[key]; ok {
+			set(&credentials, value)
+		}
+	}
+
+	return credentials, scanner.Err()
+}
+
+// watchedFiles caches the Credentials parsed from files, parsing them again
+// when the modification time of one of them changes or watch noticed a change.
+type watchedFiles struct {
+	paths  []string
+	parse  func(read func(path string) ([]byte, error)) (Credentials, error)
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	modTimes    map[string]time.Time
+	credentials *Credentials
+	// dirty is set by watch when the files changed since they were parsed.
+	dirty bool
+}
+
+func newWatchedFiles(paths []string, logger *slog.Logger, parse func(read func(path string) ([]byte, error)) (Credentials, error)) *watchedFiles {
+	return &watchedFiles{paths: paths, parse: parse, logger: logger}
+}
+
+// watch marks the Credentials to be parsed again and calls notify whenever
+// one of the files changes, until ctx is done. The directories of the files
+// are watched rather than the files, so the replacement of a file by a
+// rename is seen too, including the one of the ..data link of a Kubernetes
+// secret mount, which keeps the modification times.
+func (w *watchedFiles) watch(ctx context.Context, notify func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	names := make(map[string]bool)
+	for _, path := range w.paths {
+		names[filepath.Clean(path)] = true
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", filepath.Dir(path), err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op == fsnotify.Chmod || !names[filepath.Clean(event.Name)] && !strings.HasPrefix(filepath.Base(event.Name), "..") {
+				continue
+			}
+			w.mu.Lock()
+			w.dirty = true
+			w.mu.Unlock()
+			notify()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			w.logger.Warn("Failed to watch the credentials files", "err", err)
+		}
+	}
+}
+
+// Credentials returns the cached Credentials, parsed again from the files
+// when they were modified. When they can't be, the cached ones are returned.
+func (w *watchedFiles) Credentials(ctx context.Context, cloud string) (*Credentials, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.credentials != nil && !w.dirty && !w.changed() {
+		credentials := *w.credentials
+		return &credentials, nil
+	}
+
+	modTimes := make(map[string]time.Time)
+	credentials, err := w.parse(func(path string) ([]byte, error) {
+		// Kubernetes updates a secret mount by replacing the symbolic link
+		// of its files, which Stat follows.
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[path] = info.ModTime()
+		return os.ReadFile(path)
+	})
+	if err != nil {
+		if w.credentials == nil {
+			return nil, fmt.Errorf("failed to read the credentials of %s: %w", cloud, err)
+		}
+		w.logger.Warn("Failed to read the credentials files, using the cached credentials", "cloud", cloud, "err", err)
+		cached := *w.credentials
+		return &cached, nil
+	}
+
+	if w.credentials != nil {
+		w.logger.Info("Credentials files modified, using the new credentials", "cloud", cloud)
+	}
+	w.modTimes = modTimes
+	w.credentials = &credentials
+	w.dirty = false
+	return &credentials, nil
+}
+
+// changed reports whether a file was modified since it was parsed. w.mu
+// must be held.
+func (w *watchedFiles) changed() bool {
+	for path, modTime := range w.modTimes {
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
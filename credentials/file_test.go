@@ -0,0 +1,157 @@
+package credentials
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile writes content to path with a modification time distinct from
+// the previous one, however coarse the file system clock is.
+func writeFile(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	idPath := filepath.Join(dir, "application_credential_id")
+	secretPath := filepath.Join(dir, "application_credential_secret")
+	now := time.Now()
+	writeFile(t, idPath, "app-id\n", now)
+	writeFile(t, secretPath, "app-secret\r\n", now)
+
+	provider := NewFileProvider(FileConfig{
+		ApplicationCredentialIDFile:     idPath,
+		ApplicationCredentialSecretFile: secretPath,
+	}, slog.New(slog.DiscardHandler))
+
+	creds, err := provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{ApplicationCredentialID: "app-id", ApplicationCredentialSecret: "app-secret"}, creds)
+
+	// The secret is rotated.
+	writeFile(t, secretPath, "rotated-secret", now.Add(time.Second))
+	creds, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{ApplicationCredentialID: "app-id", ApplicationCredentialSecret: "rotated-secret"}, creds)
+
+	// The secret is removed while it is rotated again.
+	require.NoError(t, os.Remove(secretPath))
+	creds, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err, "the cached credentials are used while a file is missing")
+	assert.Equal(t, &Credentials{ApplicationCredentialID: "app-id", ApplicationCredentialSecret: "rotated-secret"}, creds)
+
+	_, err = NewFileProvider(FileConfig{PasswordFile: filepath.Join(dir, "password")}, slog.New(slog.DiscardHandler)).Credentials(context.Background(), "dev")
+	assert.ErrorContains(t, err, "failed to read the credentials of dev")
+}
+
+func TestFileProviderFollowsSymlinks(t *testing.T) {
+	// Kubernetes mounts the files of a secret as links to a directory it
+	// replaces on each update.
+	dir := t.TempDir()
+	now := time.Now()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "..v1"), 0o700))
+	writeFile(t, filepath.Join(dir, "..v1", "password"), "first", now)
+	require.NoError(t, os.Symlink("..v1", filepath.Join(dir, "..data")))
+	require.NoError(t, os.Symlink(filepath.Join("..data", "password"), filepath.Join(dir, "password")))
+
+	provider := NewFileProvider(FileConfig{PasswordFile: filepath.Join(dir, "password")}, slog.New(slog.DiscardHandler))
+	creds, err := provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "first"}, creds)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "..v2"), 0o700))
+	writeFile(t, filepath.Join(dir, "..v2", "password"), "second", now.Add(time.Second))
+	require.NoError(t, os.Symlink("..v2", filepath.Join(dir, "..data_tmp")))
+	require.NoError(t, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+
+	creds, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "second"}, creds)
+}
+
+func TestProvidersWatchFiles(t *testing.T) {
+	dir := t.TempDir()
+	passwordPath := filepath.Join(dir, "password")
+	modTime := time.Now().Add(-time.Hour)
+	writeFile(t, passwordPath, "first", modTime)
+
+	providers, err := ParseProviders([]byte(`
+clouds:
+  prod:
+    credentials_provider: file
+    password_file: `+passwordPath+`
+`), slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	creds, err := providers.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "first"}, creds)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		providers.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The file is replaced by a rename keeping its modification time, which
+	// only the watch notices. It is replaced until the watch is set up.
+	require.Eventually(t, func() bool {
+		tmp := filepath.Join(dir, "password.tmp")
+		writeFile(t, tmp, "rotated", modTime)
+		require.NoError(t, os.Rename(tmp, passwordPath))
+		select {
+		case <-providers.Changes():
+			return true
+		case <-time.After(50 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	creds, err = providers.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "rotated"}, creds)
+}
+
+func TestEnvFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openrc")
+	now := time.Now()
+	writeFile(t, path, `# Generated by the secrets operator
+export OS_AUTH_URL=https://keystone.example.com:5000/v3
+export OS_USERNAME=exporter
+export OS_PASSWORD="pass\"word"
+
+`, now)
+
+	provider := NewEnvFileProvider(path, slog.New(slog.DiscardHandler))
+	creds, err := provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: `pass"word`}, creds)
+
+	writeFile(t, path, `OS_APPLICATION_CREDENTIAL_NAME='exporter'
+OS_APPLICATION_CREDENTIAL_SECRET = secret
+`, now.Add(time.Second))
+	creds, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{ApplicationCredentialName: "exporter", ApplicationCredentialSecret: "secret"}, creds)
+}
+
+func TestParseEnvFileErrors(t *testing.T) {
+	_, err := parseEnvFile([]byte("OS_PASSWORD=secret\nOS_USERNAME\n"))
+	assert.EqualError(t, err, "line 2: expected KEY=VALUE")
+
+	_, err = parseEnvFile([]byte(`OS_PASSWORD="\q"`))
+	assert.EqualError(t, err, "line 1: invalid quoted value of OS_PASSWORD")
+}
@@ -0,0 +1,304 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault-client-go"
+	"github.com/hashicorp/vault-client-go/schema"
+)
+
+// Vault auth methods.
+const (
+	VaultAuthAppRole    = "approle"
+	VaultAuthKubernetes = "kubernetes"
+	VaultAuthToken      = "token"
+)
+
+// DefaultVaultRefreshInterval is the default VaultConfig.RefreshInterval.
+const DefaultVaultRefreshInterval = 5 * time.Minute
+
+// DefaultKubernetesTokenPath is where the service account token is mounted
+// in a Kubernetes pod.
+const DefaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// vaultTokenRenewMargin is how long before the expiry of the Vault token a
+// new one is requested, on top of the refresh interval.
+const vaultTokenRenewMargin = time.Minute
+
+// VaultSecret locates the password of a cloud in a KV v2 secret.
+type VaultSecret struct {
+	Path string
+	// MountPath is the mount path of the KV v2 secrets engine, secret by
+	// default.
+	MountPath string
+	// Key is the key of the password in the secret, password by default.
+	Key string
+}
+
+// VaultConfig configures a VaultProvider.
+type VaultConfig struct {
+	Address string
+	// AuthMethod is one of VaultAuthAppRole, VaultAuthKubernetes and
+	// VaultAuthToken.
+	AuthMethod string
+	// AuthMountPath is the mount path of the auth method, its name by
+	// default.
+	AuthMountPath string
+	// RoleID and SecretID are the credentials of the AppRole auth method.
+	RoleID   string
+	SecretID string
+	// KubernetesRole is the role of the Kubernetes auth method, logging in
+	// with the service account token read from KubernetesTokenPath.
+	KubernetesRole      string
+	KubernetesTokenPath string
+	// Token is the token of the token auth method, read from TokenPath on
+	// each refresh when empty, so a token renewed by a Vault agent is used.
+	Token     string
+	TokenPath string
+	// RefreshInterval is how long the credentials are used before being read
+	// again.
+	RefreshInterval time.Duration
+	// Secret holds the password of the clouds without one in CloudSecrets,
+	// none when its Path is empty.
+	Secret       VaultSecret
+	CloudSecrets map[string]VaultSecret
+}
+
+type cachedCredentials struct {
+	credentials Credentials
+	time        time.Time
+}
+
+// VaultProvider reads the passwords of the clouds from the KV v2 secrets of
+// Vault. Credentials are cached for the refresh interval, and the last ones
+// read keep being used when Vault can't be reached.
+type VaultProvider struct {
+	config VaultConfig
+	client *vault.Client
+	logger *slog.Logger
+
+	// mu serializes the Vault requests and guards the fields below.
+	mu          sync.Mutex
+	hasToken    bool
+	tokenExpiry time.Time
+	cache       map[string]cachedCredentials
+}
+
+// NewVaultProvider returns a VaultProvider for config, logging in on the
+// first read.
+func NewVaultProvider(config VaultConfig, logger *slog.Logger) (*VaultProvider, error) {
+	switch config.AuthMethod {
+	case VaultAuthAppRole, VaultAuthKubernetes, VaultAuthToken:
+	default:
+		return nil, fmt.Errorf("unknown Vault auth method %q", config.AuthMethod)
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = DefaultVaultRefreshInterval
+	}
+	if config.KubernetesTokenPath == "" {
+		config.KubernetesTokenPath = DefaultKubernetesTokenPath
+	}
+
+	client, err := vault.New(vault.WithAddress(config.Address))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Vault client: %w", err)
+	}
+
+	return &VaultProvider{
+		config: config,
+		client: client,
+		logger: logger,
+		cache:  make(map[string]cachedCredentials),
+	}, nil
+}
+
+// Credentials returns the password of cloud, read from Vault when it hasn't
+// been for the refresh interval. When Vault fails, the last password read is
+// returned.
+func (p *VaultProvider) Credentials(ctx context.Context, cloud string) (*Credentials, error) {
+	secret, ok := p.secret(cloud)
+	if !ok {
+		return nil, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cached, ok := p.cache[cloud]; ok && time.Since(cached.time) < p.config.RefreshInterval {
+		credentials := cached.credentials
+		return &credentials, nil
+	}
+	return p.refresh(ctx, cloud, secret)
+}
+
+// Run reads again the password of every cloud read so far each refresh
+// interval, until ctx is done.
+func (p *VaultProvider) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		for cloud := range p.cache {
+			if secret, ok := p.secret(cloud); ok {
+				_, _ = p.refresh(ctx, cloud, secret)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// refresh reads the password of cloud from Vault, falling back to the cached
+// one. p.mu must be held.
+func (p *VaultProvider) refresh(ctx context.Context, cloud string, secret VaultSecret) (*Credentials, error) {
+	password, err := p.read(ctx, secret)
+	if err != nil {
+		cached, ok := p.cache[cloud]
+		if !ok {
+			return nil, fmt.Errorf("failed to read the credentials of %s from Vault: %w", cloud, err)
+		}
+		p.logger.Warn("Failed to refresh the credentials from Vault, using the cached ones", "cloud", cloud, "cached_at", cached.time, "err", err)
+		credentials := cached.credentials
+		return &credentials, nil
+	}
+
+	credentials := Credentials{Password: password}
+	p.cache[cloud] = cachedCredentials{credentials: credentials, time: time.Now()}
+	p.logger.Debug("Read the credentials from Vault", "cloud", cloud, "path", secret.Path)
+	return &credentials, nil
+}
+
+// secret returns the VaultSecret holding the password of cloud.
+func (p *VaultProvider) secret(cloud string) (VaultSecret, bool) {
+	secret := p.config.Secret
+	if cloudSecret, ok := p.config.CloudSecrets[cloud]; ok {
+		secret.Path = cloudSecret.Path
+		if cloudSecret.MountPath != "" {
+			secret.MountPath = cloudSecret.MountPath
+		}
+		if cloudSecret.Key != "" {
+			secret.Key = cloudSecret.Key
+		}
+	}
+	if secret.Path == "" {
+		return VaultSecret{}, false
+	}
+	if secret.MountPath == "" {
+		secret.MountPath = "secret"
+	}
+	if secret.Key == "" {
+		secret.Key = "password"
+	}
+	return secret, true
+}
+
+// read returns the password held by secret, logging in first when the Vault
+// token is missing or about to expire. p.mu must be held.
+func (p *VaultProvider) read(ctx context.Context, secret VaultSecret) (string, error) {
+	if err := p.ensureToken(ctx); err != nil {
+		return "", err
+	}
+
+	resp, err := p.client.Secrets.KvV2Read(ctx, secret.Path, vault.WithMountPath(secret.MountPath))
+	if err != nil {
+		// The token may have been revoked, log in again on the next read.
+		p.hasToken = false
+		return "", fmt.Errorf("failed to read secret %s: %w", secret.Path, err)
+	}
+
+	password, ok := resp.Data.Data[secret.Key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no %q string key", secret.Path, secret.Key)
+	}
+	return password, nil
+}
+
+// ensureToken logs in to Vault unless the current token is still valid for
+// the next refresh. p.mu must be held.
+func (p *VaultProvider) ensureToken(ctx context.Context) error {
+	if p.hasToken {
+		if p.config.AuthMethod == VaultAuthToken && p.config.Token == "" {
+			// Pick up the token renewed in TokenPath.
+			p.hasToken = false
+		} else if !p.tokenExpiry.IsZero() && time.Until(p.tokenExpiry) < p.config.RefreshInterval+vaultTokenRenewMargin {
+			p.hasToken = false
+		}
+	}
+	if p.hasToken {
+		return nil
+	}
+
+	token, leaseDuration, err := p.login(ctx)
+	if err != nil {
+		return err
+	}
+	if err := p.client.SetToken(token); err != nil {
+		return fmt.Errorf("failed to set Vault token: %w", err)
+	}
+
+	p.hasToken = true
+	p.tokenExpiry = time.Time{}
+	if leaseDuration > 0 {
+		p.tokenExpiry = time.Now().Add(leaseDuration)
+	}
+	return nil
+}
+
+// login returns a Vault token from the auth method and how long it is valid
+// for, zero when it doesn't expire or isn't known.
+func (p *VaultProvider) login(ctx context.Context) (string, time.Duration, error) {
+	mountPath := p.config.AuthMountPath
+	if mountPath == "" {
+		mountPath = p.config.AuthMethod
+	}
+
+	var resp *vault.Response[map[string]interface{}]
+	var err error
+	switch p.config.AuthMethod {
+	case VaultAuthToken:
+		if p.config.Token != "" {
+			return p.config.Token, 0, nil
+		}
+		token, err := os.ReadFile(p.config.TokenPath)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read Vault token: %w", err)
+		}
+		return strings.TrimSpace(string(token)), 0, nil
+	case VaultAuthAppRole:
+		resp, err = p.client.Auth.AppRoleLogin(ctx, schema.AppRoleLoginRequest{
+			RoleId:   p.config.RoleID,
+			SecretId: p.config.SecretID,
+		}, vault.WithMountPath(mountPath))
+	case VaultAuthKubernetes:
+		var jwt []byte
+		jwt, err = os.ReadFile(p.config.KubernetesTokenPath)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read Kubernetes service account token: %w", err)
+		}
+		resp, err = p.client.Auth.KubernetesLogin(ctx, schema.KubernetesLoginRequest{
+			Jwt:  strings.TrimSpace(string(jwt)),
+			Role: p.config.KubernetesRole,
+		}, vault.WithMountPath(mountPath))
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to login to Vault: %w", err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return "", 0, errors.New("failed to login to Vault: no token returned")
+	}
+
+	return resp.Auth.ClientToken, time.Duration(resp.Auth.LeaseDuration) * time.Second, nil
+}
@@ -0,0 +1,285 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault is a Vault server supporting the AppRole and Kubernetes logins
+// and the reads of KV v2 secrets.
+type fakeVault struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	secrets       map[string]map[string]any
+	leaseDuration int
+	denied        bool
+	tokens        map[string]bool
+	logins        int
+	reads         int
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	v := &fakeVault{
+		secrets:       make(map[string]map[string]any),
+		leaseDuration: 86400,
+		tokens:        map[string]bool{"static-token": true},
+	}
+	v.Server = httptest.NewServer(http.HandlerFunc(v.serveHTTP))
+	t.Cleanup(v.Close)
+	return v
+}
+
+func (v *fakeVault) setSecret(path string, data map[string]any) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.secrets[path] = data
+}
+
+func (v *fakeVault) setDenied(denied bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.denied = denied
+}
+
+func (v *fakeVault) counts() (logins, reads int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.logins, v.reads
+}
+
+func (v *fakeVault) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	deny := func() {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+	}
+	if v.denied {
+		deny()
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v1/auth/"):
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		valid := r.URL.Path == "/v1/auth/approle/login" && body["role_id"] == "role" && body["secret_id"] == "secret" ||
+			r.URL.Path == "/v1/auth/k8s/login" && body["role"] == "exporter" && body["jwt"] == "service-account-jwt"
+		if !valid {
+			deny()
+			return
+		}
+		v.logins++
+		token := "token-" + string(rune('0'+v.logins))
+		v.tokens[token] = true
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"data": nil,
+			"auth": map[string]any{"client_token": token, "lease_duration": v.leaseDuration},
+		})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		if !v.tokens[r.Header.Get("X-Vault-Token")] {
+			deny()
+			return
+		}
+		data, ok := v.secrets[strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		v.reads++
+		_ = json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": data}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVaultProviderAppRole(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.setSecret("openstack/default", map[string]any{"password": "default-password"})
+	vault.setSecret("openstack/prod", map[string]any{"os_password": "prod-password"})
+
+	provider, err := NewVaultProvider(VaultConfig{
+		Address:         vault.URL,
+		AuthMethod:      VaultAuthAppRole,
+		RoleID:          "role",
+		SecretID:        "secret",
+		RefreshInterval: time.Hour,
+		Secret:          VaultSecret{Path: "openstack/default"},
+		CloudSecrets:    map[string]VaultSecret{"prod": {Path: "openstack/prod", Key: "os_password"}},
+	}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	creds, err := provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "prod-password"}, creds)
+
+	creds, err = provider.Credentials(context.Background(), "dev")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "default-password"}, creds)
+
+	_, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	logins, reads := vault.counts()
+	assert.Equal(t, 1, logins, "the token is reused")
+	assert.Equal(t, 2, reads, "the credentials are cached for the refresh interval")
+}
+
+func TestVaultProviderWithoutSecret(t *testing.T) {
+	provider, err := NewVaultProvider(VaultConfig{
+		Address:      "http://127.0.0.1:0",
+		AuthMethod:   VaultAuthAppRole,
+		CloudSecrets: map[string]VaultSecret{"prod": {Path: "openstack/prod"}},
+	}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	creds, err := provider.Credentials(context.Background(), "dev")
+	assert.NoError(t, err)
+	assert.Nil(t, creds, "clouds without secret use clouds.yaml")
+
+	_, err = NewVaultProvider(VaultConfig{AuthMethod: "userpass"}, slog.New(slog.DiscardHandler))
+	assert.ErrorContains(t, err, "unknown Vault auth method")
+}
+
+func TestVaultProviderFallsBackToCachedCredentials(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.setSecret("openstack/prod", map[string]any{"password": "prod-password"})
+
+	provider, err := NewVaultProvider(VaultConfig{
+		Address:         vault.URL,
+		AuthMethod:      VaultAuthAppRole,
+		RoleID:          "role",
+		SecretID:        "secret",
+		RefreshInterval: time.Millisecond,
+		Secret:          VaultSecret{Path: "openstack/prod"},
+	}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	_, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+
+	vault.setDenied(true)
+	time.Sleep(2 * time.Millisecond)
+	creds, err := provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err, "the cached credentials are used while Vault fails")
+	assert.Equal(t, &Credentials{Password: "prod-password"}, creds)
+
+	_, err = provider.Credentials(context.Background(), "dev")
+	assert.ErrorContains(t, err, "failed to read the credentials of dev from Vault")
+
+	vault.setDenied(false)
+	vault.setSecret("openstack/prod", map[string]any{"password": "rotated-password"})
+	creds, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "rotated-password"}, creds)
+	logins, _ := vault.counts()
+	assert.Equal(t, 2, logins, "a new token is requested after a denied read")
+}
+
+func TestVaultProviderRenewsTokenBeforeExpiry(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.leaseDuration = 30
+	vault.setSecret("openstack/prod", map[string]any{"password": "prod-password"})
+
+	provider, err := NewVaultProvider(VaultConfig{
+		Address:         vault.URL,
+		AuthMethod:      VaultAuthAppRole,
+		RoleID:          "role",
+		SecretID:        "secret",
+		RefreshInterval: time.Millisecond,
+		Secret:          VaultSecret{Path: "openstack/prod"},
+	}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	for range 3 {
+		_, err := provider.Credentials(context.Background(), "prod")
+		require.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+	}
+	logins, reads := vault.counts()
+	assert.Equal(t, 3, reads)
+	assert.Equal(t, 3, logins, "a token expiring within a minute is renewed before each read")
+}
+
+func TestVaultProviderKubernetesAndTokenAuth(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.setSecret("openstack/prod", map[string]any{"password": "prod-password"})
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+
+	jwtPath := filepath.Join(dir, "jwt")
+	require.NoError(t, os.WriteFile(jwtPath, []byte("service-account-jwt\n"), 0o600))
+	provider, err := NewVaultProvider(VaultConfig{
+		Address:             vault.URL,
+		AuthMethod:          VaultAuthKubernetes,
+		AuthMountPath:       "k8s",
+		KubernetesRole:      "exporter",
+		KubernetesTokenPath: jwtPath,
+		Secret:              VaultSecret{Path: "openstack/prod"},
+	}, logger)
+	require.NoError(t, err)
+	creds, err := provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "prod-password"}, creds)
+
+	tokenPath := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenPath, []byte("static-token\n"), 0o600))
+	provider, err = NewVaultProvider(VaultConfig{
+		Address:         vault.URL,
+		AuthMethod:      VaultAuthToken,
+		TokenPath:       tokenPath,
+		RefreshInterval: time.Millisecond,
+		Secret:          VaultSecret{Path: "openstack/prod"},
+	}, logger)
+	require.NoError(t, err)
+	creds, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "prod-password"}, creds)
+
+	// The token file is read again on each refresh.
+	require.NoError(t, os.WriteFile(tokenPath, []byte("revoked-token\n"), 0o600))
+	vault.setSecret("openstack/prod", map[string]any{"password": "rotated-password"})
+	time.Sleep(2 * time.Millisecond)
+	creds, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+	assert.Equal(t, &Credentials{Password: "prod-password"}, creds, "the revoked token can't read the rotated password")
+}
+
+func TestVaultProviderRun(t *testing.T) {
+	vault := newFakeVault(t)
+	vault.setSecret("openstack/prod", map[string]any{"password": "prod-password"})
+
+	provider, err := NewVaultProvider(VaultConfig{
+		Address:         vault.URL,
+		AuthMethod:      VaultAuthToken,
+		Token:           "static-token",
+		RefreshInterval: 10 * time.Millisecond,
+		Secret:          VaultSecret{Path: "openstack/prod"},
+	}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	_, err = provider.Credentials(context.Background(), "prod")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	provider.Run(ctx)
+
+	_, reads := vault.counts()
+	assert.GreaterOrEqual(t, reads, 3, "the credentials read so far are refreshed periodically")
+}
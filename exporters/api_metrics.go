@@ -0,0 +1,159 @@
+package exporters
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gophercloudv2 "github.com/gophercloud/gophercloud/v2"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	apiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "openstack_exporter_api_requests_total",
+		Help: "Total number of requests sent to the OpenStack APIs",
+	}, []string{"cloud", "service_type", "method", "path_template", "code"})
+
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "openstack_exporter_api_request_duration_seconds",
+		Help:    "Duration of the requests sent to the OpenStack APIs",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"cloud", "service_type", "method", "path_template"})
+
+	authSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "openstack_exporter_auth_success",
+		Help: "Whether the last authentication against Keystone of the cloud succeeded",
+	}, []string{"cloud"})
+)
+
+// idPattern matches the UUIDs and 32 characters hex IDs used by OpenStack.
+var idPattern = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[0-9a-fA-F]{32}`)
+
+// numericSegmentPattern matches path segments made only of digits, like Nova hypervisor IDs.
+var numericSegmentPattern = regexp.MustCompile(`^[0-9]+$`)
+
+type apiMetricsCollector struct{}
+
+// APIMetricsCollector returns the collector exposing the metrics of the
+// requests sent to the OpenStack APIs by every cloud transport, and the
+// outcome of the last authentication of every cloud.
+func APIMetricsCollector() prometheus.Collector {
+	return apiMetricsCollector{}
+}
+
+func (apiMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	apiRequests.Describe(ch)
+	apiRequestDuration.Describe(ch)
+	authSuccess.Describe(ch)
+}
+
+func (apiMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	apiRequests.Collect(ch)
+	apiRequestDuration.Collect(ch)
+	authSuccess.Collect(ch)
+}
+
+type serviceEndpoint struct {
+	prefix      string
+	serviceType string
+}
+
+// instrumentedRoundTripper records the requests of a cloud in the API metrics.
+// The service type of a request is found by matching its URL against the
+// endpoints of the service clients built on top of the transport.
+type instrumentedRoundTripper struct {
+	next  http.RoundTripper
+	cloud string
+
+	mu        sync.RWMutex
+	endpoints []serviceEndpoint
+}
+
+func newInstrumentedRoundTripper(next http.RoundTripper, cloud string) *instrumentedRoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &instrumentedRoundTripper{next: next, cloud: cloud}
+}
+
+// RegisterEndpoint maps the requests sent below endpoint to serviceType.
+func (t *instrumentedRoundTripper) RegisterEndpoint(endpoint, serviceType string) {
+	if endpoint == "" {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, e := range t.endpoints {
+		if e.prefix == endpoint {
+			t.endpoints[i].serviceType = serviceType
+			return
+		}
+	}
+	t.endpoints = append(t.endpoints, serviceEndpoint{prefix: endpoint, serviceType: serviceType})
+}
+
+// RegisterServiceClient maps the requests of client to its service type. It
+// does nothing on a nil instrumentedRoundTripper, the one of a ProviderClient
+// the exporter didn't build.
+func (t *instrumentedRoundTripper) RegisterServiceClient(client *gophercloudv2.ServiceClient) {
+	if t == nil {
+		return
+	}
+	t.RegisterEndpoint(client.Endpoint, client.Type)
+}
+
+func (t *instrumentedRoundTripper) serviceType(u *url.URL) string {
+	target := u.Scheme + "://" + u.Host + u.Path
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	serviceType, longest := "unknown", 0
+	for _, e := range t.endpoints {
+		if len(e.prefix) > longest && strings.HasPrefix(target, e.prefix) {
+			serviceType, longest = e.serviceType, len(e.prefix)
+		}
+	}
+
+	return serviceType
+}
+
+func (t *instrumentedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+
+	serviceType := t.serviceType(req.URL)
+	path := pathTemplate(req.URL.Path)
+
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+
+	apiRequests.WithLabelValues(t.cloud, serviceType, req.Method, path, code).Inc()
+	apiRequestDuration.WithLabelValues(t.cloud, serviceType, req.Method, path).Observe(time.Since(start).Seconds())
+
+	return resp, err
+}
+
+// pathTemplate replaces the IDs in path with {id} to keep the cardinality of
+// the API metrics bounded.
+func pathTemplate(path string) string {
+	path = idPattern.ReplaceAllString(path, "{id}")
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if numericSegmentPattern.MatchString(segment) {
+			segments[i] = "{id}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
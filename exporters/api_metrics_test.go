@@ -0,0 +1,61 @@
+package exporters
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPathTemplate(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/v2.1/servers/detail", "/v2.1/servers/detail"},
+		{"/v2.1/os-quota-sets/0c4e939acacf4376bdcd1129f1a054ad/detail", "/v2.1/os-quota-sets/{id}/detail"},
+		{"/v2.0/networks/1e5b2c2b-7b4c-4f41-9b7c-6f1e6b7f3b2a", "/v2.0/networks/{id}"},
+		{"/v2.1/os-hypervisors/42", "/v2.1/os-hypervisors/{id}"},
+		{"/v3/projects", "/v3/projects"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, pathTemplate(test.path), test.path)
+	}
+}
+
+func TestInstrumentedRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/compute/v2.1/servers/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport := newInstrumentedRoundTripper(nil, "api-metrics-cloud")
+	transport.RegisterEndpoint(server.URL+"/", "identity")
+	transport.RegisterEndpoint(server.URL+"/compute/", "compute")
+	client := &http.Client{Transport: transport}
+
+	for _, path := range []string{"/compute/v2.1/servers/42", "/compute/v2.1/servers/43", "/v3/projects"} {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(apiRequests.WithLabelValues("api-metrics-cloud", "compute", "GET", "/compute/v2.1/servers/{id}", "404")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(apiRequests.WithLabelValues("api-metrics-cloud", "identity", "GET", "/v3/projects", "200")))
+
+	var duration dto.Metric
+	observer := apiRequestDuration.WithLabelValues("api-metrics-cloud", "compute", "GET", "/compute/v2.1/servers/{id}")
+	require.NoError(t, observer.(prometheus.Metric).Write(&duration))
+	assert.Equal(t, uint64(2), duration.GetHistogram().GetSampleCount())
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/services"
 	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/snapshots"
 	"github.com/gophercloud/gophercloud/v2/openstack/blockstorage/v3/volumes"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/projects"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -242,12 +243,7 @@ func ListCinderPoolCapacityFree(ctx context.Context, exporter *BaseOpenStackExpo
 }
 
 func ListVolumeLimits(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
-	allProjects, err := GetProjects(ctx, exporter)
-	if err != nil {
-		return err
-	}
-
-	for _, p := range allProjects {
+	return ForEachProject(ctx, exporter, func(ctx context.Context, p projects.Project) error {
 		// Limits are obtained from the cinder API, so now we can just use this exporter's client
 		limits, err := quotasets.GetUsage(ctx, exporter.ClientV2, p.ID).Extract()
 		if err != nil {
@@ -283,9 +279,9 @@ func ListVolumeLimits(ctx context.Context, exporter *BaseOpenStackExporter, ch c
 
 		ch <- prometheus.MustNewConstMetric(exporter.Metrics["limits_backup_used_gb"].Metric,
 			prometheus.GaugeValue, float64(limits.BackupGigabytes.InUse), p.Name, p.ID)
-	}
 
-	return nil
+		return nil
+	})
 }
 
 func getVolumeListOptions(tenantID string) volumes.ListOpts {
@@ -0,0 +1,526 @@
+package exporters
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gophercloudv2 "github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	clientutilsv2 "github.com/gophercloud/utils/v2/client"
+	clientconfigv2 "github.com/gophercloud/utils/v2/openstack/clientconfig"
+	"github.com/openstack-exporter/openstack-exporter/credentials"
+)
+
+// tokenExpiryMargin is how long before the Keystone token expiry a pooled
+// ProviderClient is proactively re-authenticated.
+const tokenExpiryMargin = 1 * time.Minute
+
+// clientRefreshInterval is how often Run checks the tokens and credentials of
+// the pooled ProviderClients.
+const clientRefreshInterval = 30 * time.Second
+
+// DefaultProviderClientPool is the pool used by NewExporter and
+// AutodetectServicesFromCatalog.
+var DefaultProviderClientPool = NewProviderClientPool()
+
+// ProviderClientPool keeps one authenticated ProviderClient per cloud, so the
+// Keystone token and service catalog are shared by every service exporter of
+// that cloud and reused across scrapes.
+type ProviderClientPool struct {
+	mu                  sync.Mutex
+	clients             map[string]*PooledProviderClient
+	authStatuses        map[string]AuthStatus
+	transportOptions    TransportOptions
+	credentialsProvider credentials.Provider
+	// generations counts the invalidations of the client of each cloud and
+	// resets those of every cloud, see generation.
+	generations map[string]uint64
+	resets      uint64
+}
+
+// AuthStatus is the outcome of the last authentication of a cloud.
+type AuthStatus struct {
+	Time    time.Time
+	Success bool
+	// Catalog reports whether the token came with a service catalog.
+	Catalog bool
+	// Err is the error of a failed authentication.
+	Err error
+}
+
+// PooledProviderClient is an authenticated ProviderClient together with the
+// cloud configuration it was built from.
+type PooledProviderClient struct {
+	mu     sync.Mutex
+	Client *gophercloudv2.ProviderClient
+	Cloud  *clientconfigv2.Cloud
+	Region string
+
+	instrumentation *instrumentedRoundTripper
+	// credentials are the ones of the credentials provider the client is
+	// authenticated with, nil when it uses the ones of clouds.yaml.
+	credentials atomic.Pointer[credentials.Credentials]
+}
+
+// NewProviderClientPool returns an empty ProviderClientPool.
+func NewProviderClientPool() *ProviderClientPool {
+	return &ProviderClientPool{
+		clients:      make(map[string]*PooledProviderClient),
+		authStatuses: make(map[string]AuthStatus),
+		generations:  make(map[string]uint64),
+	}
+}
+
+// Get returns the pooled ProviderClient for cloud, authenticating on first use.
+// Tokens are renewed through the client's ReauthFunc when they are about to
+// expire, when the credentials of the credentials provider changed or when an
+// API call returns 401. Run does the same for the clients not requested again,
+// such as the ones of the exporters kept across scrapes.
+func (p *ProviderClientPool) Get(ctx context.Context, cloud string, logger *slog.Logger) (*PooledProviderClient, error) {
+	p.mu.Lock()
+	transportOptions := p.transportOptions
+	credentialsProvider := p.credentialsProvider
+	pc, ok := p.clients[cloud]
+	if !ok {
+		pc = &PooledProviderClient{}
+		p.clients[cloud] = pc
+	}
+	p.mu.Unlock()
+
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	if pc.Client != nil {
+		if err := p.refresh(ctx, cloud, pc, logger); err != nil {
+			return nil, err
+		}
+		return pc, nil
+	}
+
+	var creds *credentials.Credentials
+	if credentialsProvider != nil {
+		var err error
+		creds, err = credentialsProvider.Credentials(ctx, cloud)
+		if err != nil {
+			p.recordAuth(cloud, nil, err)
+			return nil, err
+		}
+	}
+
+	config, err := clientconfigv2.GetCloudFromYAML(cloudClientOpts(cloud, creds))
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := newCloudTransport(config, transportOptions.Debug, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	instrumentation := newInstrumentedRoundTripper(transport, cloud)
+	if config.AuthInfo != nil {
+		instrumentation.RegisterEndpoint(config.AuthInfo.AuthURL, "identity")
+	}
+
+	// Retries and rate limiting wrap the instrumentation so every attempt
+	// is recorded in the API metrics.
+	limited := newRateLimitedRoundTripper(instrumentation, transportOptions.RateLimit, instrumentation.serviceType)
+	retried := newRetryRoundTripper(limited, transportOptions.Retry)
+
+	client, cloudConfig, region, err := newAuthenticatedProviderClient(ctx, cloudClientOpts(cloud, creds), retried)
+	p.recordAuth(cloud, client, err)
+	if err != nil {
+		return nil, err
+	}
+	logger.Debug("Authenticated provider client for cloud", "cloud", cloud)
+	registerCatalogEndpoints(instrumentation, client)
+
+	pc.instrumentation = instrumentation
+	pc.Client = client
+	pc.Cloud = cloudConfig
+	pc.Region = region
+	pc.credentials.Store(creds)
+	p.rotateOnReauth(cloud, pc, logger)
+	return pc, nil
+}
+
+// Run re-authenticates the pooled ProviderClients whose token is about to
+// expire or whose credentials changed, every clientRefreshInterval and as soon
+// as a credentials provider implementing credentials.Notifier tells of a
+// change, until ctx is done. Errors are logged and recorded in the AuthStatus
+// of the cloud.
+func (p *ProviderClientPool) Run(ctx context.Context, logger *slog.Logger) {
+	ticker := time.NewTicker(clientRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		p.mu.Lock()
+		notifier, _ := p.credentialsProvider.(credentials.Notifier)
+		p.mu.Unlock()
+		var changes <-chan struct{}
+		if notifier != nil {
+			changes = notifier.Changes()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.refreshAll(ctx, logger)
+		case <-changes:
+			logger.Debug("Credentials changed, refreshing the authentication of the clouds")
+			p.refreshAll(ctx, logger)
+		}
+	}
+}
+
+// refreshAll refreshes every authenticated pooled ProviderClient.
+func (p *ProviderClientPool) refreshAll(ctx context.Context, logger *slog.Logger) {
+	p.mu.Lock()
+	clients := maps.Clone(p.clients)
+	p.mu.Unlock()
+
+	for cloud, pc := range clients {
+		pc.mu.Lock()
+		if pc.Client != nil {
+			if err := p.refresh(ctx, cloud, pc, logger); err != nil {
+				logger.Warn("Failed to refresh the authentication of the cloud", "cloud", cloud, "error", err)
+			}
+		}
+		pc.mu.Unlock()
+	}
+}
+
+// refresh re-authenticates the client of pc when its token is about to expire
+// or the credentials of the credentials provider changed.
+func (p *ProviderClientPool) refresh(ctx context.Context, cloud string, pc *PooledProviderClient, logger *slog.Logger) error {
+	if tokenExpiresSoon(pc.Client) {
+		logger.Debug("Keystone token is about to expire, re-authenticating", "cloud", cloud)
+		return pc.Client.Reauthenticate(ctx, pc.Client.Token())
+	}
+
+	p.mu.Lock()
+	credentialsProvider := p.credentialsProvider
+	p.mu.Unlock()
+	if credentialsProvider == nil {
+		return nil
+	}
+
+	creds, err := credentialsProvider.Credentials(ctx, cloud)
+	if err != nil {
+		p.recordAuth(cloud, nil, err)
+		return err
+	}
+	if equalCredentials(pc.credentials.Load(), creds) {
+		return nil
+	}
+	return pc.Client.Reauthenticate(ctx, pc.Client.Token())
+}
+
+// rotateOnReauth wraps the ReauthFunc of the client of pc, so every
+// re-authentication reads the credentials provider again and authenticates
+// with the new credentials when they changed. The client is kept, so the
+// service clients built on it, such as the ones of the exporters kept across
+// scrapes, use the new token.
+func (p *ProviderClientPool) rotateOnReauth(cloud string, pc *PooledProviderClient, logger *slog.Logger) {
+	client := pc.Client
+	reauth := client.ReauthFunc
+	if reauth == nil {
+		return
+	}
+
+	// Calls of ReauthFunc are serialized by client.Reauthenticate.
+	client.ReauthFunc = func(ctx context.Context) error {
+		p.mu.Lock()
+		credentialsProvider := p.credentialsProvider
+		p.mu.Unlock()
+
+		creds := pc.credentials.Load()
+		if credentialsProvider != nil {
+			var err error
+			creds, err = credentialsProvider.Credentials(ctx, cloud)
+			if err != nil {
+				p.recordAuth(cloud, nil, err)
+				return err
+			}
+		}
+
+		if equalCredentials(pc.credentials.Load(), creds) {
+			err := reauth(ctx)
+			p.recordAuth(cloud, client, err)
+			return err
+		}
+
+		logger.Info("Credentials of the cloud changed, re-authenticating", "cloud", cloud)
+		fresh, err := authenticatedClient(ctx, cloudClientOpts(cloud, creds), client.HTTPClient.Transport)
+		if err != nil {
+			p.recordAuth(cloud, nil, err)
+			return err
+		}
+		client.CopyTokenFrom(fresh)
+		pc.credentials.Store(creds)
+		reauth = func(ctx context.Context) error {
+			if err := fresh.ReauthFunc(ctx); err != nil {
+				return err
+			}
+			client.CopyTokenFrom(fresh)
+			return nil
+		}
+		p.recordAuth(cloud, client, nil)
+		return nil
+	}
+}
+
+// recordAuth records the outcome of an authentication of cloud, client being
+// the authenticated ProviderClient when err is nil.
+func (p *ProviderClientPool) recordAuth(cloud string, client *gophercloudv2.ProviderClient, err error) {
+	status := AuthStatus{Time: time.Now(), Success: err == nil, Err: err}
+	if err == nil {
+		status.Catalog = hasServiceCatalog(client)
+	}
+
+	p.mu.Lock()
+	p.authStatuses[cloud] = status
+	p.mu.Unlock()
+
+	if status.Success {
+		authSuccess.WithLabelValues(cloud).Set(1)
+	} else {
+		authSuccess.WithLabelValues(cloud).Set(0)
+	}
+}
+
+// AuthStatuses returns the AuthStatus of every cloud authenticated at least
+// once, even when its ProviderClient was dropped since.
+func (p *ProviderClientPool) AuthStatuses() map[string]AuthStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return maps.Clone(p.authStatuses)
+}
+
+// SetTransportOptions sets the retry policy and rate limits of the clients
+// authenticated from now on.
+func (p *ProviderClientPool) SetTransportOptions(options TransportOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.transportOptions = options
+}
+
+// SetCredentialsProvider sets the credentials.Provider whose credentials
+// replace the ones of clouds.yaml. It is consulted on every Get, by Run and on
+// every re-authentication, and the clients of the clouds whose credentials
+// changed are authenticated again.
+func (p *ProviderClientPool) SetCredentialsProvider(provider credentials.Provider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.credentialsProvider = provider
+}
+
+// Invalidate drops the pooled ProviderClient of cloud, forcing a full
+// authentication on the next Get.
+func (p *ProviderClientPool) Invalidate(cloud string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.clients, cloud)
+	p.generations[cloud]++
+}
+
+// Reset drops every pooled ProviderClient.
+func (p *ProviderClientPool) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clients = make(map[string]*PooledProviderClient)
+	p.resets++
+}
+
+// generation returns a number changing whenever the ProviderClient of cloud
+// is dropped, so the exporters built on the dropped one, which Run no longer
+// renews, can tell they have to be rebuilt.
+func (p *ProviderClientPool) generation(cloud string) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.resets + p.generations[cloud]
+}
+
+// EndpointOpts returns the EndpointOpts to use for the given endpoint type.
+func (pc *PooledProviderClient) EndpointOpts(endpointType string) gophercloudv2.EndpointOpts {
+	return gophercloudv2.EndpointOpts{
+		Region:       pc.Region,
+		Availability: GetEndpointTypeV2(endpointType),
+	}
+}
+
+// registerCatalogEndpoints maps every endpoint of the service catalog to its
+// service type in the API metrics.
+func registerCatalogEndpoints(instrumentation *instrumentedRoundTripper, client *gophercloudv2.ProviderClient) {
+	result, ok := client.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return
+	}
+
+	catalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return
+	}
+
+	for _, entry := range catalog.Entries {
+		for _, endpoint := range entry.Endpoints {
+			instrumentation.RegisterEndpoint(gophercloudv2.NormalizeURL(endpoint.URL), entry.Type)
+		}
+	}
+}
+
+// cloudClientOpts returns the ClientOpts of cloud, with creds replacing the
+// credentials of clouds.yaml when not nil.
+func cloudClientOpts(cloud string, creds *credentials.Credentials) *clientconfigv2.ClientOpts {
+	opts := &clientconfigv2.ClientOpts{Cloud: cloud}
+	if creds != nil {
+		opts.YAMLOpts = credentialsYAMLOpts{cloud: cloud, credentials: *creds}
+	}
+	return opts
+}
+
+func equalCredentials(a, b *credentials.Credentials) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// credentialsYAMLOpts loads clouds.yaml and secure.yaml like the default
+// clientconfig.YAMLOpts, replacing the credentials of cloud.
+type credentialsYAMLOpts struct {
+	clientconfigv2.YAMLOpts
+	cloud       string
+	credentials credentials.Credentials
+}
+
+func (o credentialsYAMLOpts) LoadCloudsYAML() (map[string]clientconfigv2.Cloud, error) {
+	clouds, err := o.YAMLOpts.LoadCloudsYAML()
+	o.apply(clouds)
+	return clouds, err
+}
+
+// LoadSecureCloudsYAML replaces the credentials in secure.yaml too, since it
+// takes precedence over clouds.yaml.
+func (o credentialsYAMLOpts) LoadSecureCloudsYAML() (map[string]clientconfigv2.Cloud, error) {
+	clouds, err := o.YAMLOpts.LoadSecureCloudsYAML()
+	o.apply(clouds)
+	return clouds, err
+}
+
+func (o credentialsYAMLOpts) apply(clouds map[string]clientconfigv2.Cloud) {
+	cloud, ok := clouds[o.cloud]
+	if !ok {
+		return
+	}
+
+	authInfo := clientconfigv2.AuthInfo{}
+	if cloud.AuthInfo != nil {
+		authInfo = *cloud.AuthInfo
+	}
+	o.credentials.Apply(&authInfo)
+	cloud.AuthInfo = &authInfo
+	clouds[o.cloud] = cloud
+}
+
+// hasServiceCatalog reports whether the token of client came with a non
+// empty service catalog. Tokens other than Keystone v3 ones are assumed to.
+func hasServiceCatalog(client *gophercloudv2.ProviderClient) bool {
+	result, ok := client.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return true
+	}
+
+	catalog, err := result.ExtractServiceCatalog()
+	return err == nil && len(catalog.Entries) > 0
+}
+
+// tokenExpiresSoon reports whether the Keystone v3 token held by client
+// expires within tokenExpiryMargin.
+func tokenExpiresSoon(client *gophercloudv2.ProviderClient) bool {
+	result, ok := client.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return false
+	}
+
+	token, err := result.ExtractToken()
+	if err != nil || token.ExpiresAt.IsZero() {
+		return false
+	}
+
+	return time.Now().Add(tokenExpiryMargin).After(token.ExpiresAt)
+}
+
+// newCloudTransport builds the http.RoundTripper used to talk to a cloud,
+// honouring its TLS settings, and logging every request and response when
+// debug is set. A nil transport means the default one.
+func newCloudTransport(config *clientconfigv2.Cloud, debug bool, logger *slog.Logger) (http.RoundTripper, error) {
+	var transport http.RoundTripper
+	var tlsConfig tls.Config
+
+	var configureTransport = false
+	if config.Verify != nil && !*config.Verify {
+		logger.Info("SSL verification disabled on transport")
+		tlsConfig.InsecureSkipVerify = true
+		configureTransport = true
+	} else if config.CACertFile != "" {
+		certPool, err := additionalTLSTrust(config.CACertFile, logger)
+		if err != nil {
+			logger.Error("Failed to include additional certificates to ca-trust", "err", err)
+		}
+		tlsConfig.RootCAs = certPool
+		configureTransport = true
+	}
+
+	// took from here:
+	// https://github.com/gophercloud/utils/blob/4c0f6d93d3a9b027a21d9206b6bdd09123de7a09/internal/util.go#L65
+	if config.ClientCertFile != "" && config.ClientKeyFile != "" {
+		clientCert, _, err := pathOrContents(config.ClientCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading Client Cert: %s", err)
+		}
+		clientKey, _, err := pathOrContents(config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading Client Key: %s", err)
+		}
+		cert, err := tls.X509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		configureTransport = true
+	}
+	if configureTransport {
+		transport = &http.Transport{
+			TLSClientConfig: &tlsConfig,
+			Proxy:           http.ProxyFromEnvironment,
+		}
+	}
+
+	if debug {
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+
+		transport = &clientutilsv2.RoundTripper{
+			Rt:     transport,
+			Logger: &clientutilsv2.DefaultLogger{},
+		}
+	}
+
+	return transport, nil
+}
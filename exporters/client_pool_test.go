@@ -0,0 +1,348 @@
+package exporters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/openstack-exporter/openstack-exporter/credentials"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderClientPoolAuthenticatesOnce(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	data, err := os.ReadFile(path.Join(baseFixturePath, "tokens.json"))
+	require.NoError(t, err)
+	responder := httpmock.NewBytesResponder(201, data).HeaderSet(map[string][]string{
+		"Content-Type":    {"application/json"},
+		"X-Subject-Token": {"1234"},
+	})
+	httpmock.RegisterResponder("POST", "http://test.cloud:35357/v3/auth/tokens", responder)
+
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path.Join(baseFixturePath, "test_config.yaml"))
+	logger := slog.New(slog.DiscardHandler)
+
+	pool := NewProviderClientPool()
+	first, err := pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	second, err := pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	assert.Same(t, first.Client, second.Client)
+
+	for _, service := range []string{"compute", "network", "image"} {
+		assert.True(t, isServiceAvailable(second.Client, second.EndpointOpts("public"), service), service)
+	}
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+
+	pool.Invalidate(cloudName)
+	third, err := pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	assert.NotSame(t, first.Client, third.Client)
+	assert.Equal(t, 2, httpmock.GetTotalCallCount())
+}
+
+func TestProviderClientPoolRecordsAuthStatus(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	httpmock.RegisterResponder("POST", "http://test.cloud:35357/v3/auth/tokens", httpmock.NewStringResponder(401, `{"error": {"code": 401}}`))
+
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path.Join(baseFixturePath, "test_config.yaml"))
+	logger := slog.New(slog.DiscardHandler)
+
+	pool := NewProviderClientPool()
+	assert.Empty(t, pool.AuthStatuses())
+
+	_, err := pool.Get(context.Background(), cloudName, logger)
+	require.Error(t, err)
+	status := pool.AuthStatuses()[cloudName]
+	assert.False(t, status.Success)
+	assert.False(t, status.Catalog)
+	assert.Error(t, status.Err)
+	assert.Equal(t, 0.0, testutil.ToFloat64(authSuccess.WithLabelValues(cloudName)))
+
+	data, err := os.ReadFile(path.Join(baseFixturePath, "tokens.json"))
+	require.NoError(t, err)
+	responder := httpmock.NewBytesResponder(201, data).HeaderSet(map[string][]string{
+		"Content-Type":    {"application/json"},
+		"X-Subject-Token": {"1234"},
+	})
+	httpmock.RegisterResponder("POST", "http://test.cloud:35357/v3/auth/tokens", responder)
+
+	_, err = pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	status = pool.AuthStatuses()[cloudName]
+	assert.True(t, status.Success)
+	assert.True(t, status.Catalog)
+	assert.NoError(t, status.Err)
+	assert.Equal(t, 1.0, testutil.ToFloat64(authSuccess.WithLabelValues(cloudName)))
+
+	// The status outlives the ProviderClient.
+	pool.Invalidate(cloudName)
+	assert.Contains(t, pool.AuthStatuses(), cloudName)
+}
+
+type staticCredentialsProvider struct {
+	credentials *credentials.Credentials
+	err         error
+}
+
+func (p *staticCredentialsProvider) Credentials(ctx context.Context, cloud string) (*credentials.Credentials, error) {
+	return p.credentials, p.err
+}
+
+func TestProviderClientPoolCredentialsProvider(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	data, err := os.ReadFile(path.Join(baseFixturePath, "tokens.json"))
+	require.NoError(t, err)
+	var passwords []string
+	httpmock.RegisterResponder("POST", "http://test.cloud:35357/v3/auth/tokens", func(req *http.Request) (*http.Response, error) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		var auth struct {
+			Auth struct {
+				Identity struct {
+					Password struct {
+						User struct {
+							Password string `json:"password"`
+						} `json:"user"`
+					} `json:"password"`
+					ApplicationCredential struct {
+						ID     string `json:"id"`
+						Secret string `json:"secret"`
+					} `json:"application_credential"`
+				} `json:"identity"`
+			} `json:"auth"`
+		}
+		if err := json.Unmarshal(body, &auth); err != nil {
+			return nil, err
+		}
+		identity := auth.Auth.Identity
+		if identity.ApplicationCredential.ID != "" {
+			passwords = append(passwords, identity.ApplicationCredential.ID+":"+identity.ApplicationCredential.Secret)
+		} else {
+			passwords = append(passwords, identity.Password.User.Password)
+		}
+
+		resp := httpmock.NewBytesResponse(201, data)
+		resp.Header.Set("Content-Type", "application/json")
+		resp.Header.Set("X-Subject-Token", "1234")
+		return resp, nil
+	})
+
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path.Join(baseFixturePath, "test_config.yaml"))
+	logger := slog.New(slog.DiscardHandler)
+
+	provider := &staticCredentialsProvider{credentials: &credentials.Credentials{Password: "from-provider"}}
+	pool := NewProviderClientPool()
+	pool.SetCredentialsProvider(provider)
+
+	first, err := pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	second, err := pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.Equal(t, []string{"from-provider"}, passwords, "the password of clouds.yaml is replaced")
+
+	provider.credentials = &credentials.Credentials{Password: "rotated"}
+	rotated, err := pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	assert.Same(t, first.Client, rotated.Client, "the client of the exporters kept across scrapes is authenticated again")
+	assert.Equal(t, []string{"from-provider", "rotated"}, passwords, "rotated credentials are authenticated again")
+
+	// A re-authentication after a 401 reads the provider again too.
+	provider.credentials = &credentials.Credentials{Password: "rotated-again"}
+	require.NoError(t, first.Client.Reauthenticate(context.Background(), first.Client.Token()))
+	assert.Equal(t, []string{"from-provider", "rotated", "rotated-again"}, passwords)
+
+	// So does Run, without waiting for a Get or a 401.
+	provider.credentials = &credentials.Credentials{Password: "refreshed"}
+	pool.refreshAll(context.Background(), logger)
+	assert.Equal(t, []string{"from-provider", "rotated", "rotated-again", "refreshed"}, passwords)
+
+	provider.credentials = &credentials.Credentials{ApplicationCredentialID: "app-id", ApplicationCredentialSecret: "app-secret"}
+	_, err = pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	assert.Equal(t, "app-id:app-secret", passwords[len(passwords)-1], "application credentials are used instead of the password")
+
+	provider.err = errors.New("vault sealed")
+	_, err = pool.Get(context.Background(), cloudName, logger)
+	assert.ErrorContains(t, err, "vault sealed")
+	assert.False(t, pool.AuthStatuses()[cloudName].Success)
+}
+
+func TestProviderClientPoolRefreshesRotatedFiles(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	data, err := os.ReadFile(path.Join(baseFixturePath, "tokens.json"))
+	require.NoError(t, err)
+	var passwords []string
+	httpmock.RegisterResponder("POST", "http://test.cloud:35357/v3/auth/tokens", func(req *http.Request) (*http.Response, error) {
+		var auth struct {
+			Auth struct {
+				Identity struct {
+					Password struct {
+						User struct {
+							Password string `json:"password"`
+						} `json:"user"`
+					} `json:"password"`
+				} `json:"identity"`
+			} `json:"auth"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&auth); err != nil {
+			return nil, err
+		}
+		passwords = append(passwords, auth.Auth.Identity.Password.User.Password)
+
+		resp := httpmock.NewBytesResponse(201, data)
+		resp.Header.Set("Content-Type", "application/json")
+		resp.Header.Set("X-Subject-Token", "1234")
+		return resp, nil
+	})
+
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path.Join(baseFixturePath, "test_config.yaml"))
+	logger := slog.New(slog.DiscardHandler)
+
+	passwordFile := path.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("first\n"), 0o600))
+	pool := NewProviderClientPool()
+	pool.SetCredentialsProvider(credentials.NewFileProvider(credentials.FileConfig{PasswordFile: passwordFile}, logger))
+
+	pc, err := pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	pool.refreshAll(context.Background(), logger)
+	assert.Equal(t, []string{"first"}, passwords, "unchanged credentials are not authenticated again")
+
+	// The secret is rotated while the exporters keep using the client.
+	require.NoError(t, os.WriteFile(passwordFile, []byte("second\n"), 0o600))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(passwordFile, later, later))
+	pool.refreshAll(context.Background(), logger)
+	assert.Equal(t, []string{"first", "second"}, passwords)
+	assert.True(t, pool.AuthStatuses()[cloudName].Success)
+
+	same, err := pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	assert.Same(t, pc.Client, same.Client)
+	assert.Len(t, passwords, 2)
+}
+
+// notifyingCredentialsProvider tells of the changes of its credentials.
+type notifyingCredentialsProvider struct {
+	staticCredentialsProvider
+	changes chan struct{}
+}
+
+func (p *notifyingCredentialsProvider) Changes() <-chan struct{} {
+	return p.changes
+}
+
+func TestProviderClientPoolRunRefreshesOnChanges(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	data, err := os.ReadFile(path.Join(baseFixturePath, "tokens.json"))
+	require.NoError(t, err)
+	var mu sync.Mutex
+	var passwords []string
+	httpmock.RegisterResponder("POST", "http://test.cloud:35357/v3/auth/tokens", func(req *http.Request) (*http.Response, error) {
+		var auth struct {
+			Auth struct {
+				Identity struct {
+					Password struct {
+						User struct {
+							Password string `json:"password"`
+						} `json:"user"`
+					} `json:"password"`
+				} `json:"identity"`
+			} `json:"auth"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&auth); err != nil {
+			return nil, err
+		}
+		mu.Lock()
+		passwords = append(passwords, auth.Auth.Identity.Password.User.Password)
+		mu.Unlock()
+
+		resp := httpmock.NewBytesResponse(201, data)
+		resp.Header.Set("Content-Type", "application/json")
+		resp.Header.Set("X-Subject-Token", "1234")
+		return resp, nil
+	})
+
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path.Join(baseFixturePath, "test_config.yaml"))
+	logger := slog.New(slog.DiscardHandler)
+
+	provider := &notifyingCredentialsProvider{
+		staticCredentialsProvider: staticCredentialsProvider{credentials: &credentials.Credentials{Password: "first"}},
+		changes:                   make(chan struct{}),
+	}
+	pool := NewProviderClientPool()
+	pool.SetCredentialsProvider(provider)
+	_, err = pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Go(func() { pool.Run(ctx, logger) })
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// Long before the next refresh interval.
+	provider.credentials = &credentials.Credentials{Password: "rotated"}
+	provider.changes <- struct{}{}
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Equal(passwords, []string{"first", "rotated"})
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestProviderClientPoolRefreshesExpiringTokens(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	data, err := os.ReadFile(path.Join(baseFixturePath, "tokens.json"))
+	require.NoError(t, err)
+	expiresAt := time.Now().Add(tokenExpiryMargin / 2).UTC().Format("2006-01-02T15:04:05.000000Z")
+	data = []byte(strings.Replace(string(data), "2100-11-07T02:58:43.578887Z", expiresAt, 1))
+	responder := httpmock.NewBytesResponder(201, data).HeaderSet(map[string][]string{
+		"Content-Type":    {"application/json"},
+		"X-Subject-Token": {"1234"},
+	})
+	httpmock.RegisterResponder("POST", "http://test.cloud:35357/v3/auth/tokens", responder)
+
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path.Join(baseFixturePath, "test_config.yaml"))
+	logger := slog.New(slog.DiscardHandler)
+
+	pool := NewProviderClientPool()
+	_, err = pool.Get(context.Background(), cloudName, logger)
+	require.NoError(t, err)
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+
+	// Without any Get, as for the exporters kept across scrapes.
+	pool.refreshAll(context.Background(), logger)
+	assert.Equal(t, 2, httpmock.GetTotalCallCount(), "the token about to expire is renewed")
+}
@@ -0,0 +1,195 @@
+package exporters
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// ScrapeResult is the outcome of the collection of a cloud for a scrape.
+type ScrapeResult struct {
+	MetricFamilies []*dto.MetricFamily
+	// GatherErr is the error of the gathering of MetricFamilies.
+	GatherErr error
+	// EnableErr joins the errors of the exporters that couldn't be built.
+	EnableErr error
+	// Exporters is the number of exporters collected.
+	Exporters int
+}
+
+// Gatherer returns a prometheus.Gatherer serving the result.
+func (r ScrapeResult) Gatherer() prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		return r.MetricFamilies, r.GatherErr
+	})
+}
+
+type coalescedResult struct {
+	result ScrapeResult
+	done   time.Time
+}
+
+// ScrapeCoalescer shares the collection of a cloud and set of services
+// between the scrapes requesting it concurrently, such as the ones of
+// Prometheus replicas, and with the ones arriving during a grace period after
+// it completed. A nil ScrapeCoalescer doesn't coalesce anything.
+type ScrapeCoalescer struct {
+	grace time.Duration
+
+	mu      sync.Mutex
+	results map[string]coalescedResult
+	flights map[string]*flight
+}
+
+// NewScrapeCoalescer returns a ScrapeCoalescer keeping each result for grace
+// after its collection, zero sharing it with the concurrent scrapes only.
+func NewScrapeCoalescer(grace time.Duration) *ScrapeCoalescer {
+	return &ScrapeCoalescer{
+		grace:   grace,
+		results: make(map[string]coalescedResult),
+		flights: make(map[string]*flight),
+	}
+}
+
+// Do returns the result of collect for cloud and services, running it unless
+// a collection of the same cloud and services is in progress or completed
+// within the grace period. collect runs with a context detached from the
+// cancellation of the scrape starting it, bounded by the latest deadline of
+// the scrapes waiting for it, so the other scrapes still get the result when
+// it goes away and a scrape with a short timeout doesn't cut the collection
+// short for the others. A scrape whose ctx is done stops waiting and gets the
+// error of ctx.
+func (c *ScrapeCoalescer) Do(ctx context.Context, cloud string, services []string, collect func(ctx context.Context) ScrapeResult) (ScrapeResult, error) {
+	if c == nil {
+		return collect(ctx), nil
+	}
+
+	key := coalesceKey(cloud, services)
+	c.mu.Lock()
+	if result, ok := c.recent(key); ok {
+		c.mu.Unlock()
+		return result, nil
+	}
+	// The flight is joined before it can land, so every collection is
+	// bounded by the scrapes waiting for it.
+	f, ok := c.flights[key]
+	if !ok {
+		f = newFlight(ctx)
+		c.flights[key] = f
+		go c.run(key, f, collect)
+	}
+	f.join(ctx)
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result, nil
+	case <-ctx.Done():
+		return ScrapeResult{}, ctx.Err()
+	}
+}
+
+// run runs the collection of the flight f of key and lands it.
+func (c *ScrapeCoalescer) run(key string, f *flight, collect func(ctx context.Context) ScrapeResult) {
+	defer f.land()
+
+	result := collect(f.ctx)
+	c.mu.Lock()
+	delete(c.flights, key)
+	c.store(key, result)
+	c.mu.Unlock()
+
+	f.result = result
+	close(f.done)
+}
+
+// flight holds the context of a coalesced collection, cancelled at the latest
+// deadline of the scrapes that joined it, or only once it completes when one
+// of them has no deadline.
+type flight struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	// done is closed once result is set.
+	done   chan struct{}
+	result ScrapeResult
+
+	mu        sync.Mutex
+	deadline  time.Time
+	unbounded bool
+	timer     *time.Timer
+}
+
+func newFlight(parent context.Context) *flight {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(parent))
+	return &flight{ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// join extends the deadline of the collection to the one of ctx.
+func (f *flight) join(ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	switch {
+	case f.unbounded:
+	case !ok:
+		f.unbounded = true
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+	case deadline.After(f.deadline):
+		f.deadline = deadline
+		if f.timer == nil {
+			f.timer = time.AfterFunc(time.Until(deadline), func() { f.cancel(context.DeadlineExceeded) })
+		} else {
+			f.timer.Reset(time.Until(deadline))
+		}
+	}
+}
+
+// land releases the resources of the flight once the collection completed.
+func (f *flight) land() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.cancel(context.Canceled)
+}
+
+// recent returns the result of key collected within the grace period. c.mu
+// must be held.
+func (c *ScrapeCoalescer) recent(key string) (ScrapeResult, bool) {
+	coalesced, ok := c.results[key]
+	if !ok || time.Since(coalesced.done) > c.grace {
+		return ScrapeResult{}, false
+	}
+	return coalesced.result, true
+}
+
+// store keeps result for the grace period, dropping the expired results. c.mu
+// must be held.
+func (c *ScrapeCoalescer) store(key string, result ScrapeResult) {
+	if c.grace <= 0 {
+		return
+	}
+
+	for k, coalesced := range c.results {
+		if time.Since(coalesced.done) > c.grace {
+			delete(c.results, k)
+		}
+	}
+	c.results[key] = coalescedResult{result: result, done: time.Now()}
+}
+
+// coalesceKey identifies a cloud and set of services, whatever their order.
+func coalesceKey(cloud string, services []string) string {
+	sorted := slices.Sorted(slices.Values(services))
+	return cloud + "\x00" + strings.Join(slices.Compact(sorted), ",")
+}
@@ -0,0 +1,199 @@
+package exporters
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeCoalescerSharesConcurrentCollections(t *testing.T) {
+	coalescer := NewScrapeCoalescer(0)
+
+	var collections atomic.Int32
+	release := make(chan struct{})
+	collect := func(ctx context.Context) ScrapeResult {
+		collections.Add(1)
+		<-release
+		return ScrapeResult{Exporters: 2}
+	}
+
+	var wg sync.WaitGroup
+	results := make([]ScrapeResult, 3)
+	for i, services := range [][]string{{"compute", "network"}, {"network", "compute"}, {"compute", "network", "compute"}} {
+		wg.Go(func() {
+			result, err := coalescer.Do(context.Background(), "cloud", services, collect)
+			assert.NoError(t, err)
+			results[i] = result
+		})
+	}
+	// Let every scrape join the collection before it completes.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), collections.Load())
+	for _, result := range results {
+		assert.Equal(t, 2, result.Exporters)
+	}
+
+	// Without grace period, the next scrape collects again, as do other services.
+	_, err := coalescer.Do(context.Background(), "cloud", []string{"compute", "network"}, collect)
+	require.NoError(t, err)
+	_, err = coalescer.Do(context.Background(), "cloud", []string{"compute"}, collect)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), collections.Load())
+}
+
+func TestScrapeCoalescerGracePeriod(t *testing.T) {
+	coalescer := NewScrapeCoalescer(50 * time.Millisecond)
+
+	collections := 0
+	collect := func(ctx context.Context) ScrapeResult {
+		collections++
+		return ScrapeResult{Exporters: collections}
+	}
+
+	first, err := coalescer.Do(context.Background(), "cloud", []string{"compute"}, collect)
+	require.NoError(t, err)
+	second, err := coalescer.Do(context.Background(), "cloud", []string{"compute"}, collect)
+	require.NoError(t, err)
+	assert.Equal(t, first, second, "the result is kept for the grace period")
+
+	time.Sleep(60 * time.Millisecond)
+	third, err := coalescer.Do(context.Background(), "cloud", []string{"compute"}, collect)
+	require.NoError(t, err)
+	assert.Equal(t, 2, third.Exporters)
+}
+
+func TestScrapeCoalescerDetachesCollection(t *testing.T) {
+	coalescer := NewScrapeCoalescer(0)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	collect := func(ctx context.Context) ScrapeResult {
+		close(started)
+		<-release
+		return ScrapeResult{Exporters: 1, GatherErr: ctx.Err()}
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := coalescer.Do(leaderCtx, "cloud", []string{"compute"}, collect)
+		leader <- err
+	}()
+	<-started
+
+	follower := make(chan ScrapeResult, 1)
+	go func() {
+		result, err := coalescer.Do(context.Background(), "cloud", []string{"compute"}, collect)
+		assert.NoError(t, err)
+		follower <- result
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The scrape starting the collection goes away, the other one still
+	// gets the result.
+	cancelLeader()
+	assert.ErrorIs(t, <-leader, context.Canceled)
+	close(release)
+	result := <-follower
+	assert.Equal(t, 1, result.Exporters)
+	assert.NoError(t, result.GatherErr, "the collection isn't cancelled with the scrape starting it")
+}
+
+func TestScrapeCoalescerUsesLatestDeadline(t *testing.T) {
+	coalescer := NewScrapeCoalescer(0)
+
+	started := make(chan struct{})
+	collect := func(ctx context.Context) ScrapeResult {
+		close(started)
+		select {
+		case <-time.After(100 * time.Millisecond):
+			return ScrapeResult{Exporters: 1}
+		case <-ctx.Done():
+			return ScrapeResult{GatherErr: ctx.Err()}
+		}
+	}
+
+	shortCtx, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+	short := make(chan error, 1)
+	go func() {
+		_, err := coalescer.Do(shortCtx, "cloud", []string{"compute"}, collect)
+		short <- err
+	}()
+	<-started
+
+	longCtx, cancelLong := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelLong()
+	result, err := coalescer.Do(longCtx, "cloud", []string{"compute"}, collect)
+	require.NoError(t, err)
+	assert.ErrorIs(t, <-short, context.DeadlineExceeded)
+	assert.NoError(t, result.GatherErr, "the collection runs until the latest deadline")
+	assert.Equal(t, 1, result.Exporters)
+
+	// Alone, a scrape with a short timeout still bounds the collection.
+	shortCtx, cancelShort = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+	cause := make(chan error, 1)
+	_, _ = coalescer.Do(shortCtx, "cloud", []string{"compute"}, func(ctx context.Context) ScrapeResult {
+		<-ctx.Done()
+		cause <- context.Cause(ctx)
+		return ScrapeResult{}
+	})
+	assert.ErrorIs(t, <-cause, context.DeadlineExceeded)
+}
+
+func TestScrapeCoalescerBoundsEveryCollection(t *testing.T) {
+	coalescer := NewScrapeCoalescer(0)
+
+	// Collections land while the next scrapes join, none must be left
+	// without the deadline of a scrape.
+	var unbounded atomic.Int32
+	collect := func(ctx context.Context) ScrapeResult {
+		select {
+		case <-ctx.Done():
+			if !errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
+				unbounded.Add(1)
+			}
+		case <-time.After(time.Second):
+			unbounded.Add(1)
+		}
+		return ScrapeResult{}
+	}
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			for range 50 {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Millisecond)
+				_, _ = coalescer.Do(ctx, "cloud", []string{"compute"}, collect)
+				cancel()
+			}
+		})
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		coalescer.mu.Lock()
+		defer coalescer.mu.Unlock()
+		return len(coalescer.flights) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Zero(t, unbounded.Load())
+}
+
+func TestNilScrapeCoalescer(t *testing.T) {
+	var coalescer *ScrapeCoalescer
+	result, err := coalescer.Do(context.Background(), "cloud", nil, func(ctx context.Context) ScrapeResult {
+		return ScrapeResult{Exporters: 1}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Exporters)
+}
@@ -0,0 +1,104 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CollectTimeouts holds the deadlines applied to a collection. Services are
+// keyed by exporter service name (i.e: compute) and metrics use the same
+// service-metric format as --disable-metric (i.e: nova-flavors). A zero value
+// means no deadline besides the one of the scrape itself.
+type CollectTimeouts struct {
+	Default  time.Duration
+	Services map[string]time.Duration
+	Metrics  map[string]time.Duration
+}
+
+func (t CollectTimeouts) forService(service string) time.Duration {
+	if timeout, ok := t.Services[service]; ok {
+		return timeout
+	}
+	return t.Default
+}
+
+func (t CollectTimeouts) forMetric(exporterName, metric string) time.Duration {
+	return t.Metrics[fmt.Sprintf("%s-%s", exporterName, metric)]
+}
+
+// ExporterName returns the name of the exporter of service (i.e: nova for
+// compute), or an empty string for an unsupported service.
+func ExporterName(service string) string {
+	registered, _ := registeredService(service)
+	return registered.ExporterName
+}
+
+// MetricService returns the service of a metric in the service-metric format
+// (i.e: compute for nova-agent_state). The metric must be one of the Metrics
+// of the service, when they are registered.
+func MetricService(metric string) (string, bool) {
+	name, metricName, ok := strings.Cut(metric, "-")
+	if !ok || metricName == "" {
+		return "", false
+	}
+	for _, service := range registeredServices() {
+		if service.ExporterName == name {
+			if len(service.Metrics) > 0 && !slices.Contains(service.Metrics, metricName) {
+				return "", false
+			}
+			return service.Name, true
+		}
+	}
+	return "", false
+}
+
+// ContextCollector is implemented by exporters able to stop collecting when
+// the scrape they serve is cancelled.
+type ContextCollector interface {
+	CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric)
+}
+
+type contextCollector struct {
+	ctx context.Context
+	prometheus.Collector
+}
+
+// WithContext binds collector to ctx, so the collection stops when ctx is
+// done, typically when Prometheus gives up on the scrape.
+func WithContext(ctx context.Context, collector prometheus.Collector) prometheus.Collector {
+	return &contextCollector{ctx: ctx, Collector: collector}
+}
+
+func (c *contextCollector) Collect(ch chan<- prometheus.Metric) {
+	if cc, ok := c.Collector.(ContextCollector); ok {
+		cc.CollectWithContext(c.ctx, ch)
+		return
+	}
+	c.Collector.Collect(ch)
+}
+
+// ScrapeTimeout returns the deadline announced by Prometheus in the
+// X-Prometheus-Scrape-Timeout-Seconds header, reduced by offset. It returns
+// zero when the header is missing or invalid.
+func ScrapeTimeout(header string, offset time.Duration) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	var seconds float64
+	if _, err := fmt.Sscanf(header, "%g", &seconds); err != nil || seconds <= 0 {
+		return 0
+	}
+
+	timeout := time.Duration(seconds*float64(time.Second)) - offset
+	if timeout <= 0 {
+		return 0
+	}
+
+	return timeout
+}
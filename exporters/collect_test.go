@@ -0,0 +1,216 @@
+package exporters
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrapeTimeout(t *testing.T) {
+	assert.Equal(t, time.Duration(0), ScrapeTimeout("", 0))
+	assert.Equal(t, time.Duration(0), ScrapeTimeout("bad", 0))
+	assert.Equal(t, time.Duration(0), ScrapeTimeout("0.2", 500*time.Millisecond))
+	assert.Equal(t, 9500*time.Millisecond, ScrapeTimeout("10", 500*time.Millisecond))
+	assert.Equal(t, 1500*time.Millisecond, ScrapeTimeout("1.5", 0))
+}
+
+func TestCollectTimeoutsResolution(t *testing.T) {
+	timeouts := CollectTimeouts{
+		Default:  time.Minute,
+		Services: map[string]time.Duration{"compute": 20 * time.Second},
+		Metrics:  map[string]time.Duration{"nova-flavors": 5 * time.Second},
+	}
+
+	assert.Equal(t, 20*time.Second, timeouts.forService("compute"))
+	assert.Equal(t, time.Minute, timeouts.forService("network"))
+	assert.Equal(t, 5*time.Second, timeouts.forMetric("nova", "flavors"))
+	assert.Equal(t, time.Duration(0), timeouts.forMetric("nova", "servers"))
+}
+
+func TestCollectStopsTimedOutMetric(t *testing.T) {
+	exporter := &BaseOpenStackExporter{
+		Name: "test",
+		ExporterConfig: ExporterConfig{
+			Prefix:      "openstack",
+			ServiceName: "test",
+			CollectTimeouts: CollectTimeouts{
+				Metrics: map[string]time.Duration{"test-slow": 10 * time.Millisecond},
+			},
+		},
+		logger: slog.New(slog.DiscardHandler),
+	}
+	exporter.AddMetric("fast", func(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
+		ch <- prometheus.MustNewConstMetric(exporter.Metrics["fast"].Metric, prometheus.GaugeValue, 1)
+		return nil
+	}, nil, "", nil)
+	exporter.AddMetric("slow", func(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
+		select {
+		case <-time.After(time.Minute):
+			ch <- prometheus.MustNewConstMetric(exporter.Metrics["slow"].Metric, prometheus.GaugeValue, 1)
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, nil, "", nil)
+
+	expected := `
+# HELP openstack_test_fast fast
+# TYPE openstack_test_fast gauge
+openstack_test_fast 1
+# HELP openstack_test_up up
+# TYPE openstack_test_up gauge
+openstack_test_up 1
+`
+	done := make(chan error, 1)
+	go func() {
+		done <- testutil.CollectAndCompare(exporter, strings.NewReader(expected), "openstack_test_fast", "openstack_test_slow", "openstack_test_up")
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("collection was not stopped after the metric timeout")
+	}
+}
+
+func TestWithContextStopsCollection(t *testing.T) {
+	exporter := &BaseOpenStackExporter{
+		Name:           "test",
+		ExporterConfig: ExporterConfig{Prefix: "openstack", ServiceName: "test"},
+		logger:         slog.New(slog.DiscardHandler),
+	}
+	exporter.AddMetric("blocking", func(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil, "", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	expected := `
+# HELP openstack_test_up up
+# TYPE openstack_test_up gauge
+openstack_test_up 0
+`
+	err := testutil.CollectAndCompare(WithContext(ctx, exporter), strings.NewReader(expected), "openstack_test_up")
+	assert.NoError(t, err)
+}
+
+func TestCollectorSelfMetrics(t *testing.T) {
+	exporter := &BaseOpenStackExporter{
+		Name:           "test",
+		ExporterConfig: ExporterConfig{Prefix: "openstack", ServiceName: "compute"},
+		logger:         slog.New(slog.DiscardHandler),
+	}
+	exporter.AddMetric("ok", func(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
+		return nil
+	}, nil, "", nil)
+	exporter.AddMetric("broken", func(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
+		return errors.New("403 Forbidden")
+	}, nil, "", nil)
+
+	expected := `
+# HELP openstack_exporter_collector_success Whether the last collection of the metric succeeded
+# TYPE openstack_exporter_collector_success gauge
+openstack_exporter_collector_success{metric="broken",service="compute"} 0
+openstack_exporter_collector_success{metric="ok",service="compute"} 1
+`
+	err := testutil.CollectAndCompare(exporter, strings.NewReader(expected), "openstack_exporter_collector_success")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, testutil.CollectAndCount(exporter, "openstack_exporter_collector_duration_seconds"))
+	// Only the metric that succeeded has a last success timestamp.
+	assert.Equal(t, 1, testutil.CollectAndCount(exporter, "openstack_exporter_collector_last_success_timestamp_seconds"))
+}
+
+func TestCollectorLastSuccessOutlivesExporter(t *testing.T) {
+	failing := false
+	newExporter := func(now time.Time) *BaseOpenStackExporter {
+		exporter := NewBaseOpenStackExporter("test", &ExporterConfig{
+			Prefix:      "openstack",
+			ServiceName: "compute",
+			Cloud:       "last-success-cloud",
+			Clock:       fixedClock(now),
+		}, slog.New(slog.DiscardHandler))
+		exporter.AddMetric("servers", func(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
+			if failing {
+				return errors.New("503 Service Unavailable")
+			}
+			return nil
+		}, nil, "", nil)
+		return exporter
+	}
+
+	succeeded := time.Unix(1704164645, 0)
+	assert.Equal(t, 1, testutil.CollectAndCount(newExporter(succeeded), "openstack_exporter_collector_last_success_timestamp_seconds"))
+
+	// The cache builds a new exporter on every refresh.
+	failing = true
+	expected := `
+# HELP openstack_exporter_collector_last_success_timestamp_seconds Unix timestamp of the last successful collection of the metric
+# TYPE openstack_exporter_collector_last_success_timestamp_seconds gauge
+openstack_exporter_collector_last_success_timestamp_seconds{metric="servers",service="compute"} 1.704164645e+09
+`
+	err := testutil.CollectAndCompare(newExporter(succeeded.Add(time.Hour)), strings.NewReader(expected), "openstack_exporter_collector_last_success_timestamp_seconds")
+	assert.NoError(t, err)
+}
+
+func TestCollectMetricFilter(t *testing.T) {
+	exporter := &BaseOpenStackExporter{
+		Name: "test",
+		ExporterConfig: ExporterConfig{
+			Prefix:        "openstack",
+			ServiceName:   "test",
+			CollectMetric: func(metric string) bool { return metric == "test-fast" },
+		},
+		logger: slog.New(slog.DiscardHandler),
+	}
+	for _, name := range []string{"fast", "slow"} {
+		exporter.AddMetric(name, func(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
+			ch <- prometheus.MustNewConstMetric(exporter.Metrics[name].Metric, prometheus.GaugeValue, 1)
+			return nil
+		}, nil, "", nil)
+	}
+
+	expected := `
+# HELP openstack_test_fast fast
+# TYPE openstack_test_fast gauge
+openstack_test_fast 1
+# HELP openstack_test_up up
+# TYPE openstack_test_up gauge
+openstack_test_up 1
+`
+	err := testutil.CollectAndCompare(exporter, strings.NewReader(expected), "openstack_test_fast", "openstack_test_slow", "openstack_test_up")
+	assert.NoError(t, err)
+}
+
+func TestMetricService(t *testing.T) {
+	service, ok := MetricService("nova-agent_state")
+	assert.True(t, ok)
+	assert.Equal(t, "compute", service)
+
+	service, ok = MetricService("container_infra-cluster_status")
+	assert.True(t, ok)
+	assert.Equal(t, "container-infra", service)
+
+	_, ok = MetricService("unknown-metric")
+	assert.False(t, ok)
+	_, ok = MetricService("nova-agent_states")
+	assert.False(t, ok, "the metric must be one of the service")
+	_, ok = MetricService("nova")
+	assert.False(t, ok)
+	_, ok = MetricService("nova-")
+	assert.False(t, ok)
+
+	for _, service := range SupportedExporters {
+		assert.NotEmpty(t, ExporterName(service), service)
+	}
+}
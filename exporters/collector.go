@@ -0,0 +1,133 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	gophercloudv2 "github.com/gophercloud/gophercloud/v2"
+	clientconfigv2 "github.com/gophercloud/utils/v2/openstack/clientconfig"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// New returns a prometheus.Collector of the services of opts.Services,
+// collected from the cloud of opts. The client of the cloud is authenticated
+// with ctx. The Collector also implements ContextCollector, so a collection
+// can be bounded by a context:
+//
+//	collector, err := exporters.New(ctx, exporters.Options{
+//		Cloud:        "prod",
+//		Services:     []string{"compute", "network"},
+//		Prefix:       "openstack",
+//		EndpointType: "public",
+//		Logger:       logger,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	registry.MustRegister(collector)
+func New(ctx context.Context, opts Options) (prometheus.Collector, error) {
+	pc, err := opts.providerClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	services := opts.Services
+	if len(services) == 0 {
+		services, err = availableServices(pc.Client, opts.endpointOpts(pc))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &collector{exporters: make([]OpenStackExporter, 0, len(services))}
+	for _, service := range services {
+		if !IsExporterNameValid(service) {
+			return nil, fmt.Errorf("unknown service %q", service)
+		}
+		exporter, err := newExporter(service, pc, opts)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", service, err)
+		}
+		c.exporters = append(c.exporters, exporter)
+	}
+	return c, nil
+}
+
+// collector collects the exporters of several services of a cloud.
+type collector struct {
+	exporters []OpenStackExporter
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, exporter := range c.exporters {
+		exporter.Describe(ch)
+	}
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	c.CollectWithContext(context.Background(), ch)
+}
+
+// CollectWithContext collects every service concurrently.
+func (c *collector) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	collectAll(ctx, c.exporters, ch)
+}
+
+// collectAll collects exporters concurrently, with ctx when they implement
+// ContextCollector.
+func collectAll(ctx context.Context, exporters []OpenStackExporter, ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	for _, exporter := range exporters {
+		wg.Go(func() {
+			if cc, ok := exporter.(ContextCollector); ok {
+				cc.CollectWithContext(ctx, ch)
+				return
+			}
+			exporter.Collect(ch)
+		})
+	}
+	wg.Wait()
+}
+
+// providerClient returns the authenticated client of the cloud of opts: the
+// injected ProviderClient, a client authenticated through the injected
+// Transport, or the one of DefaultProviderClientPool.
+func (opts Options) providerClient(ctx context.Context) (*PooledProviderClient, error) {
+	switch {
+	case opts.ProviderClient != nil:
+		return &PooledProviderClient{Client: opts.ProviderClient, Cloud: &clientconfigv2.Cloud{}}, nil
+	case opts.Transport != nil:
+		instrumentation := newInstrumentedRoundTripper(opts.Transport, opts.Cloud)
+		client, cloudConfig, region, err := newAuthenticatedProviderClient(ctx, &clientconfigv2.ClientOpts{Cloud: opts.Cloud}, instrumentation)
+		if err != nil {
+			return nil, err
+		}
+		if cloudConfig.AuthInfo != nil {
+			instrumentation.RegisterEndpoint(cloudConfig.AuthInfo.AuthURL, "identity")
+		}
+		registerCatalogEndpoints(instrumentation, client)
+		return &PooledProviderClient{Client: client, Cloud: cloudConfig, Region: region, instrumentation: instrumentation}, nil
+	default:
+		return DefaultProviderClientPool.Get(ctx, opts.Cloud, opts.logger())
+	}
+}
+
+// endpointOpts returns the EndpointOpts of the services of pc, in the Region
+// of opts when set.
+func (opts Options) endpointOpts(pc *PooledProviderClient) gophercloudv2.EndpointOpts {
+	eo := pc.EndpointOpts(opts.EndpointType)
+	if opts.Region != "" {
+		eo.Region = opts.Region
+	}
+	return eo
+}
+
+// logger returns the Logger of opts, discarding the logs when nil.
+func (opts Options) logger() *slog.Logger {
+	if opts.Logger != nil {
+		return opts.Logger
+	}
+	return slog.New(slog.DiscardHandler)
+}
@@ -0,0 +1,113 @@
+package exporters
+
+import (
+	"context"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack"
+	"github.com/jarcoal/httpmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// newMockTransport returns a transport serving the Keystone token and the
+// Glance images of the fixtures, without touching http.DefaultTransport.
+func newMockTransport(t *testing.T) *httpmock.MockTransport {
+	transport := httpmock.NewMockTransport()
+	for _, response := range []struct {
+		method, url, fixture string
+		status               int
+	}{
+		{"POST", "http://test.cloud:35357/v3/auth/tokens", "tokens", 201},
+		{"GET", "http://test.cloud/glance/", "glance_api_discovery", 200},
+		{"GET", "http://test.cloud/glance/v2/images", "glance_images", 200},
+	} {
+		data, err := os.ReadFile(path.Join(baseFixturePath, response.fixture+".json"))
+		require.NoError(t, err)
+		transport.RegisterResponder(response.method, response.url, httpmock.NewBytesResponder(response.status, data).HeaderSet(map[string][]string{
+			"Content-Type":    {"application/json"},
+			"X-Subject-Token": {"1234"},
+		}))
+	}
+	return transport
+}
+
+func TestNewWithTransport(t *testing.T) {
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path.Join(baseFixturePath, "test_config.yaml"))
+	transport := newMockTransport(t)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	images, err := New(context.Background(), Options{
+		Cloud:        cloudName,
+		Services:     []string{"image"},
+		Transport:    transport,
+		Clock:        fixedClock(now),
+		Prefix:       "openstack",
+		EndpointType: "public",
+	})
+	require.NoError(t, err)
+
+	expected := `
+# HELP openstack_exporter_collector_last_success_timestamp_seconds Unix timestamp of the last successful collection of the metric
+# TYPE openstack_exporter_collector_last_success_timestamp_seconds gauge
+openstack_exporter_collector_last_success_timestamp_seconds{metric="image_bytes",service="image"} 1.704164645e+09
+openstack_exporter_collector_last_success_timestamp_seconds{metric="images",service="image"} 1.704164645e+09
+# HELP openstack_glance_images images
+# TYPE openstack_glance_images gauge
+openstack_glance_images 2
+# HELP openstack_glance_up up
+# TYPE openstack_glance_up gauge
+openstack_glance_up 1
+`
+	err = testutil.CollectAndCompare(images, strings.NewReader(expected),
+		"openstack_exporter_collector_last_success_timestamp_seconds", "openstack_glance_images", "openstack_glance_up")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, transport.GetCallCountInfo()["POST http://test.cloud:35357/v3/auth/tokens"], "the injected transport is used")
+}
+
+func TestNewWithProviderClient(t *testing.T) {
+	transport := newMockTransport(t)
+	client, err := openstack.NewClient("http://test.cloud:35357/v3")
+	require.NoError(t, err)
+	client.HTTPClient.Transport = transport
+	require.NoError(t, openstack.Authenticate(context.Background(), client, gophercloud.AuthOptions{
+		IdentityEndpoint: "http://test.cloud:35357/v3",
+		Username:         "admin",
+		Password:         "admin",
+		DomainName:       "Default",
+	}))
+
+	services, err := availableServices(client, gophercloud.EndpointOpts{Region: "RegionOne", Availability: gophercloud.AvailabilityPublic})
+	require.NoError(t, err)
+	assert.Contains(t, services, "image", "the services of New default to the ones of the catalog")
+
+	images, err := New(context.Background(), Options{
+		ProviderClient: client,
+		Services:       []string{"image"},
+		Region:         "RegionOne",
+		Prefix:         "openstack",
+		EndpointType:   "public",
+	})
+	require.NoError(t, err)
+	expected := `
+# HELP openstack_glance_images images
+# TYPE openstack_glance_images gauge
+openstack_glance_images 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(images, strings.NewReader(expected), "openstack_glance_images"))
+
+	_, err = New(context.Background(), Options{ProviderClient: client, Services: []string{"image", "queue"}})
+	assert.EqualError(t, err, `unknown service "queue"`)
+}
@@ -2,84 +2,132 @@ package exporters
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/common/version"
+	pver "github.com/prometheus/client_golang/prometheus/collectors/version"
+	dto "github.com/prometheus/client_model/go"
 )
 
+// Scrape modes reported in the mode label of the common metrics.
+const (
+	ScrapeModeMetrics = "metrics"
+	ScrapeModeProbe   = "probe"
+	ScrapeModeCache   = "cache"
+)
+
+// UnknownCloud is the cloud label of the scrapes of a cloud missing from
+// clouds.yaml, so the cloud parameter of a probe doesn't add label values.
+const UnknownCloud = "unknown"
+
 type CommonMetricsExporter struct {
-	totalScrapes   prometheus.Counter
-	scrapeDuration prometheus.Histogram
-	scrapeErrors   prometheus.Counter
-	buildInfo      prometheus.Metric
+	totalScrapes   *prometheus.CounterVec
+	scrapeDuration *prometheus.HistogramVec
+	scrapeErrors   *prometheus.CounterVec
+	buildInfo      prometheus.Collector
+
+	// Deprecated: replaced by scrapeDuration, which is in seconds.
+	scrapeDurationMiliseconds *prometheus.HistogramVec
+	disableDeprecatedMetrics  bool
 }
 
-func NewCommonMetricsExporter(prefix string) *CommonMetricsExporter {
-	totalScrapes := prometheus.NewCounter(prometheus.CounterOpts{
+func NewCommonMetricsExporter(prefix string, disableDeprecatedMetrics bool) *CommonMetricsExporter {
+	labels := []string{"cloud", "mode"}
+
+	totalScrapes := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: fmt.Sprintf("%s_exporter_scrapes_total", prefix),
 		Help: "Total number of scrapes",
-	})
+	}, labels)
+
+	scrapeDuration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    fmt.Sprintf("%s_exporter_scrape_duration_seconds", prefix),
+		Help:    "Duration of scrapes in seconds",
+		Buckets: []float64{0.5, 0.75, 1, 5, 10, 30, 60, 120},
+	}, labels)
 
-	scrapeDuration := prometheus.NewHistogram(prometheus.HistogramOpts{
+	scrapeDurationMiliseconds := prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name:    fmt.Sprintf("%s_exporter_scrape_duration_miliseconds", prefix),
-		Help:    "Duration of scrapes",
+		Help:    "Duration of scrapes (deprecated, use the _scrape_duration_seconds metric)",
 		Buckets: []float64{500, 750, 1000, 5000, 10000, 30000},
-	})
+	}, labels)
 
-	scrapeErrors := prometheus.NewCounter(prometheus.CounterOpts{
+	scrapeErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: fmt.Sprintf("%s_exporter_scrape_errors_total", prefix),
 		Help: "Total number of scrape errors",
-	})
-
-	buildInfo := prometheus.MustNewConstMetric(
-		prometheus.NewDesc(
-			fmt.Sprintf("%s_exporter_build_info", prefix),
-			"A metric with a constant '1' value labeled by version, revision, branch, and goversion.",
-			nil,
-			prometheus.Labels{
-				"version":   version.Version,
-				"revision":  version.Revision,
-				"branch":    version.Branch,
-				"goversion": version.GoVersion,
-			},
-		),
-		prometheus.GaugeValue, 1,
-	)
+	}, labels)
 
 	return &CommonMetricsExporter{
-		totalScrapes:   totalScrapes,
-		scrapeDuration: scrapeDuration,
-		scrapeErrors:   scrapeErrors,
-		buildInfo:      buildInfo,
+		totalScrapes:              totalScrapes,
+		scrapeDuration:            scrapeDuration,
+		scrapeErrors:              scrapeErrors,
+		buildInfo:                 pver.NewCollector(fmt.Sprintf("%s_exporter", prefix)),
+		scrapeDurationMiliseconds: scrapeDurationMiliseconds,
+		disableDeprecatedMetrics:  disableDeprecatedMetrics,
 	}
 }
 
 func (e *CommonMetricsExporter) Describe(ch chan<- *prometheus.Desc) {
-	ch <- e.totalScrapes.Desc()
-	ch <- e.scrapeDuration.Desc()
-	ch <- e.scrapeErrors.Desc()
-	ch <- e.buildInfo.Desc()
+	e.totalScrapes.Describe(ch)
+	e.scrapeDuration.Describe(ch)
+	e.scrapeErrors.Describe(ch)
+	e.buildInfo.Describe(ch)
+	if !e.disableDeprecatedMetrics {
+		e.scrapeDurationMiliseconds.Describe(ch)
+	}
 }
 
 func (e *CommonMetricsExporter) Collect(ch chan<- prometheus.Metric) {
-	ch <- e.totalScrapes
-	ch <- e.scrapeDuration
-	ch <- e.scrapeErrors
-	ch <- e.buildInfo
+	e.totalScrapes.Collect(ch)
+	e.scrapeDuration.Collect(ch)
+	e.scrapeErrors.Collect(ch)
+	e.buildInfo.Collect(ch)
+	if !e.disableDeprecatedMetrics {
+		e.scrapeDurationMiliseconds.Collect(ch)
+	}
 }
 
 func (e *CommonMetricsExporter) MetricIsDisabled(name string) bool {
 	return false
 }
 
-func (e *CommonMetricsExporter) TotalScrapes() prometheus.Counter {
+// ObserveScrape records a scrape of cloud in the given mode.
+func (e *CommonMetricsExporter) ObserveScrape(cloud, mode string, duration time.Duration, failed bool) {
+	e.totalScrapes.WithLabelValues(cloud, mode).Inc()
+	e.scrapeDuration.WithLabelValues(cloud, mode).Observe(duration.Seconds())
+	e.scrapeDurationMiliseconds.WithLabelValues(cloud, mode).Observe(float64(duration.Milliseconds()))
+	if failed {
+		e.scrapeErrors.WithLabelValues(cloud, mode).Inc()
+	}
+}
+
+func (e *CommonMetricsExporter) TotalScrapes() *prometheus.CounterVec {
 	return e.totalScrapes
 }
 
-func (e *CommonMetricsExporter) ScrapeDuration() prometheus.Histogram {
+func (e *CommonMetricsExporter) ScrapeDuration() *prometheus.HistogramVec {
 	return e.scrapeDuration
 }
 
-func (e *CommonMetricsExporter) ScrapeErrors() prometheus.Counter {
+func (e *CommonMetricsExporter) ScrapeErrors() *prometheus.CounterVec {
 	return e.scrapeErrors
 }
+
+// CollectorFailures counts the collectors reported as failed by the
+// exporter_collector_success metric in mfs.
+func CollectorFailures(prefix string, mfs []*dto.MetricFamily) int {
+	name := prometheus.BuildFQName(prefix, "exporter", "collector_success")
+
+	failures := 0
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			if m.GetGauge().GetValue() == 0 {
+				failures++
+			}
+		}
+	}
+
+	return failures
+}
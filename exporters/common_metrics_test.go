@@ -2,18 +2,19 @@ package exporters
 
 import (
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestCommonMetricsExporter(t *testing.T) {
-	exporter := NewCommonMetricsExporter("test")
+	exporter := NewCommonMetricsExporter("test", false)
 
-	exporter.TotalScrapes().Inc()
-	exporter.ScrapeErrors().Add(2)
-	exporter.ScrapeDuration().Observe(1234)
+	exporter.ObserveScrape("cloud-a", ScrapeModeProbe, 1234*time.Millisecond, true)
 
 	// Register to a test registry
 	reg := prometheus.NewRegistry()
@@ -26,6 +27,7 @@ func TestCommonMetricsExporter(t *testing.T) {
 	metricsMap := map[string]bool{
 		"test_exporter_scrapes_total":               false,
 		"test_exporter_scrape_errors_total":         false,
+		"test_exporter_scrape_duration_seconds":     false,
 		"test_exporter_scrape_duration_miliseconds": false,
 		"test_exporter_build_info":                  false,
 	}
@@ -42,12 +44,40 @@ func TestCommonMetricsExporter(t *testing.T) {
 }
 
 func TestCommonMetricsExporterScrapeCounters(t *testing.T) {
-	exporter := NewCommonMetricsExporter("unit")
+	exporter := NewCommonMetricsExporter("unit", false)
 
-	exporter.TotalScrapes().Inc()
-	exporter.ScrapeErrors().Add(5)
-	exporter.ScrapeDuration().Observe(1000)
+	exporter.ObserveScrape("cloud-a", ScrapeModeProbe, time.Second, false)
+	exporter.ObserveScrape("cloud-a", ScrapeModeProbe, time.Second, true)
+	exporter.ObserveScrape("cloud-b", ScrapeModeCache, time.Second, true)
 
-	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.TotalScrapes()))
-	assert.Equal(t, float64(5), testutil.ToFloat64(exporter.ScrapeErrors()))
+	assert.Equal(t, float64(2), testutil.ToFloat64(exporter.TotalScrapes().WithLabelValues("cloud-a", ScrapeModeProbe)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.ScrapeErrors().WithLabelValues("cloud-a", ScrapeModeProbe)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(exporter.ScrapeErrors().WithLabelValues("cloud-b", ScrapeModeCache)))
+}
+
+func TestCommonMetricsExporterDisableDeprecated(t *testing.T) {
+	exporter := NewCommonMetricsExporter("unit", true)
+	exporter.ObserveScrape("cloud-a", ScrapeModeMetrics, time.Second, false)
+
+	assert.Equal(t, 0, testutil.CollectAndCount(exporter, "unit_exporter_scrape_duration_miliseconds"))
+	assert.Equal(t, 1, testutil.CollectAndCount(exporter, "unit_exporter_scrape_duration_seconds"))
+}
+
+func TestCollectorFailures(t *testing.T) {
+	gauge := func(v float64) *dto.Metric {
+		return &dto.Metric{Gauge: &dto.Gauge{Value: proto.Float64(v)}}
+	}
+	mfs := []*dto.MetricFamily{
+		{
+			Name:   proto.String("openstack_exporter_collector_success"),
+			Metric: []*dto.Metric{gauge(1), gauge(0), gauge(0)},
+		},
+		{
+			Name:   proto.String("openstack_nova_up"),
+			Metric: []*dto.Metric{gauge(0)},
+		},
+	}
+
+	assert.Equal(t, 2, CollectorFailures("openstack", mfs))
+	assert.Equal(t, 0, CollectorFailures("other", mfs))
 }
@@ -0,0 +1,38 @@
+// Package exporters implements the collectors of the OpenStack services
+// exported by openstack-exporter, and can be embedded in other programs.
+//
+// New builds a prometheus.Collector of the services of a cloud from an
+// Options value:
+//
+//	collector, err := exporters.New(ctx, exporters.Options{
+//		Cloud:    "prod",
+//		Services: []string{"compute", "network"},
+//		Prefix:   "openstack",
+//	})
+//
+// The cloud is read from the clouds.yaml found by gophercloud, unless an
+// authenticated *gophercloud.ProviderClient is set in Options.ProviderClient.
+// Options.Transport replaces the http.RoundTripper of the requests,
+// Options.Logger receives the logs and Options.Clock tells the time of the
+// collector metrics. New reads no environment variable besides the ones of
+// clouds.yaml and keeps no state shared between collectors, except the
+// authenticated clients of DefaultProviderClientPool when neither
+// ProviderClient nor Transport is set, and the API metrics of
+// APIMetricsCollector.
+//
+// Other services are added with Register, from the init function of the
+// package implementing their exporter, and are then built by New and the
+// openstack-exporter command like the built-in ones.
+//
+// # Stability
+//
+// New, Options, Clock, ContextCollector, Register, Service, ClientFactory,
+// ExporterConstructor, NewBaseOpenStackExporter, SupportedExporters and
+// IsExporterNameValid follow semantic versioning: within a major version,
+// their signatures don't change and fields are only added to Options, with a
+// zero value keeping the previous behaviour. The names, labels and meaning of
+// the metrics follow the deprecation policy documented in the README. The
+// other exported identifiers of the package, such as NewExporter,
+// ExporterConfig and the service exporters, serve the openstack-exporter
+// command and may change in any release.
+package exporters
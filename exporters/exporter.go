@@ -2,7 +2,7 @@ package exporters
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -13,8 +13,6 @@ import (
 	"log/slog"
 
 	gophercloudv2 "github.com/gophercloud/gophercloud/v2"
-	clientutilsv2 "github.com/gophercloud/utils/v2/client"
-	clientconfigv2 "github.com/gophercloud/utils/v2/openstack/clientconfig"
 	"github.com/hashicorp/go-uuid"
 	"github.com/mitchellh/go-homedir"
 	"github.com/openstack-exporter/openstack-exporter/utils"
@@ -41,8 +39,6 @@ const (
 	TERABYTE
 )
 
-var SupportedExporters = []string{"network", "compute", "image", "volume", "identity", "object-store", "load-balancer", "container-infra", "dns", "baremetal", "gnocchi", "database", "orchestration", "placement", "sharev2"}
-
 type OpenStackExporter interface {
 	prometheus.Collector
 
@@ -51,8 +47,70 @@ type OpenStackExporter interface {
 	MetricIsDisabled(name string) bool
 }
 
-func EnableExporter(service, prefix, cloud string, disabledMetrics []string, endpointType string, collectTime bool, disableSlowMetrics bool, disableDeprecatedMetrics bool, disableCinderAgentUUID bool, domainID string, tenantID string, novaMetadataMapping *utils.LabelMappingFlag, dnsConcurrentCount int, uuidGenFunc func() (string, error), logger *slog.Logger) (*OpenStackExporter, error) {
-	exporter, err := NewExporter(service, prefix, cloud, disabledMetrics, endpointType, collectTime, disableSlowMetrics, disableDeprecatedMetrics, disableCinderAgentUUID, domainID, tenantID, novaMetadataMapping, dnsConcurrentCount, uuidGenFunc, logger)
+// Options holds the settings the exporters of a cloud are built with. The
+// zero value of every field is a valid default.
+type Options struct {
+	// Cloud is the name of the cloud in clouds.yaml, authenticated through
+	// DefaultProviderClientPool unless ProviderClient or Transport is set.
+	// It labels the API metrics of the cloud.
+	Cloud string
+	// Services lists the services collected by New, every supported
+	// service of the service catalog when empty.
+	Services []string
+	// ProviderClient is an authenticated client used instead of the
+	// credentials of Cloud in clouds.yaml. Its HTTP client is left as is, so
+	// the API metrics, retries and rate limits of the exporter don't apply.
+	ProviderClient *gophercloudv2.ProviderClient
+	// Transport, when ProviderClient is nil, sends the requests to the
+	// cloud in place of the transport built from the TLS settings of
+	// clouds.yaml. The client is then authenticated outside of
+	// DefaultProviderClientPool.
+	Transport http.RoundTripper
+	// Region is the region collected without region label, the one of the
+	// cloud in clouds.yaml when empty, or any region with a ProviderClient.
+	// It is ignored when Regions or DiscoverRegions is set.
+	Region string
+	// Logger receives the logs of the exporters, which are discarded when
+	// nil.
+	Logger *slog.Logger
+	// Clock tells the time of the collector metrics, the system clock when
+	// nil.
+	Clock Clock
+
+	Prefix                   string
+	DisabledMetrics          []string
+	EndpointType             string
+	CollectTime              bool
+	DisableSlowMetrics       bool
+	DisableDeprecatedMetrics bool
+	DisableCinderAgentUUID   bool
+	DomainID                 string
+	TenantID                 string
+	NovaMetadataMapping      *utils.LabelMappingFlag
+	DnsConcurrentCount       int
+	ProjectConcurrentCount   int
+	CollectTimeouts          CollectTimeouts
+	// CollectMetric reports whether a metric, in the same service-metric
+	// format as --disable-metric, is collected. Every enabled metric is when
+	// nil. Unlike a disabled metric, a skipped one is still described.
+	CollectMetric func(metric string) bool
+	// Regions lists the regions to collect, each one labelled with a region
+	// label. When empty and DiscoverRegions is set, every region of the
+	// service catalog is collected. Otherwise only the region of the cloud is
+	// collected, without region label.
+	Regions         []string
+	DiscoverRegions bool
+	// UUIDGenFunc generates the Cinder agent UUIDs, uuid.GenerateUUID when nil.
+	UUIDGenFunc func() (string, error)
+}
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+func EnableExporter(service, cloud string, opts Options, logger *slog.Logger) (*OpenStackExporter, error) {
+	exporter, err := NewExporter(service, cloud, opts, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -65,8 +123,16 @@ type PrometheusMetric struct {
 }
 
 type ExporterConfig struct {
-	ClientV2                 *gophercloudv2.ServiceClient
-	ServiceName              string
+	ClientV2    *gophercloudv2.ServiceClient
+	ServiceName string
+	// Cloud is the name of the cloud the exporter collects, which keys the
+	// last success of its metrics across the exporters built for it.
+	Cloud string
+	// EndpointOpts are the ones ClientV2 was built with, used to build the
+	// clients of the other services the exporter needs, such as Keystone.
+	EndpointOpts gophercloudv2.EndpointOpts
+	// Region is added as a region label to every metric when not empty.
+	Region                   string
 	Prefix                   string
 	DisabledMetrics          []string
 	CollectTime              bool
@@ -78,21 +144,48 @@ type ExporterConfig struct {
 	TenantID                 string
 	NovaMetadataMapping      *utils.LabelMappingFlag
 	DnsConcurrentCount       int
+	ProjectConcurrentCount   int
+	CollectTimeouts          CollectTimeouts
+	CollectMetric            func(metric string) bool
+	// Clock tells the time, the system clock when nil.
+	Clock Clock
 }
 
 type BaseOpenStackExporter struct {
 	ExporterConfig
-	Name    string
-	Metrics map[string]*PrometheusMetric
-	logger  *slog.Logger
+	Name       string
+	Metrics    map[string]*PrometheusMetric
+	logger     *slog.Logger
+	authFailed atomic.Bool
+}
+
+// lastSuccesses maps a lastSuccessKey to the time of the last successful
+// collection of the metric. It outlives the exporters, as the cache builds new
+// ones on every refresh.
+var lastSuccesses sync.Map
+
+// lastSuccessKey identifies a metric of a service collected for a cloud and
+// region.
+type lastSuccessKey struct {
+	cloud, region, service, metric string
 }
 
 type ListFunc func(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error
 
-var (
-	endpointOptsV2   map[string]gophercloudv2.EndpointOpts
-	endpointOptsV2Mu sync.Mutex
-)
+// NewBaseOpenStackExporter returns the BaseOpenStackExporter named name, for
+// the exporters of the services added with Register, which add their metrics
+// to it with AddMetric.
+func NewBaseOpenStackExporter(name string, config *ExporterConfig, logger *slog.Logger) *BaseOpenStackExporter {
+	return &BaseOpenStackExporter{Name: name, ExporterConfig: *config, logger: logger}
+}
+
+// now returns the current time of the exporter's Clock.
+func (exporter *BaseOpenStackExporter) now() time.Time {
+	if exporter.Clock != nil {
+		return exporter.Clock.Now()
+	}
+	return time.Now()
+}
 
 func (exporter *BaseOpenStackExporter) GetName() string {
 	return fmt.Sprintf("%s_%s", exporter.Prefix, exporter.Name)
@@ -113,27 +206,46 @@ func (exporter *BaseOpenStackExporter) Describe(ch chan<- *prometheus.Desc) {
 	}
 }
 
-func (exporter *BaseOpenStackExporter) RunCollection(metric *PrometheusMetric, metricName string, ch chan<- prometheus.Metric, logger *slog.Logger) error {
-	ctx := context.TODO()
+// RunCollection runs the ListFunc of a metric, sending its metrics to ch. The
+// ListFunc is given ctx bounded by the metric's own timeout, so its requests
+// stop when either expires.
+func (exporter *BaseOpenStackExporter) RunCollection(ctx context.Context, metric *PrometheusMetric, metricName string, ch chan<- prometheus.Metric, logger *slog.Logger) error {
+	if timeout := exporter.CollectTimeouts.forMetric(exporter.Name, metricName); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
 	exporter.logger.Info("Collecting metrics for exporter", "exporter", exporter.GetName(), "metrics", metricName)
-	now := time.Now()
-	err := metric.Fn(ctx, exporter, ch)
-	if err != nil {
-		return fmt.Errorf("failed to collect metric: %s, error: %s", metricName, err)
+	start := exporter.now()
+	if err := metric.Fn(ctx, exporter, ch); err != nil {
+		return fmt.Errorf("failed to collect metric: %s, error: %w", metricName, err)
 	}
 
 	exporter.logger.Info("Collected metrics for exporter", "exporter", exporter.GetName(), "metrics", metricName)
 	if exporter.CollectTime {
-		ch <- prometheus.MustNewConstMetric(exporter.Metrics["openstack_metric_collect_seconds"].Metric, prometheus.GaugeValue, time.Since(now).Seconds(), metricName)
+		ch <- prometheus.MustNewConstMetric(exporter.Metrics["openstack_metric_collect_seconds"].Metric, prometheus.GaugeValue, exporter.now().Sub(start).Seconds(), metricName)
 	}
 
 	return nil
 }
 
 func (exporter *BaseOpenStackExporter) Collect(ch chan<- prometheus.Metric) {
+	exporter.CollectWithContext(context.Background(), ch)
+}
+
+// CollectWithContext collects every metric of the exporter, stopping the ones
+// still running when ctx or the service timeout expires.
+func (exporter *BaseOpenStackExporter) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	if timeout := exporter.CollectTimeouts.forService(exporter.ServiceName); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	metricsCount := 0
 	var failures int32
+	var authFailures int32
 
 	var g errgroup.Group
 
@@ -142,6 +254,9 @@ func (exporter *BaseOpenStackExporter) Collect(ch chan<- prometheus.Metric) {
 			exporter.logger.Debug("No function handler set for metric", "metric", name)
 			continue
 		}
+		if exporter.CollectMetric != nil && !exporter.CollectMetric(fmt.Sprintf("%s-%s", exporter.Name, name)) {
+			continue
+		}
 
 		metricsCount++
 
@@ -149,7 +264,14 @@ func (exporter *BaseOpenStackExporter) Collect(ch chan<- prometheus.Metric) {
 		metric := metric
 
 		g.Go(func() error {
-			if err := exporter.RunCollection(metric, name, ch, exporter.logger); err != nil {
+			start := exporter.now()
+			projectStats := &projectCollectionStats{}
+			err := exporter.RunCollection(withProjectCollectionStats(ctx, projectStats), metric, name, ch, exporter.logger)
+			exporter.collectCollectorMetrics(ch, name, exporter.now().Sub(start), err == nil)
+			if projectStats.used.Load() {
+				ch <- prometheus.MustNewConstMetric(exporter.Metrics["exporter_collector_failed_projects"].Metric, prometheus.GaugeValue, float64(projectStats.failed.Load()), name)
+			}
+			if err != nil {
 				exporter.logger.Error(
 					"Failed to collect metric for exporter",
 					"exporter", exporter.Name,
@@ -157,6 +279,9 @@ func (exporter *BaseOpenStackExporter) Collect(ch chan<- prometheus.Metric) {
 					"err", err,
 				)
 				atomic.AddInt32(&failures, 1)
+				if isAuthError(err) {
+					atomic.AddInt32(&authFailures, 1)
+				}
 			}
 			return nil
 		})
@@ -164,6 +289,8 @@ func (exporter *BaseOpenStackExporter) Collect(ch chan<- prometheus.Metric) {
 
 	_ = g.Wait()
 
+	exporter.authFailed.Store(atomic.LoadInt32(&authFailures) > 0)
+
 	if metricsCount == 0 {
 		ch <- prometheus.MustNewConstMetric(exporter.Metrics["up"].Metric, prometheus.GaugeValue, 0)
 		return
@@ -176,6 +303,38 @@ func (exporter *BaseOpenStackExporter) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
+// collectCollectorMetrics reports the outcome and duration of the collection of metric.
+func (exporter *BaseOpenStackExporter) collectCollectorMetrics(ch chan<- prometheus.Metric, metric string, duration time.Duration, success bool) {
+	key := lastSuccessKey{cloud: exporter.Cloud, region: exporter.Region, service: exporter.ServiceName, metric: metric}
+	successValue := 0.0
+	if success {
+		successValue = 1
+		lastSuccesses.Store(key, exporter.now())
+	}
+
+	ch <- prometheus.MustNewConstMetric(exporter.Metrics["exporter_collector_success"].Metric, prometheus.GaugeValue, successValue, metric)
+	ch <- prometheus.MustNewConstMetric(exporter.Metrics["exporter_collector_duration_seconds"].Metric, prometheus.GaugeValue, duration.Seconds(), metric)
+
+	if last, ok := lastSuccesses.Load(key); ok {
+		ch <- prometheus.MustNewConstMetric(exporter.Metrics["exporter_collector_last_success_timestamp_seconds"].Metric, prometheus.GaugeValue, float64(last.(time.Time).UnixNano())/1e9, metric)
+	}
+}
+
+// AuthFailed reports whether the last collection hit an authentication error,
+// meaning the exporter's client has to be rebuilt.
+func (exporter *BaseOpenStackExporter) AuthFailed() bool {
+	return exporter.authFailed.Load()
+}
+
+// isAuthError reports whether err was caused by Keystone rejecting the token.
+func isAuthError(err error) bool {
+	var reauthErr gophercloudv2.ErrUnableToReauthenticate
+	if errors.As(err, &reauthErr) {
+		return true
+	}
+	return gophercloudv2.ResponseCodeIs(err, http.StatusUnauthorized)
+}
+
 func (exporter *BaseOpenStackExporter) isSlowMetric(metric *Metric) bool {
 	return exporter.DisableSlowMetrics && metric.Slow
 }
@@ -199,21 +358,43 @@ func (exporter *BaseOpenStackExporter) AddMetric(name string, fn ListFunc, label
 		exporter.Metrics["up"] = &PrometheusMetric{
 			Metric: prometheus.NewDesc(
 				prometheus.BuildFQName(exporter.GetName(), "", "up"),
-				"up", nil, constLabels),
+				"up", nil, exporter.withRegionLabel(constLabels)),
 			Fn: nil,
 		}
+		collectorLabels := exporter.withRegionLabel(prometheus.Labels{"service": exporter.ServiceName})
+		exporter.Metrics["exporter_collector_success"] = &PrometheusMetric{
+			Metric: prometheus.NewDesc(
+				prometheus.BuildFQName(exporter.Prefix, "exporter", "collector_success"),
+				"Whether the last collection of the metric succeeded", []string{"metric"}, collectorLabels),
+			Fn: nil,
+		}
+		exporter.Metrics["exporter_collector_duration_seconds"] = &PrometheusMetric{
+			Metric: prometheus.NewDesc(
+				prometheus.BuildFQName(exporter.Prefix, "exporter", "collector_duration_seconds"),
+				"Time spent in the last collection of the metric", []string{"metric"}, collectorLabels),
+			Fn: nil,
+		}
+		exporter.Metrics["exporter_collector_last_success_timestamp_seconds"] = &PrometheusMetric{
+			Metric: prometheus.NewDesc(
+				prometheus.BuildFQName(exporter.Prefix, "exporter", "collector_last_success_timestamp_seconds"),
+				"Unix timestamp of the last successful collection of the metric", []string{"metric"}, collectorLabels),
+			Fn: nil,
+		}
+		exporter.Metrics["exporter_collector_failed_projects"] = &PrometheusMetric{
+			Metric: prometheus.NewDesc(
+				prometheus.BuildFQName(exporter.Prefix, "exporter", "collector_failed_projects"),
+				"Number of projects skipped by the last collection of the metric because their API calls failed", []string{"metric"}, collectorLabels),
+			Fn: nil,
+		}
+		// Deprecated: replaced by the exporter_collector_duration_seconds metric.
 		exporter.Metrics["openstack_metric_collect_seconds"] = &PrometheusMetric{
 			Metric: prometheus.NewDesc(
-				"openstack_metric_collect_seconds", "Time needed to collect metric from OpenStack API", []string{"openstack_metric"}, prometheus.Labels{"openstack_service": exporter.GetName()}),
+				"openstack_metric_collect_seconds", "Time needed to collect metric from OpenStack API", []string{"openstack_metric"}, exporter.withRegionLabel(prometheus.Labels{"openstack_service": exporter.GetName()})),
 			Fn: nil,
 		}
 	}
 
-	if constLabels == nil {
-		constLabels = prometheus.Labels{}
-	}
-
-	// @TODO: get the region. constLabels["region"] = exporter.
+	constLabels = exporter.withRegionLabel(constLabels)
 
 	if _, ok := exporter.Metrics[name]; !ok {
 		exporter.logger.Info("Adding metric to exporter", "metric", name, "exporter", exporter.Name)
@@ -226,10 +407,27 @@ func (exporter *BaseOpenStackExporter) AddMetric(name string, fn ListFunc, label
 	}
 }
 
+// withRegionLabel returns a copy of labels with the region label of the
+// exporter, if any.
+func (exporter *BaseOpenStackExporter) withRegionLabel(labels prometheus.Labels) prometheus.Labels {
+	withRegion := prometheus.Labels{}
+	for name, value := range labels {
+		withRegion[name] = value
+	}
+	if exporter.Region != "" {
+		withRegion["region"] = exporter.Region
+	}
+	return withRegion
+}
+
 func (exporter *BaseOpenStackExporter) GetDnsConcurrencyCount() int {
 	return exporter.DnsConcurrentCount
 }
 
+func (exporter *BaseOpenStackExporter) GetProjectConcurrencyCount() int {
+	return max(exporter.ProjectConcurrentCount, 1)
+}
+
 // took from here:
 // https://github.com/gophercloud/utils/blob/4c0f6d93d3a9b027a21d9206b6bdd09123de7a09/internal/util.go#L87
 func pathOrContents(poc string) ([]byte, bool, error) {
@@ -257,71 +455,41 @@ func pathOrContents(poc string) ([]byte, bool, error) {
 	return []byte(poc), false, nil
 }
 
-func NewExporter(name, prefix, cloud string, disabledMetrics []string, endpointType string, collectTime bool, disableSlowMetrics bool, disableDeprecatedMetrics bool, disableCinderAgentUUID bool, domainID string, tenantID string, novaMetadataMapping *utils.LabelMappingFlag, dnsConcurrentCount int, uuidGenFunc func() (string, error), logger *slog.Logger) (OpenStackExporter, error) {
-	var exporter OpenStackExporter
-	var err error
-	var transport http.RoundTripper
-	var tlsConfig tls.Config
+// NewExporter builds the exporter of the service name for cloud, with the
+// logger in place of opts.Logger.
+func NewExporter(name, cloud string, opts Options, logger *slog.Logger) (OpenStackExporter, error) {
+	opts.Cloud = cloud
+	opts.Logger = logger
 
-	optsv2 := clientconfigv2.ClientOpts{Cloud: cloud}
-
-	config, err := clientconfigv2.GetCloudFromYAML(&optsv2)
+	pc, err := opts.providerClient(context.TODO())
 	if err != nil {
 		return nil, err
 	}
 
-	var configureTransport = false
-	if !*config.Verify {
-		logger.Info("SSL verification disabled on transport")
-		tlsConfig.InsecureSkipVerify = true
-		configureTransport = true
-	} else if config.CACertFile != "" {
-		certPool, err := additionalTLSTrust(config.CACertFile, logger)
-		if err != nil {
-			logger.Error("Failed to include additional certificates to ca-trust", "err", err)
-		}
-		tlsConfig.RootCAs = certPool
-		configureTransport = true
-	}
-
-	// took from here:
-	// https://github.com/gophercloud/utils/blob/4c0f6d93d3a9b027a21d9206b6bdd09123de7a09/internal/util.go#L65
-	if config.ClientCertFile != "" && config.ClientKeyFile != "" {
-		clientCert, _, err := pathOrContents(config.ClientCertFile)
-		if err != nil {
-			return nil, fmt.Errorf("error reading Client Cert: %s", err)
-		}
-		clientKey, _, err := pathOrContents(config.ClientKeyFile)
-		if err != nil {
-			return nil, fmt.Errorf("error reading Client Key: %s", err)
-		}
-		cert, err := tls.X509KeyPair(clientCert, clientKey)
-		if err != nil {
-			return nil, err
-		}
-		tlsConfig.Certificates = []tls.Certificate{cert}
-		configureTransport = true
-	}
-	if configureTransport {
-		transport = &http.Transport{TLSClientConfig: &tlsConfig}
-	}
-
-	if _, ok := os.LookupEnv("OS_DEBUG"); ok {
-		if transport == nil {
-			transport = http.DefaultTransport
-		}
+	return newExporter(name, pc, opts)
+}
 
-		transport = &clientutilsv2.RoundTripper{
-			Rt:     transport,
-			Logger: &clientutilsv2.DefaultLogger{},
-		}
+// newExporter builds the exporter of the service name on top of pc.
+func newExporter(name string, pc *PooledProviderClient, opts Options) (OpenStackExporter, error) {
+	logger := opts.logger()
+	if opts.multiRegion() {
+		return newRegionalExporter(name, pc, opts, logger)
 	}
 
-	clientV2, err := NewServiceClientV2(name, &optsv2, transport, endpointType)
+	eo := opts.endpointOpts(pc)
+	clientV2, err := newServiceClientFromProvider(name, pc.Client, pc.Cloud, eo)
 	if err != nil {
 		return nil, err
 	}
+	pc.instrumentation.RegisterServiceClient(clientV2)
+
+	return newServiceExporter(name, clientV2, eo, "", opts, logger)
+}
 
+// newServiceExporter builds the exporter of service on top of clientV2, built
+// with eo. A non-empty region is added as a label to every metric.
+func newServiceExporter(name string, clientV2 *gophercloudv2.ServiceClient, eo gophercloudv2.EndpointOpts, region string, opts Options, logger *slog.Logger) (OpenStackExporter, error) {
+	uuidGenFunc := opts.UUIDGenFunc
 	if uuidGenFunc == nil {
 		uuidGenFunc = uuid.GenerateUUID
 	}
@@ -329,57 +497,29 @@ func NewExporter(name, prefix, cloud string, disabledMetrics []string, endpointT
 	exporterConfig := ExporterConfig{
 		ClientV2:                 clientV2,
 		ServiceName:              name,
-		Prefix:                   prefix,
-		DisabledMetrics:          disabledMetrics,
-		CollectTime:              collectTime,
+		Cloud:                    opts.Cloud,
+		EndpointOpts:             eo,
+		Region:                   region,
+		Prefix:                   opts.Prefix,
+		DisabledMetrics:          opts.DisabledMetrics,
+		CollectTime:              opts.CollectTime,
 		UUIDGenFunc:              uuidGenFunc,
-		DisableSlowMetrics:       disableSlowMetrics,
-		DisableDeprecatedMetrics: disableDeprecatedMetrics,
-		DisableCinderAgentUUID:   disableCinderAgentUUID,
-		DomainID:                 domainID,
-		TenantID:                 tenantID,
-		NovaMetadataMapping:      novaMetadataMapping,
-		DnsConcurrentCount:       dnsConcurrentCount,
+		DisableSlowMetrics:       opts.DisableSlowMetrics,
+		DisableDeprecatedMetrics: opts.DisableDeprecatedMetrics,
+		DisableCinderAgentUUID:   opts.DisableCinderAgentUUID,
+		DomainID:                 opts.DomainID,
+		TenantID:                 opts.TenantID,
+		NovaMetadataMapping:      opts.NovaMetadataMapping,
+		DnsConcurrentCount:       opts.DnsConcurrentCount,
+		ProjectConcurrentCount:   opts.ProjectConcurrentCount,
+		CollectTimeouts:          opts.CollectTimeouts,
+		CollectMetric:            opts.CollectMetric,
+		Clock:                    opts.Clock,
 	}
 
-	switch name {
-	case "network":
-		exporter, err = NewNeutronExporter(&exporterConfig, logger)
-	case "compute":
-		exporter, err = NewNovaExporter(&exporterConfig, logger)
-	case "image":
-		exporter, err = NewGlanceExporter(&exporterConfig, logger)
-	case "volume":
-		exporter, err = NewCinderExporter(&exporterConfig, logger)
-	case "identity":
-		exporter, err = NewKeystoneExporter(&exporterConfig, logger)
-	case "object-store":
-		exporter, err = NewObjectStoreExporter(&exporterConfig, logger)
-	case "load-balancer":
-		exporter, err = NewLoadbalancerExporter(&exporterConfig, logger)
-	case "container-infra":
-		exporter, err = NewContainerInfraExporter(&exporterConfig, logger)
-	case "dns":
-		exporter, err = NewDesignateExporter(&exporterConfig, logger)
-	case "baremetal":
-		exporter, err = NewIronicExporter(&exporterConfig, logger)
-	case "gnocchi":
-		exporter, err = NewGnocchiExporter(&exporterConfig, logger)
-	case "database":
-		exporter, err = NewTroveExporter(&exporterConfig, logger)
-	case "orchestration":
-		exporter, err = NewHeatExporter(&exporterConfig, logger)
-	case "placement":
-		exporter, err = NewPlacementExporter(&exporterConfig, logger)
-	case "sharev2":
-		exporter, err = NewManilaExporter(&exporterConfig, logger)
-	default:
+	service, ok := registeredService(name)
+	if !ok {
 		return nil, fmt.Errorf("couldn't find a handler for %s exporter", name)
 	}
-
-	if err != nil {
-		return nil, err
-	}
-
-	return exporter, nil
+	return service.NewExporter(&exporterConfig, logger)
 }
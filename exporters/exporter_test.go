@@ -6,12 +6,15 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
 
 	"log/slog"
 
 	"github.com/jarcoal/httpmock"
 	"github.com/openstack-exporter/openstack-exporter/utils"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -36,8 +39,16 @@ func (suite *BaseOpenStackTestSuite) SetResponseFromFixture(method string, statu
 		StatusCode: statusCode,
 	}
 
+	// The metrics of an exporter are collected concurrently and may request
+	// the same URL, while the call counter of Times isn't safe for concurrent
+	// use.
+	var mu sync.Mutex
 	responder := httpmock.ResponderFromResponse(response).Times(2)
-	httpmock.RegisterResponder(method, url, responder)
+	httpmock.RegisterResponder(method, url, func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return responder(req)
+	})
 }
 
 func (suite *BaseOpenStackTestSuite) MakeURL(resource string, port string) string {
@@ -172,11 +183,20 @@ func (suite *BaseOpenStackTestSuite) SetupTest() {
 	suite.installFixtures()
 
 	os.Setenv("OS_CLIENT_CONFIG_FILE", path.Join(baseFixturePath, "test_config.yaml"))
+	DefaultProviderClientPool.Reset()
 
 	novaMetadataMapping := new(utils.LabelMappingFlag)
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
-	exporter, err := NewExporter(suite.ServiceName, suite.Prefix, cloudName, []string{}, "public", false, false, false, false, "", "", novaMetadataMapping, 10, func() (string, error) {
-		return DEFAULT_UUID, nil
+	exporter, err := NewExporter(suite.ServiceName, cloudName, Options{
+		Prefix:                 suite.Prefix,
+		DisabledMetrics:        []string{},
+		EndpointType:           "public",
+		NovaMetadataMapping:    novaMetadataMapping,
+		DnsConcurrentCount:     10,
+		ProjectConcurrentCount: 10,
+		UUIDGenFunc: func() (string, error) {
+			return DEFAULT_UUID, nil
+		},
 	}, logger)
 
 	if err != nil {
@@ -213,6 +233,46 @@ func (suite *BaseOpenStackTestSuite) installFixtures() {
 	)
 }
 
+// collectorMetricsFilter hides the exporter_collector_* self-metrics, whose
+// durations and timestamps change on every collection.
+type collectorMetricsFilter struct {
+	prometheus.Collector
+}
+
+func withoutCollectorMetrics(c prometheus.Collector) prometheus.Collector {
+	return &collectorMetricsFilter{Collector: c}
+}
+
+func isCollectorMetric(desc *prometheus.Desc) bool {
+	return strings.Contains(desc.String(), "_exporter_collector_")
+}
+
+func (f *collectorMetricsFilter) Describe(ch chan<- *prometheus.Desc) {
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		f.Collector.Describe(descs)
+		close(descs)
+	}()
+	for desc := range descs {
+		if !isCollectorMetric(desc) {
+			ch <- desc
+		}
+	}
+}
+
+func (f *collectorMetricsFilter) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		f.Collector.Collect(metrics)
+		close(metrics)
+	}()
+	for metric := range metrics {
+		if !isCollectorMetric(metric.Desc()) {
+			ch <- metric
+		}
+	}
+}
+
 func (suite *BaseOpenStackTestSuite) TearDownTest() {
 	defer httpmock.DeactivateAndReset()
 }
@@ -0,0 +1,184 @@
+package exporters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ExporterFactory builds the exporter of a service for a cloud.
+type ExporterFactory func(service, cloud string) (OpenStackExporter, error)
+
+// authFailureReporter is implemented by exporters able to tell that their
+// client needs to be rebuilt because authentication failed.
+type authFailureReporter interface {
+	AuthFailed() bool
+}
+
+type instanceKey struct {
+	cloud   string
+	service string
+}
+
+type instanceEntry struct {
+	exporter OpenStackExporter
+	lastUsed time.Time
+	// generation is the one of the pooled ProviderClient of the cloud when
+	// the exporter was built.
+	generation uint64
+}
+
+// ExporterInstances keeps exporters built once per cloud and service, so
+// microversion discovery and metric setup don't run on every scrape.
+// Instances are rebuilt after an authentication failure of any exporter of
+// their cloud, once the pooled ProviderClient they were built on is dropped,
+// or after a Reset, and evicted when they haven't been used for idleTTL.
+type ExporterInstances struct {
+	factory ExporterFactory
+	idleTTL time.Duration
+	logger  *slog.Logger
+
+	mu      sync.Mutex
+	entries map[instanceKey]*instanceEntry
+}
+
+// NewExporterInstances returns an empty ExporterInstances using factory to
+// build missing exporters. An idleTTL of zero disables eviction.
+func NewExporterInstances(factory ExporterFactory, idleTTL time.Duration, logger *slog.Logger) *ExporterInstances {
+	return &ExporterInstances{
+		factory: factory,
+		idleTTL: idleTTL,
+		logger:  logger,
+		entries: make(map[instanceKey]*instanceEntry),
+	}
+}
+
+// Get returns the exporters of services for cloud, building the missing ones.
+// Services whose exporter can't be built are skipped and their errors joined.
+func (i *ExporterInstances) Get(cloud string, services []string) ([]OpenStackExporter, error) {
+	exporters := make([]OpenStackExporter, 0, len(services))
+	var errs []error
+
+	for _, service := range services {
+		exp, err := i.get(cloud, service)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", service, err))
+			continue
+		}
+		exporters = append(exporters, exp)
+	}
+
+	return exporters, errors.Join(errs...)
+}
+
+func (i *ExporterInstances) get(cloud, service string) (OpenStackExporter, error) {
+	key := instanceKey{cloud: cloud, service: service}
+
+	i.mu.Lock()
+	entry, ok := i.entries[key]
+	if ok {
+		if r, isReporter := entry.exporter.(authFailureReporter); isReporter && r.AuthFailed() {
+			// The other exporters of the cloud share the client and
+			// are rebuilt too, as its generation changes.
+			i.logger.Info("Rebuilding exporter after authentication failure", "cloud", cloud, "service", service)
+			delete(i.entries, key)
+			DefaultProviderClientPool.Invalidate(cloud)
+			ok = false
+		} else if entry.generation != DefaultProviderClientPool.generation(cloud) {
+			i.logger.Info("Rebuilding exporter on the new client of the cloud", "cloud", cloud, "service", service)
+			delete(i.entries, key)
+			ok = false
+		} else {
+			entry.lastUsed = time.Now()
+		}
+	}
+	i.mu.Unlock()
+
+	if ok {
+		return entry.exporter, nil
+	}
+
+	// Read before building, so an exporter built on a client dropped in the
+	// meantime is rebuilt on the next Get.
+	generation := DefaultProviderClientPool.generation(cloud)
+	exp, err := i.factory(service, cloud)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// Another request may have built the same exporter in the meantime.
+	if existing, ok := i.entries[key]; ok && existing.generation == generation {
+		existing.lastUsed = time.Now()
+		return existing.exporter, nil
+	}
+	i.entries[key] = &instanceEntry{exporter: exp, lastUsed: time.Now(), generation: generation}
+
+	return exp, nil
+}
+
+// Invalidate drops every exporter of cloud.
+func (i *ExporterInstances) Invalidate(cloud string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for key := range i.entries {
+		if key.cloud == cloud {
+			delete(i.entries, key)
+		}
+	}
+}
+
+// Reset drops every exporter, e.g. after a configuration change.
+func (i *ExporterInstances) Reset() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.entries = make(map[instanceKey]*instanceEntry)
+}
+
+// EvictIdle drops exporters not used since idleTTL and returns how many were evicted.
+func (i *ExporterInstances) EvictIdle() int {
+	if i.idleTTL <= 0 {
+		return 0
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	evicted := 0
+	for key, entry := range i.entries {
+		if time.Since(entry.lastUsed) > i.idleTTL {
+			delete(i.entries, key)
+			evicted++
+		}
+	}
+
+	return evicted
+}
+
+// Run evicts idle exporters periodically until ctx is done.
+func (i *ExporterInstances) Run(ctx context.Context) {
+	if i.idleTTL <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(i.idleTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if n := i.EvictIdle(); n > 0 {
+				i.logger.Info("Evicted idle exporters", "count", n)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package exporters
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeExporter struct {
+	service    string
+	authFailed bool
+}
+
+func (f *fakeExporter) Describe(ch chan<- *prometheus.Desc) {}
+
+func (f *fakeExporter) Collect(ch chan<- prometheus.Metric) {}
+
+func (f *fakeExporter) GetName() string {
+	return f.service
+}
+
+func (f *fakeExporter) AddMetric(name string, fn ListFunc, labels []string, deprecatedVersion string, constLabels prometheus.Labels) {
+}
+
+func (f *fakeExporter) MetricIsDisabled(name string) bool {
+	return false
+}
+
+func (f *fakeExporter) AuthFailed() bool {
+	return f.authFailed
+}
+
+func newCountingFactory(builds *int) ExporterFactory {
+	return func(service, cloud string) (OpenStackExporter, error) {
+		*builds++
+		return &fakeExporter{service: service}, nil
+	}
+}
+
+func TestExporterInstancesReuse(t *testing.T) {
+	builds := 0
+	instances := NewExporterInstances(newCountingFactory(&builds), 0, slog.New(slog.DiscardHandler))
+
+	first, err := instances.Get("cloud-a", []string{"compute", "network"})
+	require.NoError(t, err)
+	second, err := instances.Get("cloud-a", []string{"compute", "network"})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, builds)
+	assert.Same(t, first[0], second[0])
+
+	_, err = instances.Get("cloud-b", []string{"compute"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, builds)
+
+	instances.Invalidate("cloud-a")
+	_, err = instances.Get("cloud-a", []string{"compute"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, builds)
+
+	instances.Reset()
+	_, err = instances.Get("cloud-b", []string{"compute"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, builds)
+}
+
+func TestExporterInstancesRebuildAfterAuthFailure(t *testing.T) {
+	builds := 0
+	instances := NewExporterInstances(newCountingFactory(&builds), 0, slog.New(slog.DiscardHandler))
+
+	exps, err := instances.Get("cloud-a", []string{"compute", "network"})
+	require.NoError(t, err)
+	other, err := instances.Get("cloud-b", []string{"network"})
+	require.NoError(t, err)
+	exps[0].(*fakeExporter).authFailed = true
+
+	rebuilt, err := instances.Get("cloud-a", []string{"compute"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, builds)
+	assert.NotSame(t, exps[0], rebuilt[0])
+
+	// The network exporter was built on the client dropped after the
+	// failure of the compute one, which Run no longer renews.
+	rebuilt, err = instances.Get("cloud-a", []string{"network"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, builds)
+	assert.NotSame(t, exps[1], rebuilt[0])
+
+	kept, err := instances.Get("cloud-b", []string{"network"})
+	require.NoError(t, err)
+	assert.Equal(t, 5, builds, "the exporters of the other clouds are kept")
+	assert.Same(t, other[0], kept[0])
+}
+
+func TestExporterInstancesEvictIdle(t *testing.T) {
+	builds := 0
+	instances := NewExporterInstances(newCountingFactory(&builds), time.Millisecond, slog.New(slog.DiscardHandler))
+
+	_, err := instances.Get("cloud-a", []string{"compute"})
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, 1, instances.EvictIdle())
+
+	_, err = instances.Get("cloud-a", []string{"compute"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, builds)
+}
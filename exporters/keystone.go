@@ -156,21 +156,19 @@ func ListGroups(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<-
 	return nil
 }
 
-func newIdentityV3ClientV2FromExporter(exporter *BaseOpenStackExporter, fallbackServiceName string) (*gophercloud.ServiceClient, error) {
-	var eo gophercloud.EndpointOpts
-
-	// We need a list of all tenants/projects. Therefore, within this nova exporter we need
-	// to create an openstack client for the Identity/Keystone API.
-	// If possible, use the EndpointOpts spefic to the identity service.
-	if v, ok := endpointOptsV2["identity"]; ok {
-		eo = v
-	} else if v, ok := endpointOptsV2[fallbackServiceName]; ok {
-		eo = v
-	} else {
-		return nil, errors.New("no EndpointOpts available to create Identity client")
-	}
-
+// newIdentityV3ClientV2FromExporter returns a Keystone client sharing the
+// ProviderClient and the EndpointOpts of the exporter, which needs it to list
+// the projects.
+func newIdentityV3ClientV2FromExporter(exporter *BaseOpenStackExporter) (*gophercloud.ServiceClient, error) {
+	eo := exporter.EndpointOpts
 	cli, err := openstack.NewIdentityV3(exporter.ClientV2.ProviderClient, eo)
+	var notFound *gophercloud.ErrEndpointNotFound
+	if errors.As(err, &notFound) && eo.Region != "" {
+		// Keystone is usually shared by every region while only registered
+		// in some of them, take its endpoint from any region.
+		eo.Region = ""
+		cli, err = openstack.NewIdentityV3(exporter.ClientV2.ProviderClient, eo)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -13,6 +13,7 @@ import (
 
 	"go4.org/netipx"
 
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/projects"
 	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/agents"
 	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/external"
 	"github.com/gophercloud/gophercloud/v2/openstack/networking/v2/extensions/layer3/floatingips"
@@ -656,12 +657,7 @@ func collectNeutronQuotaDetail(ch chan<- prometheus.Metric, metric *prometheus.D
 }
 
 func ListNetworkQuotas(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
-	allProjects, err := GetProjects(ctx, exporter)
-	if err != nil {
-		return err
-	}
-
-	for _, p := range allProjects {
+	return ForEachProject(ctx, exporter, func(ctx context.Context, p projects.Project) error {
 		// quota are obtained from the neutron API, so now we can just use this exporter's client
 		quota, err := quotas.GetDetail(ctx, exporter.ClientV2, p.ID).Extract()
 		if err != nil {
@@ -678,7 +674,6 @@ func ListNetworkQuotas(ctx context.Context, exporter *BaseOpenStackExporter, ch
 		collectNeutronQuotaDetail(ch, exporter.Metrics["quota_security_group_rule"].Metric, quota.SecurityGroupRule, p.Name, p.ID)
 		collectNeutronQuotaDetail(ch, exporter.Metrics["quota_rbac_policy"].Metric, quota.RBACPolicy, p.Name, p.ID)
 
-	}
-
-	return nil
+		return nil
+	})
 }
@@ -19,6 +19,7 @@ import (
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/servers"
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/services"
 	"github.com/gophercloud/gophercloud/v2/openstack/compute/v2/usage"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/projects"
 	"github.com/openstack-exporter/openstack-exporter/utils"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -285,12 +286,7 @@ func collectNovaQuotaDetail(ch chan<- prometheus.Metric, metric *prometheus.Desc
 }
 
 func ListQuotas(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
-	allProjects, err := GetProjects(ctx, exporter)
-	if err != nil {
-		return err
-	}
-
-	for _, p := range allProjects {
+	return ForEachProject(ctx, exporter, func(ctx context.Context, p projects.Project) error {
 		quotaSet, err := quotasets.GetDetail(ctx, exporter.ClientV2, p.ID).Extract()
 		if err != nil {
 			return err
@@ -311,8 +307,8 @@ func ListQuotas(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<-
 		collectNovaQuotaDetail(ch, exporter.Metrics["quota_injected_file_path_bytes"].Metric, quotaSet.InjectedFilePathBytes, p.Name, p.ID)
 		collectNovaQuotaDetail(ch, exporter.Metrics["quota_injected_files"].Metric, quotaSet.InjectedFiles, p.Name, p.ID)
 
-	}
-	return nil
+		return nil
+	})
 }
 
 func ListAZs(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
@@ -420,12 +416,7 @@ func ListAllServers(ctx context.Context, exporter *BaseOpenStackExporter, ch cha
 }
 
 func ListComputeLimits(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
-	allProjects, err := GetProjects(ctx, exporter)
-	if err != nil {
-		return err
-	}
-
-	for _, p := range allProjects {
+	return ForEachProject(ctx, exporter, func(ctx context.Context, p projects.Project) error {
 		// Limits are obtained from the nova API, so now we can just use this exporter's client
 		limitGetOpts := limits.GetOpts{TenantID: p.ID}
 		if p.ID == exporter.TenantID {
@@ -454,9 +445,9 @@ func ListComputeLimits(ctx context.Context, exporter *BaseOpenStackExporter, ch
 
 		ch <- prometheus.MustNewConstMetric(exporter.Metrics["limits_instances_max"].Metric,
 			prometheus.GaugeValue, float64(limits.Absolute.MaxTotalInstances), p.Name, p.ID)
-	}
 
-	return nil
+		return nil
+	})
 }
 
 // ListUsage add metrics about usage
@@ -3,6 +3,7 @@ package exporters
 import (
 	"strings"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
@@ -506,6 +507,39 @@ openstack_nova_vcpus_used{aggregates="",availability_zone="",hostname="host1"} 0
 `
 
 func (suite *NovaTestSuite) TestNovaExporter() {
-	err := testutil.CollectAndCompare(*suite.Exporter, strings.NewReader(novaExpectedUp))
+	err := testutil.CollectAndCompare(withoutCollectorMetrics(*suite.Exporter), strings.NewReader(novaExpectedUp))
 	assert.NoError(suite.T(), err)
 }
+
+func (suite *NovaTestSuite) TestNovaQuotasSkipFailedProject() {
+	suite.SetResponseFromFixture("GET", 500,
+		suite.MakeURL("/compute/os-quota-sets/5961c443439d4fcebe42643723755e9d/detail", ""),
+		suite.FixturePath("nova_quotas_1_usage"),
+	)
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(*suite.Exporter)
+	mfs, err := registry.Gather()
+	suite.Require().NoError(err)
+
+	values := map[string]float64{}
+	quotaCores := 0
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "openstack_exporter_collector_failed_projects":
+			for _, m := range mf.GetMetric() {
+				for _, label := range m.GetLabel() {
+					if label.GetName() == "metric" {
+						values[label.GetValue()] = m.GetGauge().GetValue()
+					}
+				}
+			}
+		case "openstack_nova_quota_cores":
+			quotaCores = len(mf.GetMetric())
+		}
+	}
+
+	assert.Equal(suite.T(), map[string]float64{"limits_vcpus_max": 0, "quota_cores": 1}, values)
+	// Seven of the eight projects are still reported, with three values each.
+	assert.Equal(suite.T(), 7*3, quotaCores)
+}
@@ -0,0 +1,78 @@
+package exporters
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/projects"
+	"golang.org/x/sync/errgroup"
+)
+
+// projectCollectionStats records the outcome of the per-project calls made by
+// a collector through ForEachProject.
+type projectCollectionStats struct {
+	used   atomic.Bool
+	failed atomic.Int64
+}
+
+type projectCollectionStatsKey struct{}
+
+func withProjectCollectionStats(ctx context.Context, stats *projectCollectionStats) context.Context {
+	return context.WithValue(ctx, projectCollectionStatsKey{}, stats)
+}
+
+// ForEachProject calls fn for every project returned by GetProjects, with at
+// most ProjectConcurrentCount calls running at once. A project whose call
+// fails is logged and skipped, an error is only returned when every project
+// failed or ctx is done.
+func ForEachProject(ctx context.Context, exporter *BaseOpenStackExporter, fn func(ctx context.Context, project projects.Project) error) error {
+	allProjects, err := GetProjects(ctx, exporter)
+	if err != nil {
+		return err
+	}
+
+	var (
+		failed   atomic.Int64
+		mu       sync.Mutex
+		firstErr error
+	)
+
+	var g errgroup.Group
+	g.SetLimit(exporter.GetProjectConcurrencyCount())
+
+	for _, p := range allProjects {
+		g.Go(func() error {
+			if err := fn(ctx, p); err != nil {
+				exporter.logger.Warn("Failed to collect project, skipping it",
+					"exporter", exporter.Name, "project_id", p.ID, "project_name", p.Name, "err", err)
+				failed.Add(1)
+
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+
+	_ = g.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if stats, ok := ctx.Value(projectCollectionStatsKey{}).(*projectCollectionStats); ok {
+		stats.used.Store(true)
+		stats.failed.Store(failed.Load())
+	}
+
+	if len(allProjects) > 0 && failed.Load() == int64(len(allProjects)) {
+		return fmt.Errorf("failed to collect all %d projects: %w", len(allProjects), firstErr)
+	}
+
+	return nil
+}
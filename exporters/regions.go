@@ -0,0 +1,159 @@
+package exporters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	gophercloudv2 "github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// multiRegion reports whether the exporters are built per region, with a
+// region label on every metric.
+func (opts Options) multiRegion() bool {
+	return len(opts.Regions) > 0 || opts.DiscoverRegions
+}
+
+// regionsFor returns the regions to collect service from: the configured ones,
+// or the ones holding an endpoint of the service in the catalog.
+func (opts Options) regionsFor(pc *PooledProviderClient, service string) []string {
+	if len(opts.Regions) > 0 {
+		return opts.Regions
+	}
+
+	regions := catalogRegions(pc.Client, service, GetEndpointTypeV2(opts.EndpointType))
+	if len(regions) == 0 {
+		// Not a Keystone v3 catalog, only the region of the cloud is known.
+		return []string{opts.endpointOpts(pc).Region}
+	}
+	return regions
+}
+
+// catalogRegions returns the sorted regions in which the catalog of client
+// has an endpoint of service with the given availability.
+func catalogRegions(client *gophercloudv2.ProviderClient, service string, availability gophercloudv2.Availability) []string {
+	result, ok := client.GetAuthResult().(tokens.CreateResult)
+	if !ok {
+		return nil
+	}
+
+	catalog, err := result.ExtractServiceCatalog()
+	if err != nil {
+		return nil
+	}
+
+	var regions []string
+	for _, entry := range catalog.Entries {
+		if !slices.Contains(catalogTypes(service), entry.Type) {
+			continue
+		}
+		for _, endpoint := range entry.Endpoints {
+			if endpoint.Interface != string(availability) {
+				continue
+			}
+			region := endpoint.RegionID
+			if region == "" {
+				region = endpoint.Region
+			}
+			if region != "" && !slices.Contains(regions, region) {
+				regions = append(regions, region)
+			}
+		}
+	}
+
+	slices.Sort(regions)
+	return regions
+}
+
+// newRegionalExporter builds an exporter of service for every region of opts.
+// Regions sharing the endpoint of an earlier one, like a global Keystone, are
+// skipped so their metrics aren't collected twice, and so are configured
+// regions without the service.
+func newRegionalExporter(service string, pc *PooledProviderClient, opts Options, logger *slog.Logger) (OpenStackExporter, error) {
+	var exporters []OpenStackExporter
+	var endpoints []string
+
+	for _, region := range opts.regionsFor(pc, service) {
+		eo := opts.endpointOpts(pc)
+		eo.Region = region
+
+		clientV2, err := newServiceClientFromProvider(service, pc.Client, pc.Cloud, eo)
+		if err != nil {
+			var notFound *gophercloudv2.ErrEndpointNotFound
+			if errors.As(err, &notFound) {
+				logger.Warn("Service not available in region, skipping it", "service", service, "region", region)
+				continue
+			}
+			return nil, fmt.Errorf("region %s: %w", region, err)
+		}
+
+		if slices.Contains(endpoints, clientV2.Endpoint) {
+			logger.Debug("Service endpoint already collected from another region", "service", service, "region", region, "endpoint", clientV2.Endpoint)
+			continue
+		}
+		endpoints = append(endpoints, clientV2.Endpoint)
+		pc.instrumentation.RegisterServiceClient(clientV2)
+
+		exporter, err := newServiceExporter(service, clientV2, eo, region, opts, logger.With("region", region))
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", region, err)
+		}
+		exporters = append(exporters, exporter)
+	}
+
+	if len(exporters) == 0 {
+		return nil, fmt.Errorf("service %s is not available in any region", service)
+	}
+
+	return &regionalExporter{regions: exporters}, nil
+}
+
+// regionalExporter collects a service from several regions of a cloud, each
+// region having its own exporter whose metrics carry a region label.
+type regionalExporter struct {
+	regions []OpenStackExporter
+}
+
+func (r *regionalExporter) GetName() string {
+	return r.regions[0].GetName()
+}
+
+func (r *regionalExporter) AddMetric(name string, fn ListFunc, labels []string, deprecatedVersion string, constLabels prometheus.Labels) {
+	for _, exporter := range r.regions {
+		exporter.AddMetric(name, fn, labels, deprecatedVersion, constLabels)
+	}
+}
+
+func (r *regionalExporter) MetricIsDisabled(name string) bool {
+	return r.regions[0].MetricIsDisabled(name)
+}
+
+func (r *regionalExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, exporter := range r.regions {
+		exporter.Describe(ch)
+	}
+}
+
+func (r *regionalExporter) Collect(ch chan<- prometheus.Metric) {
+	r.CollectWithContext(context.Background(), ch)
+}
+
+// CollectWithContext collects every region concurrently.
+func (r *regionalExporter) CollectWithContext(ctx context.Context, ch chan<- prometheus.Metric) {
+	collectAll(ctx, r.regions, ch)
+}
+
+// AuthFailed reports whether the collection of any region hit an
+// authentication error.
+func (r *regionalExporter) AuthFailed() bool {
+	for _, exporter := range r.regions {
+		if reporter, ok := exporter.(authFailureReporter); ok && reporter.AuthFailed() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package exporters
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupRegionsCloud mocks a cloud whose catalog has a second region, with its
+// own image endpoint and the identity endpoint of the first region.
+func setupRegionsCloud(t *testing.T) {
+	data, err := os.ReadFile(path.Join(baseFixturePath, "tokens.json"))
+	require.NoError(t, err)
+
+	var token map[string]any
+	require.NoError(t, json.Unmarshal(data, &token))
+	for _, entry := range token["token"].(map[string]any)["catalog"].([]any) {
+		entry := entry.(map[string]any)
+		url := map[string]string{"image": "http://test.cloud/glance-two", "identity": "http://test.cloud/identity"}[entry["type"].(string)]
+		if url == "" {
+			continue
+		}
+		for _, iface := range []string{"public", "internal", "admin"} {
+			entry["endpoints"] = append(entry["endpoints"].([]any), map[string]any{
+				"id": "region-two-" + iface, "interface": iface, "region": "RegionTwo", "region_id": "RegionTwo", "url": url,
+			})
+		}
+	}
+	data, err = json.Marshal(token)
+	require.NoError(t, err)
+
+	httpmock.Activate()
+	t.Cleanup(httpmock.DeactivateAndReset)
+	httpmock.RegisterResponder("POST", "http://test.cloud:35357/v3/auth/tokens",
+		httpmock.NewBytesResponder(201, data).HeaderSet(map[string][]string{
+			"Content-Type":    {"application/json"},
+			"X-Subject-Token": {"1234"},
+		}))
+	for _, prefix := range []string{"/glance", "/glance-two"} {
+		for resource, fixture := range map[string]string{"/": "glance_api_discovery", "/v2/images": "glance_images"} {
+			data, err := os.ReadFile(path.Join(baseFixturePath, fixture+".json"))
+			require.NoError(t, err)
+			httpmock.RegisterResponder("GET", "http://test.cloud"+prefix+resource,
+				httpmock.NewBytesResponder(200, data).HeaderSet(map[string][]string{"Content-Type": {"application/json"}}))
+		}
+	}
+
+	t.Setenv("OS_CLIENT_CONFIG_FILE", path.Join(baseFixturePath, "test_config.yaml"))
+	DefaultProviderClientPool.Reset()
+	t.Cleanup(DefaultProviderClientPool.Reset)
+}
+
+func TestNewExporterDiscoversRegions(t *testing.T) {
+	setupRegionsCloud(t)
+
+	exporter, err := NewExporter("image", cloudName, Options{Prefix: "openstack", EndpointType: "public", DiscoverRegions: true}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	expected := `
+# HELP openstack_glance_images images
+# TYPE openstack_glance_images gauge
+openstack_glance_images{region="RegionOne"} 2
+openstack_glance_images{region="RegionTwo"} 2
+# HELP openstack_glance_up up
+# TYPE openstack_glance_up gauge
+openstack_glance_up{region="RegionOne"} 1
+openstack_glance_up{region="RegionTwo"} 1
+`
+	err = testutil.CollectAndCompare(withoutCollectorMetrics(exporter), strings.NewReader(expected), "openstack_glance_images", "openstack_glance_up")
+	assert.NoError(t, err)
+}
+
+func TestNewExporterSkipsSharedEndpoints(t *testing.T) {
+	setupRegionsCloud(t)
+
+	exporter, err := NewExporter("identity", cloudName, Options{Prefix: "openstack", EndpointType: "public", DiscoverRegions: true}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	// Both regions share the same Keystone, it is only collected once.
+	require.IsType(t, &regionalExporter{}, exporter)
+	assert.Len(t, exporter.(*regionalExporter).regions, 1)
+}
+
+func TestNewExporterConfiguredRegions(t *testing.T) {
+	setupRegionsCloud(t)
+
+	exporter, err := NewExporter("image", cloudName, Options{Prefix: "openstack", EndpointType: "public", Regions: []string{"RegionTwo", "RegionThree"}}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	assert.Len(t, exporter.(*regionalExporter).regions, 1)
+
+	_, err = NewExporter("image", cloudName, Options{Prefix: "openstack", EndpointType: "public", Regions: []string{"RegionThree"}}, slog.New(slog.DiscardHandler))
+	assert.ErrorContains(t, err, "not available in any region")
+}
+
+func TestSingleRegionHasNoRegionLabel(t *testing.T) {
+	setupRegionsCloud(t)
+
+	exporter, err := NewExporter("image", cloudName, Options{Prefix: "openstack", EndpointType: "public"}, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	expected := `
+# HELP openstack_glance_images images
+# TYPE openstack_glance_images gauge
+openstack_glance_images 2
+`
+	err = testutil.CollectAndCompare(withoutCollectorMetrics(exporter), strings.NewReader(expected), "openstack_glance_images")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,192 @@
+package exporters
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	gophercloudv2 "github.com/gophercloud/gophercloud/v2"
+	openstackv2 "github.com/gophercloud/gophercloud/v2/openstack"
+	gnocchiv2 "github.com/gophercloud/utils/v2/gnocchi"
+	clientconfigv2 "github.com/gophercloud/utils/v2/openstack/clientconfig"
+)
+
+// ClientFactory builds the service client of a service from an
+// authenticated ProviderClient, the configuration of its cloud in clouds.yaml
+// and the EndpointOpts locating its endpoint in the service catalog.
+type ClientFactory func(client *gophercloudv2.ProviderClient, cloud *clientconfigv2.Cloud, eo gophercloudv2.EndpointOpts) (*gophercloudv2.ServiceClient, error)
+
+// ExporterConstructor builds the exporter of a service on top of the service
+// client set in config.ClientV2.
+type ExporterConstructor func(config *ExporterConfig, logger *slog.Logger) (OpenStackExporter, error)
+
+// Service describes a service exporter added with Register.
+type Service struct {
+	// Name is the name of the service in the flags and the configuration,
+	// i.e. compute.
+	Name string
+	// ExporterName is the name of the exporter of the service, which
+	// prefixes its metrics and its metrics in the service-metric format,
+	// i.e. nova.
+	ExporterName string
+	// CatalogTypes are the types of the service in the service catalog,
+	// used to detect it and to find its regions.
+	CatalogTypes []string
+	NewClient    ClientFactory
+	NewExporter  ExporterConstructor
+	// Metrics are the names of the metrics of the service, used to validate
+	// the metrics in the service-metric format. Any metric is accepted when
+	// they are not given.
+	Metrics []string
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Service)
+)
+
+// SupportedExporters lists the names of the registered services, in the order
+// they were registered. It must not be modified.
+var SupportedExporters []string
+
+// Register adds a service exporter, which is then collected like the built-in
+// ones: it can be autodetected from the service catalog, enabled or disabled
+// with the --disable-service.<name> flags and configured in the configuration
+// file. It is meant to be called from the init function of the package
+// implementing the exporter, and panics when a field of service is missing
+// or its name is already registered.
+func Register(service Service) {
+	if service.Name == "" || service.ExporterName == "" || len(service.CatalogTypes) == 0 || service.NewClient == nil || service.NewExporter == nil {
+		panic(fmt.Sprintf("exporters: incomplete registration of service %q", service.Name))
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[service.Name]; ok {
+		panic(fmt.Sprintf("exporters: service %q registered twice", service.Name))
+	}
+	service.CatalogTypes = slices.Clone(service.CatalogTypes)
+	service.Metrics = slices.Clone(service.Metrics)
+	registry[service.Name] = service
+	SupportedExporters = append(SupportedExporters, service.Name)
+}
+
+// registeredService returns the Service registered as name.
+func registeredService(name string) (Service, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	service, ok := registry[name]
+	return service, ok
+}
+
+// registeredServices returns every registered Service.
+func registeredServices() []Service {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	registered := make([]Service, 0, len(SupportedExporters))
+	for _, name := range SupportedExporters {
+		registered = append(registered, registry[name])
+	}
+	return registered
+}
+
+// catalogTypes returns the service catalog types of the service name.
+func catalogTypes(name string) []string {
+	service, _ := registeredService(name)
+	return service.CatalogTypes
+}
+
+// IsExporterNameValid reports whether service is registered.
+func IsExporterNameValid(service string) bool {
+	_, ok := registeredService(service)
+	return ok
+}
+
+// metricNames returns the names of metrics.
+func metricNames(metrics []Metric) []string {
+	names := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		names = append(names, metric.Name)
+	}
+	return names
+}
+
+// exporterConstructor adapts the constructor of a service exporter to an
+// ExporterConstructor.
+func exporterConstructor[E OpenStackExporter](newExporter func(*ExporterConfig, *slog.Logger) (E, error)) ExporterConstructor {
+	return func(config *ExporterConfig, logger *slog.Logger) (OpenStackExporter, error) {
+		exporter, err := newExporter(config, logger)
+		if err != nil {
+			return nil, err
+		}
+		return exporter, nil
+	}
+}
+
+// endpointClient adapts a gophercloud service client constructor, which
+// doesn't depend on the configuration of the cloud, to a ClientFactory.
+func endpointClient(newClient func(*gophercloudv2.ProviderClient, gophercloudv2.EndpointOpts) (*gophercloudv2.ServiceClient, error)) ClientFactory {
+	return func(client *gophercloudv2.ProviderClient, _ *clientconfigv2.Cloud, eo gophercloudv2.EndpointOpts) (*gophercloudv2.ServiceClient, error) {
+		return newClient(client, eo)
+	}
+}
+
+func newIdentityClient(client *gophercloudv2.ProviderClient, cloud *clientconfigv2.Cloud, eo gophercloudv2.EndpointOpts) (*gophercloudv2.ServiceClient, error) {
+	identityVersion := "3"
+	if v := cloud.IdentityAPIVersion; v != "" {
+		identityVersion = v
+	}
+
+	switch identityVersion {
+	case "v2", "2", "2.0":
+		return openstackv2.NewIdentityV2(client, eo)
+	case "v3", "3":
+		return openstackv2.NewIdentityV3(client, eo)
+	default:
+		return nil, fmt.Errorf("invalid identity API version")
+	}
+}
+
+func newVolumeClient(client *gophercloudv2.ProviderClient, cloud *clientconfigv2.Cloud, eo gophercloudv2.EndpointOpts) (*gophercloudv2.ServiceClient, error) {
+	volumeVersion := "3"
+	if v := cloud.VolumeAPIVersion; v != "" {
+		volumeVersion = v
+	}
+
+	switch volumeVersion {
+	case "v1", "1":
+		return openstackv2.NewBlockStorageV1(client, eo)
+	case "v2", "2":
+		return openstackv2.NewBlockStorageV2(client, eo)
+	case "v3", "3":
+		return openstackv2.NewBlockStorageV3(client, eo)
+	default:
+		return nil, fmt.Errorf("invalid volume API version")
+	}
+}
+
+func init() {
+	for _, service := range []Service{
+		{"network", "neutron", []string{"network"}, endpointClient(openstackv2.NewNetworkV2), exporterConstructor(NewNeutronExporter), metricNames(defaultNeutronMetrics)},
+		{"compute", "nova", []string{"compute"}, endpointClient(openstackv2.NewComputeV2), exporterConstructor(NewNovaExporter), metricNames(defaultNovaMetrics)},
+		{"image", "glance", []string{"image"}, endpointClient(openstackv2.NewImageV2), exporterConstructor(NewGlanceExporter), metricNames(defaultGlanceMetrics)},
+		{"volume", "cinder", []string{"block-storage", "volume", "volumev2", "volumev3"}, newVolumeClient, exporterConstructor(NewCinderExporter), metricNames(defaultCinderMetrics)},
+		{"identity", "identity", []string{"identity"}, newIdentityClient, exporterConstructor(NewKeystoneExporter), metricNames(defaultKeystoneMetrics)},
+		{"object-store", "object_store", []string{"object-store"}, endpointClient(openstackv2.NewObjectStorageV1), exporterConstructor(NewObjectStoreExporter), metricNames(defaultObjectStoreMetrics)},
+		{"load-balancer", "loadbalancer", []string{"load-balancer"}, endpointClient(openstackv2.NewLoadBalancerV2), exporterConstructor(NewLoadbalancerExporter), metricNames(defaultLoadbalancerMetrics)},
+		{"container-infra", "container_infra", []string{"container-infrastructure-management", "container-infra"}, endpointClient(openstackv2.NewContainerInfraV1), exporterConstructor(NewContainerInfraExporter), metricNames(defaultContainerInfraMetrics)},
+		{"dns", "designate", []string{"dns"}, endpointClient(openstackv2.NewDNSV2), exporterConstructor(NewDesignateExporter), metricNames(defaultDesignateMetrics)},
+		{"baremetal", "ironic", []string{"baremetal"}, endpointClient(openstackv2.NewBareMetalV1), exporterConstructor(NewIronicExporter), metricNames(defaultIronicMetrics)},
+		{"gnocchi", "gnocchi", []string{"metric", "gnocchi"}, endpointClient(gnocchiv2.NewGnocchiV1), exporterConstructor(NewGnocchiExporter), metricNames(defaultGnocchiMetrics)},
+		{"database", "trove", []string{"database"}, endpointClient(openstackv2.NewDBV1), exporterConstructor(NewTroveExporter), metricNames(defaultTroveMetrics)},
+		{"orchestration", "heat", []string{"orchestration"}, endpointClient(openstackv2.NewOrchestrationV1), exporterConstructor(NewHeatExporter), metricNames(defaultHeatMetrics)},
+		{"placement", "placement", []string{"placement"}, endpointClient(openstackv2.NewPlacementV1), exporterConstructor(NewPlacementExporter), metricNames(defaultPlacementMetrics)},
+		{"sharev2", "sharev2", []string{"shared-file-system", "sharev2"}, endpointClient(openstackv2.NewSharedFileSystemV2), exporterConstructor(NewManilaExporter), metricNames(defaultManilaMetrics)},
+	} {
+		Register(service)
+	}
+}
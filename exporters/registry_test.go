@@ -0,0 +1,81 @@
+package exporters
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/utils/v2/openstack/clientconfig"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// registerQueueService registers a service for the tests, unregistered when
+// the test ends.
+func registerQueueService(t *testing.T) {
+	Register(Service{
+		Name:         "queue",
+		ExporterName: "zaqar",
+		CatalogTypes: []string{"messaging"},
+		NewClient: func(client *gophercloud.ProviderClient, _ *clientconfig.Cloud, eo gophercloud.EndpointOpts) (*gophercloud.ServiceClient, error) {
+			return &gophercloud.ServiceClient{ProviderClient: client, Endpoint: "http://test.cloud/zaqar/", Type: "messaging"}, nil
+		},
+		NewExporter: func(config *ExporterConfig, logger *slog.Logger) (OpenStackExporter, error) {
+			exporter := NewBaseOpenStackExporter("zaqar", config, logger)
+			exporter.AddMetric("queues", func(ctx context.Context, exporter *BaseOpenStackExporter, ch chan<- prometheus.Metric) error {
+				ch <- prometheus.MustNewConstMetric(exporter.Metrics["queues"].Metric, prometheus.GaugeValue, 3)
+				return nil
+			}, nil, "", nil)
+			return exporter, nil
+		},
+	})
+
+	t.Cleanup(func() {
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		delete(registry, "queue")
+		SupportedExporters = slices.DeleteFunc(SupportedExporters, func(service string) bool { return service == "queue" })
+	})
+}
+
+func TestRegister(t *testing.T) {
+	registerQueueService(t)
+
+	assert.True(t, IsExporterNameValid("queue"))
+	assert.Equal(t, "queue", SupportedExporters[len(SupportedExporters)-1])
+	assert.Equal(t, "zaqar", ExporterName("queue"))
+	service, ok := MetricService("zaqar-queues")
+	assert.True(t, ok)
+	assert.Equal(t, "queue", service)
+	assert.Equal(t, []string{"messaging"}, catalogTypes("queue"))
+
+	collector, err := New(context.Background(), Options{
+		ProviderClient: &gophercloud.ProviderClient{},
+		Services:       []string{"queue"},
+		Prefix:         "openstack",
+	})
+	require.NoError(t, err)
+	expected := `
+# HELP openstack_zaqar_queues queues
+# TYPE openstack_zaqar_queues gauge
+openstack_zaqar_queues 3
+# HELP openstack_zaqar_up up
+# TYPE openstack_zaqar_up gauge
+openstack_zaqar_up 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected), "openstack_zaqar_queues", "openstack_zaqar_up"))
+}
+
+func TestRegisterPanics(t *testing.T) {
+	registerQueueService(t)
+
+	assert.PanicsWithValue(t, `exporters: service "queue" registered twice`, func() { registerQueueService(t) })
+	assert.PanicsWithValue(t, `exporters: incomplete registration of service "topic"`, func() {
+		Register(Service{Name: "topic", ExporterName: "zaqar_topic", CatalogTypes: []string{"messaging"}})
+	})
+}
@@ -0,0 +1,229 @@
+package exporters
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxDrainedBodySize bounds how much of a retried response body is read so the
+// connection can be reused.
+const maxDrainedBodySize = 64 << 10
+
+// RetryPolicy configures the retries of the requests sent to the OpenStack
+// APIs. Only idempotent requests are retried.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt, 0 disables
+	// retries.
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry, doubled for each
+	// following one.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between two attempts, including the one asked
+	// by a Retry-After header.
+	MaxBackoff time.Duration
+	// StatusCodes are the response codes that are retried.
+	StatusCodes []int
+}
+
+// DefaultRetryStatusCodes are the transient response codes retried by default.
+var DefaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RateLimits configures the token buckets limiting the requests sent to the
+// OpenStack APIs. A zero rate disables the matching limiter.
+type RateLimits struct {
+	// Cloud is the number of requests per second allowed for a whole cloud.
+	Cloud float64
+	// Service is the number of requests per second allowed for each service
+	// endpoint of a cloud.
+	Service float64
+	// Burst is the bucket size of both limiters, at least 1.
+	Burst int
+}
+
+// TransportOptions configures the http.RoundTripper chain built for every
+// cloud of a ProviderClientPool.
+type TransportOptions struct {
+	Retry     RetryPolicy
+	RateLimit RateLimits
+	// Debug logs every request and response, as gophercloud does with
+	// OS_DEBUG.
+	Debug bool
+}
+
+// retryRoundTripper retries idempotent requests failing with a transient
+// error, with an exponential backoff honouring Retry-After.
+type retryRoundTripper struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func newRetryRoundTripper(next http.RoundTripper, policy RetryPolicy) http.RoundTripper {
+	if policy.MaxRetries <= 0 {
+		return next
+	}
+	return &retryRoundTripper{next: next, policy: policy}
+}
+
+func (t *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.policy.MaxRetries || !t.shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt, resp)
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) < wait {
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.CopyN(io.Discard, resp.Body, maxDrainedBodySize)
+			resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (t *retryRoundTripper) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// Errors caused by the request context are final.
+		return req.Context().Err() == nil
+	}
+	return slices.Contains(t.policy.StatusCodes, resp.StatusCode)
+}
+
+// backoff returns the wait before the retry following attempt: the
+// Retry-After of resp when set, an exponential backoff with jitter otherwise.
+func (t *retryRoundTripper) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return min(wait, t.policy.MaxBackoff)
+		}
+	}
+
+	wait := t.policy.InitialBackoff << attempt
+	if wait <= 0 || wait > t.policy.MaxBackoff {
+		wait = t.policy.MaxBackoff
+	}
+	// Spread the retries of concurrent collectors over the second half of the backoff.
+	return wait/2 + rand.N(wait/2+1)
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as an
+// HTTP date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return req.Body == nil || req.Body == http.NoBody
+	}
+	return false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// rateLimitedRoundTripper delays the requests of a cloud so they stay within
+// the cloud and per service endpoint rate limits.
+type rateLimitedRoundTripper struct {
+	next        http.RoundTripper
+	limits      RateLimits
+	cloud       *rate.Limiter
+	serviceType func(*url.URL) string
+
+	mu       sync.Mutex
+	services map[string]*rate.Limiter
+}
+
+func newRateLimitedRoundTripper(next http.RoundTripper, limits RateLimits, serviceType func(*url.URL) string) http.RoundTripper {
+	if limits.Cloud <= 0 && limits.Service <= 0 {
+		return next
+	}
+
+	limits.Burst = max(limits.Burst, 1)
+	t := &rateLimitedRoundTripper{
+		next:        next,
+		limits:      limits,
+		serviceType: serviceType,
+		services:    make(map[string]*rate.Limiter),
+	}
+	if limits.Cloud > 0 {
+		t.cloud = rate.NewLimiter(rate.Limit(limits.Cloud), limits.Burst)
+	}
+	return t
+}
+
+func (t *rateLimitedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if limiter := t.serviceLimiter(req.URL); limiter != nil {
+		if err := limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	if t.cloud != nil {
+		if err := t.cloud.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+func (t *rateLimitedRoundTripper) serviceLimiter(u *url.URL) *rate.Limiter {
+	if t.limits.Service <= 0 {
+		return nil
+	}
+
+	service := t.serviceType(u)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limiter, ok := t.services[service]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(t.limits.Service), t.limits.Burst)
+		t.services[service] = limiter
+	}
+	return limiter
+}
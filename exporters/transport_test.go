@@ -0,0 +1,142 @@
+package exporters
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFlakyServer(t *testing.T, failures int32, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func testRetryPolicy(maxRetries int) RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:     maxRetries,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+		StatusCodes:    DefaultRetryStatusCodes,
+	}
+}
+
+func TestRetryRoundTripperRetriesTransientErrors(t *testing.T) {
+	server, calls := newFlakyServer(t, 2, "")
+	client := &http.Client{Transport: newRetryRoundTripper(http.DefaultTransport, testRetryPolicy(3))}
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestRetryRoundTripperGivesUp(t *testing.T) {
+	server, calls := newFlakyServer(t, 5, "")
+	client := &http.Client{Transport: newRetryRoundTripper(http.DefaultTransport, testRetryPolicy(2))}
+
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestRetryRoundTripperSkipsNonIdempotentRequests(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, "")
+	client := &http.Client{Transport: newRetryRoundTripper(http.DefaultTransport, testRetryPolicy(3))}
+
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRetryRoundTripperHonoursRetryAfter(t *testing.T) {
+	server, calls := newFlakyServer(t, 1, "1")
+	policy := testRetryPolicy(1)
+	policy.MaxBackoff = 50 * time.Millisecond
+	client := &http.Client{Transport: newRetryRoundTripper(http.DefaultTransport, policy)}
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), calls.Load())
+	// Retry-After asks for a second, capped by MaxBackoff.
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	wait, ok := retryAfter("3", now)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, wait)
+
+	wait, ok = retryAfter(now.Add(5*time.Second).Format(http.TimeFormat), now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, wait)
+
+	_, ok = retryAfter("", now)
+	assert.False(t, ok)
+	_, ok = retryAfter("soon", now)
+	assert.False(t, ok)
+}
+
+func TestRateLimitedRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	serviceType := func(u *url.URL) string { return strings.Split(strings.TrimPrefix(u.Path, "/"), "/")[0] }
+	limits := RateLimits{Service: 20, Burst: 1}
+	client := &http.Client{Transport: newRateLimitedRoundTripper(http.DefaultTransport, limits, serviceType)}
+
+	get := func(path string) {
+		resp, err := client.Get(server.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Each service endpoint has its own bucket.
+	start := time.Now()
+	get("/compute/servers")
+	get("/network/ports")
+	assert.Less(t, time.Since(start), 40*time.Millisecond)
+
+	// 20 requests per second leave 50ms between two compute requests.
+	start = time.Now()
+	get("/compute/servers")
+	get("/compute/servers")
+	assert.GreaterOrEqual(t, time.Since(start), 80*time.Millisecond)
+}
+
+func TestRateLimitedRoundTripperDisabled(t *testing.T) {
+	assert.Same(t, http.DefaultTransport, newRateLimitedRoundTripper(http.DefaultTransport, RateLimits{}, nil))
+	assert.Same(t, http.DefaultTransport, newRetryRoundTripper(http.DefaultTransport, RetryPolicy{}))
+}
@@ -16,29 +16,14 @@ import (
 	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/projects"
 	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
 	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/users"
-	gnocchiv2 "github.com/gophercloud/utils/v2/gnocchi"
 	clientconfigv2 "github.com/gophercloud/utils/v2/openstack/clientconfig"
 )
 
-var serviceCatalogTypesByExporterService = map[string][]string{
-	"network":         {"network"},
-	"compute":         {"compute"},
-	"image":           {"image"},
-	"volume":          {"block-storage", "volume", "volumev2", "volumev3"},
-	"identity":        {"identity"},
-	"object-store":    {"object-store"},
-	"load-balancer":   {"load-balancer"},
-	"container-infra": {"container-infrastructure-management", "container-infra"},
-	"dns":             {"dns"},
-	"baremetal":       {"baremetal"},
-	"gnocchi":         {"metric", "gnocchi"},
-	"database":        {"database"},
-	"orchestration":   {"orchestration"},
-	"placement":       {"placement"},
-	"sharev2":         {"shared-file-system", "sharev2"},
+func AuthenticatedClientV2(opts *clientconfigv2.ClientOpts, transport http.RoundTripper) (*gophercloudv2.ProviderClient, error) {
+	return authenticatedClient(context.TODO(), opts, transport)
 }
 
-func AuthenticatedClientV2(opts *clientconfigv2.ClientOpts, transport http.RoundTripper) (*gophercloudv2.ProviderClient, error) {
+func authenticatedClient(ctx context.Context, opts *clientconfigv2.ClientOpts, transport http.RoundTripper) (*gophercloudv2.ProviderClient, error) {
 	options, err := clientconfigv2.AuthOptions(opts)
 	if err != nil {
 		return nil, err
@@ -60,14 +45,14 @@ func AuthenticatedClientV2(opts *clientconfigv2.ClientOpts, transport http.Round
 		client.HTTPClient.Transport = transport
 	}
 
-	err = openstackv2.Authenticate(context.TODO(), client, *options)
+	err = openstackv2.Authenticate(ctx, client, *options)
 	if err != nil {
 		return nil, err
 	}
 	return client, nil
 }
 
-func newAuthenticatedProviderClient(opts *clientconfigv2.ClientOpts, transport http.RoundTripper, endpointType string) (*gophercloudv2.ProviderClient, *clientconfigv2.Cloud, gophercloudv2.EndpointOpts, error) {
+func newAuthenticatedProviderClient(ctx context.Context, opts *clientconfigv2.ClientOpts, transport http.RoundTripper) (*gophercloudv2.ProviderClient, *clientconfigv2.Cloud, string, error) {
 	cloud := new(clientconfigv2.Cloud)
 
 	if opts == nil {
@@ -92,13 +77,13 @@ func newAuthenticatedProviderClient(opts *clientconfigv2.ClientOpts, transport h
 		var err error
 		cloud, err = clientconfigv2.GetCloudFromYAML(opts)
 		if err != nil {
-			return nil, nil, gophercloudv2.EndpointOpts{}, err
+			return nil, nil, "", err
 		}
 	}
 
-	pClient, err := AuthenticatedClientV2(opts, transport)
+	pClient, err := authenticatedClient(ctx, opts, transport)
 	if err != nil {
-		return nil, nil, gophercloudv2.EndpointOpts{}, err
+		return nil, nil, "", err
 	}
 
 	var region string
@@ -116,96 +101,36 @@ func newAuthenticatedProviderClient(opts *clientconfigv2.ClientOpts, transport h
 		}
 	}
 
-	eo := gophercloudv2.EndpointOpts{
-		Region:       region,
-		Availability: GetEndpointTypeV2(endpointType),
-	}
-
-	return pClient, cloud, eo, nil
+	return pClient, cloud, region, nil
 }
 
 func NewServiceClientV2(service string, opts *clientconfigv2.ClientOpts, transport http.RoundTripper, endpointType string) (*gophercloudv2.ServiceClient, error) {
-	pClient, cloud, eo, err := newAuthenticatedProviderClient(opts, transport, endpointType)
+	pClient, cloud, region, err := newAuthenticatedProviderClient(context.TODO(), opts, transport)
 	if err != nil {
 		return nil, err
 	}
 
-	// Keep a map of the EndpointOpts for each service
-	endpointOptsV2Mu.Lock()
-	if endpointOptsV2 == nil {
-		endpointOptsV2 = make(map[string]gophercloudv2.EndpointOpts)
+	eo := gophercloudv2.EndpointOpts{
+		Region:       region,
+		Availability: GetEndpointTypeV2(endpointType),
 	}
-	endpointOptsV2[service] = eo
-	endpointOptsV2Mu.Unlock()
-
-	switch service {
-	case "baremetal":
-		return openstackv2.NewBareMetalV1(pClient, eo)
-	case "compute":
-		return openstackv2.NewComputeV2(pClient, eo)
-	// NOTE: Intentionally disabled here: openstack-exporter has no "container" exporter.
-	// case "container":
-	// 	return openstackv2.NewContainerV1(pClient, eo)
-	case "container-infra":
-		return openstackv2.NewContainerInfraV1(pClient, eo)
-	case "database":
-		return openstackv2.NewDBV1(pClient, eo)
-	case "dns":
-		return openstackv2.NewDNSV2(pClient, eo)
-	case "gnocchi":
-		return gnocchiv2.NewGnocchiV1(pClient, eo)
-	case "identity":
-		identityVersion := "3"
-		if v := cloud.IdentityAPIVersion; v != "" {
-			identityVersion = v
-		}
 
-		switch identityVersion {
-		case "v2", "2", "2.0":
-			return openstackv2.NewIdentityV2(pClient, eo)
-		case "v3", "3":
-			return openstackv2.NewIdentityV3(pClient, eo)
-		default:
-			return nil, fmt.Errorf("invalid identity API version")
-		}
-	case "image":
-		return openstackv2.NewImageV2(pClient, eo)
-	case "load-balancer":
-		return openstackv2.NewLoadBalancerV2(pClient, eo)
-	case "network":
-		return openstackv2.NewNetworkV2(pClient, eo)
-	case "object-store":
-		return openstackv2.NewObjectStorageV1(pClient, eo)
-	case "orchestration":
-		return openstackv2.NewOrchestrationV1(pClient, eo)
-	case "placement":
-		return openstackv2.NewPlacementV1(pClient, eo)
-	case "sharev2":
-		return openstackv2.NewSharedFileSystemV2(pClient, eo)
-	case "volume":
-		volumeVersion := "3"
-		if v := cloud.VolumeAPIVersion; v != "" {
-			volumeVersion = v
-		}
+	return newServiceClientFromProvider(service, pClient, cloud, eo)
+}
 
-		switch volumeVersion {
-		case "v1", "1":
-			return openstackv2.NewBlockStorageV1(pClient, eo)
-		case "v2", "2":
-			return openstackv2.NewBlockStorageV2(pClient, eo)
-		case "v3", "3":
-			return openstackv2.NewBlockStorageV3(pClient, eo)
-		default:
-			return nil, fmt.Errorf("invalid volume API version")
-		}
+// newServiceClientFromProvider creates the service client of an exporter from an
+// already authenticated ProviderClient.
+func newServiceClientFromProvider(service string, pClient *gophercloudv2.ProviderClient, cloud *clientconfigv2.Cloud, eo gophercloudv2.EndpointOpts) (*gophercloudv2.ServiceClient, error) {
+	registered, ok := registeredService(service)
+	if !ok {
+		return nil, fmt.Errorf("unable to create a service client for %s", service)
 	}
-
-	return nil, fmt.Errorf("unable to create a service client for %s", service)
+	return registered.NewClient(pClient, cloud, eo)
 }
 
 // GetProjects returns all projects for the configured domain or just the configured project.
 func GetProjects(ctx context.Context, exporter *BaseOpenStackExporter) ([]projects.Project, error) {
-	c, err := newIdentityV3ClientV2FromExporter(exporter, exporter.ServiceName)
+	c, err := newIdentityV3ClientV2FromExporter(exporter)
 	if err != nil {
 		return nil, err
 	}
@@ -285,12 +210,39 @@ func mapStatus(mapping map[string]int, current string) int {
 	return v
 }
 
-func AutodetectServicesFromCatalog(opts *clientconfigv2.ClientOpts, transport http.RoundTripper, endpointType string) ([]string, error) {
-	providerClient, _, endpointOpts, err := newAuthenticatedProviderClient(opts, transport, endpointType)
-	if err != nil {
-		return nil, err
+// AutodetectServicesFromCatalog returns the exporters whose services are
+// present in the cloud's service catalog. Without a custom transport the
+// ProviderClient is taken from DefaultProviderClientPool, so the token is
+// reused by the exporters afterwards.
+func AutodetectServicesFromCatalog(opts *clientconfigv2.ClientOpts, transport http.RoundTripper, endpointType string, logger *slog.Logger) ([]string, error) {
+	var providerClient *gophercloudv2.ProviderClient
+	var endpointOpts gophercloudv2.EndpointOpts
+
+	if transport == nil && opts != nil && opts.Cloud != "" {
+		pc, err := DefaultProviderClientPool.Get(context.TODO(), opts.Cloud, logger)
+		if err != nil {
+			return nil, err
+		}
+		providerClient = pc.Client
+		endpointOpts = pc.EndpointOpts(endpointType)
+	} else {
+		pClient, _, region, err := newAuthenticatedProviderClient(context.TODO(), opts, transport)
+		if err != nil {
+			return nil, err
+		}
+		providerClient = pClient
+		endpointOpts = gophercloudv2.EndpointOpts{
+			Region:       region,
+			Availability: GetEndpointTypeV2(endpointType),
+		}
 	}
 
+	return availableServices(providerClient, endpointOpts)
+}
+
+// availableServices returns the exporters whose services are present in the
+// service catalog of providerClient.
+func availableServices(providerClient *gophercloudv2.ProviderClient, endpointOpts gophercloudv2.EndpointOpts) ([]string, error) {
 	enabledServices := make([]string, 0, len(SupportedExporters))
 	for _, service := range SupportedExporters {
 		if !isServiceAvailable(providerClient, endpointOpts, service) {
@@ -307,12 +259,7 @@ func AutodetectServicesFromCatalog(opts *clientconfigv2.ClientOpts, transport ht
 }
 
 func isServiceAvailable(providerClient *gophercloudv2.ProviderClient, endpointOpts gophercloudv2.EndpointOpts, service string) bool {
-	serviceTypes, ok := serviceCatalogTypesByExporterService[service]
-	if !ok {
-		return false
-	}
-
-	for _, serviceType := range serviceTypes {
+	for _, serviceType := range catalogTypes(service) {
 		eo := endpointOpts
 		eo.ApplyDefaults(serviceType)
 		endpoint, err := providerClient.EndpointLocator(eo)
@@ -323,8 +270,3 @@ func isServiceAvailable(providerClient *gophercloudv2.ProviderClient, endpointOp
 
 	return false
 }
-
-func IsExporterNameValid(service string) bool {
-	_, ok := serviceCatalogTypesByExporterService[service]
-	return ok
-}
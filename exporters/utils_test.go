@@ -70,13 +70,13 @@ kf2kRqwmo4NpwI1Zb5eaQa6ca6qBaAQ35l+bpes7VEQX
 }
 
 func TestServiceTypeMappings(t *testing.T) {
-	if len(serviceCatalogTypesByExporterService["compute"]) == 0 {
+	if len(catalogTypes("compute")) == 0 {
 		t.Error("expected compute service type mapping")
 	}
-	if len(serviceCatalogTypesByExporterService["volume"]) == 0 {
+	if len(catalogTypes("volume")) == 0 {
 		t.Error("expected volume service type mapping")
 	}
-	if len(serviceCatalogTypesByExporterService["gnocchi"]) == 0 {
+	if len(catalogTypes("gnocchi")) == 0 {
 		t.Error("expected gnocchi service type mapping")
 	}
 }
@@ -75,17 +75,7 @@ func newEmptyNovaMetadataMapping() *utils.LabelMappingFlag {
 func startOpenStackExporter(enabledServices []string) (string, func(), error) {
 	metricsPath := "/metrics"
 	listenAddress := ":9180"
-	prefix := "openstack"
-	endpointType := "public"
-	collectTime := false
-	disabledMetrics := []string{}
-	disableSlowMetrics := false
-	disableDeprecatedMetrics := false
-	disableCinderAgentUUID := false
 	cloud := "devstack-system-admin" // Must exist in CI clouds.yaml
-	domainID := ""
-	tenantID := ""
-	dnsConcurrentCount := 10
 
 	// Logger similar to main.go
 	promlogConfig := &promslog.Config{}
@@ -105,19 +95,15 @@ func startOpenStackExporter(enabledServices []string) (string, func(), error) {
 	for _, service := range enabledServices {
 		exp, err := exporters.EnableExporter(
 			service,
-			prefix,
 			cloud,
-			disabledMetrics,
-			endpointType,
-			collectTime,
-			disableSlowMetrics,
-			disableDeprecatedMetrics,
-			disableCinderAgentUUID,
-			domainID,
-			tenantID,
-			novaMetadataMapping, // non-nil here
-			dnsConcurrentCount,
-			nil,
+			exporters.Options{
+				Prefix:                 "openstack",
+				DisabledMetrics:        []string{},
+				EndpointType:           "public",
+				NovaMetadataMapping:    novaMetadataMapping, // non-nil here
+				DnsConcurrentCount:     10,
+				ProjectConcurrentCount: 10,
+			},
 			logger,
 		)
 		if err != nil {
@@ -2,29 +2,33 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	kingpin "github.com/alecthomas/kingpin/v2"
 	clientconfigv2 "github.com/gophercloud/utils/v2/openstack/clientconfig"
-	"gopkg.in/yaml.v3"
 
-	"github.com/hashicorp/vault-client-go"
-	"github.com/hashicorp/vault-client-go/schema"
 	"github.com/openstack-exporter/openstack-exporter/cache"
+	"github.com/openstack-exporter/openstack-exporter/config"
+	"github.com/openstack-exporter/openstack-exporter/credentials"
 	"github.com/openstack-exporter/openstack-exporter/exporters"
 	"github.com/openstack-exporter/openstack-exporter/utils"
 	"github.com/prometheus/client_golang/prometheus"
-	pver "github.com/prometheus/client_golang/prometheus/collectors/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/promslog"
 	"github.com/prometheus/common/promslog/flag"
 	"github.com/prometheus/common/version"
@@ -34,6 +38,16 @@ import (
 
 const DEFAULT_OS_CLIENT_CONFIG = "/etc/openstack/clouds.yaml"
 
+// collectTimeouts holds the parsed --collect-timeout* flags.
+var collectTimeouts exporters.CollectTimeouts
+
+// configStore holds the --config.file configuration, overriding the flags.
+var configStore *config.Store
+
+// scrapeCoalescer shares live collections between concurrent scrapes, nil
+// when --no-scrape-coalesce is set.
+var scrapeCoalescer *exporters.ScrapeCoalescer
+
 type serviceState int
 
 const (
@@ -44,10 +58,11 @@ const (
 
 var (
 	metrics                  = kingpin.Flag("web.telemetry-path", "uri path to expose metrics").Default("/metrics").String()
+	enableAdminAPI           = kingpin.Flag("web.enable-admin-api", "Enable the cache admin endpoints /-/cache and /-/cache/refresh in cache mode").Default("false").Bool()
 	osClientConfig           = kingpin.Flag("os-client-config", "Path to the cloud configuration file").Default(DEFAULT_OS_CLIENT_CONFIG).String()
 	prefix                   = kingpin.Flag("prefix", "Prefix for metrics").Default("openstack").String()
 	endpointType             = kingpin.Flag("endpoint-type", "openstack endpoint type to use (i.e: public, internal, admin)").Default("public").String()
-	collectTime              = kingpin.Flag("collect-metric-time", "time spent collecting each metric").Default("false").Bool()
+	collectTime              = kingpin.Flag("collect-metric-time", "Deprecated: expose openstack_metric_collect_seconds, use <prefix>_exporter_collector_duration_seconds instead").Default("false").Bool()
 	disabledMetrics          = kingpin.Flag("disable-metric", "multiple --disable-metric can be specified in the format: service-metric (i.e: cinder-snapshots)").Default("").Short('d').Strings()
 	disableSlowMetrics       = kingpin.Flag("disable-slow-metrics", "Disable slow metrics for performance reasons").Default("false").Bool()
 	disableDeprecatedMetrics = kingpin.Flag("disable-deprecated-metrics", "Disable deprecated metrics").Default("false").Bool()
@@ -57,10 +72,37 @@ var (
 	domainID                 = kingpin.Flag("domain-id", "Gather metrics only for the given Domain ID (defaults to all domains)").String()
 	cacheEnable              = kingpin.Flag("cache", "Enable Cache mechanism globally").Default("false").Bool()
 	cacheTTL                 = kingpin.Flag("cache-ttl", "TTL duration for cache expiry(eg. 10s, 11m, 1h)").Default("300s").Duration()
+	cacheDir                 = kingpin.Flag("cache.dir", "Directory where the cache is persisted after each collection and restored from on startup, disabled when empty").Default("").String()
+	cacheMaxStaleness        = kingpin.Flag("cache.max-staleness", "How long the cached metrics of a service are still served when its refresh fails, 0 uses the cache TTL").Default("0s").Duration()
+	cacheRedisURL            = kingpin.Flag("cache.redis-url", "Store the cache in a Redis compatible server shared by several exporters (i.e: redis://:password@host:6379/0), disabled when empty").Default("").String()
+	cacheRedisPrefix         = kingpin.Flag("cache.redis-prefix", "Prefix of the keys stored in the Redis cache").Default("openstack_exporter:").String()
+	serviceRefreshIntervals  = kingpin.Flag("cache.refresh-interval.service", "multiple --cache.refresh-interval.service can be specified in the format: service=duration (i.e: compute=2m), services default to half the cache TTL").PlaceHolder("SERVICE=DURATION").StringMap()
+	metricRefreshIntervals   = kingpin.Flag("cache.refresh-interval.metric", "multiple --cache.refresh-interval.metric can be specified in the format: service-metric=duration (i.e: nova-agent_state=30s), the metric is refreshed apart from its service").PlaceHolder("SERVICE-METRIC=DURATION").StringMap()
+	cacheRefreshJitter       = kingpin.Flag("cache.refresh-jitter", "Random delay added to every cache refresh, as a fraction of its interval").Default("0.1").Float64()
+	cacheCloudConcurrency    = kingpin.Flag("cache.cloud-concurrent-count", "Number of clouds collected concurrently in cache mode, 0 for no limit").Default("4").Int()
+	cacheServiceConcurrency  = kingpin.Flag("cache.service-concurrent-count", "Number of services of a cloud collected concurrently in cache mode, 0 for no limit").Default("4").Int()
 	tenantID                 = kingpin.Flag("project-id", "Gather metrics only for the given Project ID (defaults to all projects)").String()
 	disableServiceAutodetect = kingpin.Flag("disable-service-autodetect", "Disable single-cloud service autodetection and use only explicit service flags").Default("false").Bool()
 	novaMetadataMapping      = utils.LabelMapping(kingpin.Flag("nova.metadata-extra-labels", "Map provided server metadata keys to labels in openstack_nova_server_status metric").PlaceHolder("LABEL=KEY,KEY").Default(""))
 	dnsConcurrentCount       = kingpin.Flag("dns-concurrent-count", "Number of concurrent requests for DNS recordset collection").Default("10").Int()
+	projectConcurrentCount   = kingpin.Flag("project-concurrent-count", "Number of concurrent requests for per-project collection (quotas and limits)").Default("10").Int()
+	regions                  = kingpin.Flag("region", "multiple --region can be specified to collect every given region, adding a region label to every metric (defaults to the region of the cloud, without region label)").Strings()
+	discoverRegions          = kingpin.Flag("discover-regions", "Collect every region of the service catalog when no --region is given, adding a region label to every metric").Default("false").Bool()
+	collectTimeout           = kingpin.Flag("collect-timeout", "Deadline for collecting a service, 0 only bounds the collection by the Prometheus scrape timeout (eg. 10s, 1m)").Default("0s").Duration()
+	serviceCollectTimeouts   = kingpin.Flag("collect-timeout.service", "multiple --collect-timeout.service can be specified in the format: service=duration (i.e: compute=20s)").PlaceHolder("SERVICE=DURATION").StringMap()
+	metricCollectTimeouts    = kingpin.Flag("collect-timeout.metric", "multiple --collect-timeout.metric can be specified in the format: service-metric=duration (i.e: nova-flavors=5s)").PlaceHolder("SERVICE-METRIC=DURATION").StringMap()
+	scrapeTimeoutOffset      = kingpin.Flag("scrape-timeout-offset", "Offset subtracted from the X-Prometheus-Scrape-Timeout-Seconds header when computing the collection deadline").Default("500ms").Duration()
+	scrapeCoalesce           = kingpin.Flag("scrape-coalesce", "Share a live collection between the concurrent scrapes of the same cloud and services").Default("true").Bool()
+	scrapeCoalesceGrace      = kingpin.Flag("scrape-coalesce-grace", "How long the result of a coalesced live collection is also served to the following scrapes, 0 only shares it with the concurrent ones").Default("0s").Duration()
+	exporterIdleTTL          = kingpin.Flag("exporter-idle-ttl", "Drop exporters of clouds that have not been scraped for this duration, 0 disables eviction (eg. 30m, 1h)").Default("1h").Duration()
+	apiRetries               = kingpin.Flag("api.retries", "Number of retries of idempotent OpenStack API requests failing with 429, 502, 503 or 504, 0 disables retries").Default("2").Int()
+	apiRetryBackoff          = kingpin.Flag("api.retry-backoff", "Initial backoff between two retries of an OpenStack API request, doubled at each retry").Default("500ms").Duration()
+	apiRetryMaxBackoff       = kingpin.Flag("api.retry-max-backoff", "Maximum backoff between two retries of an OpenStack API request, also caps Retry-After").Default("10s").Duration()
+	apiRateLimit             = kingpin.Flag("api.rate-limit", "Maximum number of OpenStack API requests per second for each cloud, 0 disables the limit").Default("0").Float64()
+	apiServiceRateLimit      = kingpin.Flag("api.service-rate-limit", "Maximum number of OpenStack API requests per second for each service endpoint of a cloud, 0 disables the limit").Default("0").Float64()
+	apiRateLimitBurst        = kingpin.Flag("api.rate-limit-burst", "Number of OpenStack API requests allowed to exceed the rate limits in a burst").Default("10").Int()
+	configFile               = kingpin.Flag("config.file", "Path to the exporter configuration file, setting the exporter options globally and per cloud").Default("").String()
+	configReloadInterval     = kingpin.Flag("config.reload-interval", "Interval at which the configuration file is checked for changes, 0 only reloads it on SIGHUP").Default("30s").Duration()
 )
 
 func main() {
@@ -96,18 +138,60 @@ func main() {
 		logger.Error("openstack-exporter: error: required argument 'cloud' or flag --multi-cloud not provided, try --help")
 	}
 
+	if *collectTime {
+		logger.Warn("--collect-metric-time is deprecated and will be removed in next release, use the exporter_collector_duration_seconds metric instead")
+	}
+
 	if *osClientConfig != DEFAULT_OS_CLIENT_CONFIG {
 		logger.Debug("Setting Env var OS_CLIENT_CONFIG_FILE", "os_client_config_file", *osClientConfig)
 		os.Setenv("OS_CLIENT_CONFIG_FILE", *osClientConfig)
 	}
 
-	SetPasswordIfVaultIsUsed(logger)
-
 	if _, err := os.Stat(*osClientConfig); err != nil {
 		logger.Error("Could not read config file", "error", err)
 		os.Exit(1)
 	}
 
+	credentialsProviders, err := credentials.LoadProviders(logger)
+	if err != nil {
+		logger.Error("Invalid credentials provider configuration", "error", err)
+		os.Exit(1)
+	}
+	if credentialsProviders != nil {
+		exporters.DefaultProviderClientPool.SetCredentialsProvider(credentialsProviders)
+	}
+
+	timeouts, err := parseCollectTimeouts(*collectTimeout, *serviceCollectTimeouts, *metricCollectTimeouts)
+	if err != nil {
+		logger.Error("Invalid collect timeout", "error", err)
+		os.Exit(1)
+	}
+	collectTimeouts = timeouts
+
+	configStore, err = config.NewStore(*configFile, logger)
+	if err != nil {
+		logger.Error("Failed to load configuration file", "path", *configFile, "error", err)
+		os.Exit(1)
+	}
+	applyCacheSettings(configStore.Config().Cache)
+
+	// OS_DEBUG logs the OpenStack API requests, as with the OpenStack clients.
+	_, osDebug := os.LookupEnv("OS_DEBUG")
+	exporters.DefaultProviderClientPool.SetTransportOptions(exporters.TransportOptions{
+		Retry: exporters.RetryPolicy{
+			MaxRetries:     *apiRetries,
+			InitialBackoff: *apiRetryBackoff,
+			MaxBackoff:     *apiRetryMaxBackoff,
+			StatusCodes:    exporters.DefaultRetryStatusCodes,
+		},
+		RateLimit: exporters.RateLimits{
+			Cloud:   *apiRateLimit,
+			Service: *apiServiceRateLimit,
+			Burst:   *apiRateLimitBurst,
+		},
+		Debug: osDebug,
+	})
+
 	services, err := resolveServiceConfig(*multiCloud, *cloud, *disableServiceAutodetect, serviceStates, logger)
 	if err != nil {
 		logger.Error("Failed to resolve service configuration", "error", err)
@@ -120,13 +204,99 @@ func main() {
 	ctx2, cancel2 := signal.NotifyContext(ctx1, syscall.SIGINT, syscall.SIGTERM)
 	defer cancel2()
 
+	providersCtx, stopProviders := context.WithCancel(ctx2)
+	if credentialsProviders != nil {
+		go credentialsProviders.Run(providersCtx)
+	}
+	go exporters.DefaultProviderClientPool.Run(ctx2, logger)
+
+	commonMetrics := exporters.NewCommonMetricsExporter(*prefix, *disableDeprecatedMetrics)
+	prometheus.MustRegister(commonMetrics)
+	prometheus.MustRegister(exporters.APIMetricsCollector())
+
 	// Start the backend service.
 	if *cacheEnable {
-		go cacheBackgroundService(ctx2, services, cancel1, logger)
+		staleness := *cacheMaxStaleness
+		if staleness == 0 {
+			staleness = *cacheTTL
+		}
+		cache.SetMaxStaleness(staleness)
+		schedule, err := parseRefreshSchedule(*cacheTTL, *cacheRefreshJitter, *serviceRefreshIntervals, *metricRefreshIntervals)
+		if err != nil {
+			logger.Error("Invalid cache refresh interval", "error", err)
+			os.Exit(1)
+		}
+		cache.SetRefreshSchedule(schedule)
+		cache.SetConcurrency(*cacheCloudConcurrency, *cacheServiceConcurrency)
+		if *cacheDir != "" && *cacheRedisURL != "" {
+			logger.Error("--cache.dir and --cache.redis-url are mutually exclusive")
+			os.Exit(1)
+		}
+		if *cacheRedisURL != "" {
+			redisCache, err := cache.NewRedisCache(*cacheRedisURL, *cacheRedisPrefix, *cacheTTL, logger)
+			if err != nil {
+				logger.Error("Failed to set up the Redis cache", "error", err)
+				os.Exit(1)
+			}
+			defer redisCache.Close()
+			cache.SetCache(redisCache)
+		}
+		if *cacheDir != "" {
+			fileCache, err := cache.NewFileCache(*cacheDir, logger)
+			if err != nil {
+				logger.Error("Failed to open cache directory", "path", *cacheDir, "error", err)
+				os.Exit(1)
+			}
+			cache.SetCache(fileCache)
+			cache.FlushExpiredCloudCaches(*cacheTTL)
+		}
+		cache.SetCollectObserver(func(cloud string, duration time.Duration, failed bool) {
+			commonMetrics.ObserveScrape(cloud, exporters.ScrapeModeCache, duration, failed)
+		})
+		go cacheBackgroundService(ctx2, services, logger)
 	}
 
+	instances := exporters.NewExporterInstances(newExporterFactory(logger), *exporterIdleTTL, logger)
+	if *scrapeCoalesce {
+		scrapeCoalescer = exporters.NewScrapeCoalescer(*scrapeCoalesceGrace)
+	}
+	go instances.Run(ctx2)
+
+	// Rebuild the exporters with the new options, the HTTP server keeps running.
+	// The clouds whose clouds.yaml entry changed authenticate again, with the
+	// credentials providers read again.
+	previousConfig := configStore.Config()
+	configStore.OnReload(func(c *config.Config) {
+		if c.Cache.Enabled != nil && *c.Cache.Enabled != *cacheEnable || c.Cache.TTL != nil && *c.Cache.TTL != *cacheTTL {
+			logger.Warn("Cache settings of the configuration file are only applied on restart")
+		}
+		changed := c.ChangedClouds(previousConfig)
+		previousConfig = c
+		if len(changed) > 0 {
+			providers, err := credentials.LoadProviders(logger)
+			if err != nil {
+				logger.Error("Failed to reload the credentials providers, keeping the previous ones", "error", err)
+			} else {
+				stopProviders()
+				providersCtx, stopProviders = context.WithCancel(ctx2)
+				if providers != nil {
+					exporters.DefaultProviderClientPool.SetCredentialsProvider(providers)
+					go providers.Run(providersCtx)
+				} else {
+					exporters.DefaultProviderClientPool.SetCredentialsProvider(nil)
+				}
+			}
+			for _, cloud := range changed {
+				logger.Info("Cloud configuration changed, authenticating it again", "cloud", cloud)
+				exporters.DefaultProviderClientPool.Invalidate(cloud)
+			}
+		}
+		instances.Reset()
+	})
+	go configStore.Watch(ctx2, *configReloadInterval)
+
 	// Start the HTTP server.
-	go startHTTPServer(ctx2, services, toolkitFlags, cancel1, logger)
+	go startHTTPServer(ctx2, services, instances, commonMetrics, toolkitFlags, cancel1, logger)
 
 	<-ctx2.Done()
 	if err := context.Cause(ctx2); err != nil && !errors.Is(err, context.Canceled) {
@@ -202,7 +372,7 @@ func getEnabledServicesFromStates(serviceStates map[string]serviceState) []strin
 
 func autodetectServices(cloud string, logger *slog.Logger) ([]string, error) {
 	opts := &clientconfigv2.ClientOpts{Cloud: cloud}
-	services, err := exporters.AutodetectServicesFromCatalog(opts, nil, *endpointType)
+	services, err := exporters.AutodetectServicesFromCatalog(opts, nil, exporterOptions(cloud).EndpointType, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -210,29 +380,27 @@ func autodetectServices(cloud string, logger *slog.Logger) ([]string, error) {
 }
 
 // cacheBackgroundService runs a background service to collect the metrics and stores in the cache.
-// It collects data every cache-ttl/2 time and flush every cache-ttl time.
+// Every service, and every metric with its own interval, is refreshed on its own schedule,
+// every cache-ttl/2 by default, and the cache is flushed every cache-ttl time.
 // The cache data will be read by the Prometheus HandleFunc.
-func cacheBackgroundService(ctx context.Context, services []string, cancel context.CancelCauseFunc, logger *slog.Logger) {
+func cacheBackgroundService(ctx context.Context, services []string, logger *slog.Logger) {
 	logger.Info("Start cache background service")
-	collectTicker := time.NewTicker(*cacheTTL / 2)
-	defer collectTicker.Stop()
+	cloudServices := func(cloud string) []string {
+		return servicesForCloud(cloud, services)
+	}
 	ttlTicker := time.NewTicker(*cacheTTL)
 	defer ttlTicker.Stop()
 
-	// Collect cache data in the beginning.
-	if err := cache.CollectCache(exporters.EnableExporter, *multiCloud, services, *prefix, *cloud, *disabledMetrics, *endpointType, *collectTime, *disableSlowMetrics, *disableDeprecatedMetrics, *disableCinderAgentUUID, *domainID, *tenantID, novaMetadataMapping, *dnsConcurrentCount, nil, logger); err != nil {
+	// Collect cache data in the beginning. Clouds failing to collect are
+	// retried by their refresh schedule.
+	if err := cache.CollectCache(exporters.EnableExporter, *multiCloud, cloudServices, *cloud, exporterOptions, logger); err != nil {
 		logger.Error("Failed to collect from cache", "err", err)
-		cancel(err)
-		return
 	}
 
+	go cache.RunRefreshSchedule(ctx, exporters.EnableExporter, *multiCloud, cloudServices, *cloud, exporterOptions, logger)
+
 	for {
 		select {
-		case <-collectTicker.C:
-			if err := cache.CollectCache(exporters.EnableExporter, *multiCloud, services, *prefix, *cloud, *disabledMetrics, *endpointType, *collectTime, *disableSlowMetrics, *disableDeprecatedMetrics, *disableCinderAgentUUID, *domainID, *tenantID, novaMetadataMapping, *dnsConcurrentCount, nil, logger); err != nil {
-				cancel(err)
-				return
-			}
 		case <-ttlTicker.C:
 			cache.FlushExpiredCloudCaches(*cacheTTL)
 			logger.Info("Cache TTL flush")
@@ -243,12 +411,207 @@ func cacheBackgroundService(ctx context.Context, services []string, cancel conte
 	}
 }
 
-func startHTTPServer(ctx context.Context, services []string, toolkitFlags *web.FlagConfig, cancel context.CancelCauseFunc, logger *slog.Logger) {
+// newExporterFactory returns the ExporterFactory building exporters from the command line flags.
+func newExporterFactory(logger *slog.Logger) exporters.ExporterFactory {
+	return func(service, cloud string) (exporters.OpenStackExporter, error) {
+		exp, err := exporters.EnableExporter(service, cloud, exporterOptions(cloud), logger)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Enabled exporter for service", "service", service, "cloud", cloud)
+		return *exp, nil
+	}
+}
+
+// flagOptions returns the exporter options set by the command line flags.
+func flagOptions() exporters.Options {
+	return exporters.Options{
+		Prefix:                   *prefix,
+		DisabledMetrics:          *disabledMetrics,
+		EndpointType:             *endpointType,
+		CollectTime:              *collectTime,
+		DisableSlowMetrics:       *disableSlowMetrics,
+		DisableDeprecatedMetrics: *disableDeprecatedMetrics,
+		DisableCinderAgentUUID:   *disableCinderAgentUUID,
+		DomainID:                 *domainID,
+		TenantID:                 *tenantID,
+		NovaMetadataMapping:      novaMetadataMapping,
+		DnsConcurrentCount:       *dnsConcurrentCount,
+		ProjectConcurrentCount:   *projectConcurrentCount,
+		CollectTimeouts:          collectTimeouts,
+		Regions:                  *regions,
+		DiscoverRegions:          *discoverRegions,
+	}
+}
+
+// exporterOptions returns the exporter options of cloud, the flags overridden
+// by the configuration file.
+func exporterOptions(cloud string) exporters.Options {
+	return configStore.Config().Options(cloud, flagOptions())
+}
+
+// servicesForCloud returns the services enabled for cloud, the configured
+// services unless overridden by the configuration.
+func servicesForCloud(cloud string, configuredServices []string) []string {
+	return configStore.Config().Services(cloud, configuredServices)
+}
+
+// applyCacheSettings overrides the cache flags with the configuration file.
+func applyCacheSettings(settings config.CacheSettings) {
+	if settings.Enabled != nil {
+		*cacheEnable = *settings.Enabled
+	}
+	if settings.TTL != nil {
+		*cacheTTL = *settings.TTL
+	}
+}
+
+// parseCollectTimeouts builds the CollectTimeouts from the --collect-timeout* flags.
+func parseCollectTimeouts(defaultTimeout time.Duration, services, metrics map[string]string) (exporters.CollectTimeouts, error) {
+	timeouts := exporters.CollectTimeouts{
+		Default:  defaultTimeout,
+		Services: make(map[string]time.Duration, len(services)),
+		Metrics:  make(map[string]time.Duration, len(metrics)),
+	}
+
+	for service, raw := range services {
+		if !exporters.IsExporterNameValid(service) {
+			return timeouts, fmt.Errorf("invalid service in --collect-timeout.service: %s", service)
+		}
+		timeout, err := time.ParseDuration(raw)
+		if err != nil {
+			return timeouts, fmt.Errorf("invalid --collect-timeout.service for %s: %w", service, err)
+		}
+		timeouts.Services[service] = timeout
+	}
+
+	for metric, raw := range metrics {
+		if _, ok := exporters.MetricService(metric); !ok {
+			return timeouts, fmt.Errorf("invalid metric in --collect-timeout.metric: %s", metric)
+		}
+		timeout, err := time.ParseDuration(raw)
+		if err != nil {
+			return timeouts, fmt.Errorf("invalid --collect-timeout.metric for %s: %w", metric, err)
+		}
+		timeouts.Metrics[metric] = timeout
+	}
+
+	return timeouts, nil
+}
+
+// parseRefreshSchedule builds the cache RefreshSchedule from the
+// --cache.refresh-* flags. Every interval, with its jitter, must fit in the
+// cache TTL, or the cache of the service would expire between two refreshes.
+func parseRefreshSchedule(ttl time.Duration, jitter float64, services, metrics map[string]string) (cache.RefreshSchedule, error) {
+	schedule := cache.RefreshSchedule{
+		Default:  ttl / 2,
+		Services: make(map[string]time.Duration, len(services)),
+		Metrics:  make(map[string]time.Duration, len(metrics)),
+		Jitter:   jitter,
+	}
+	if jitter < 0 || jitter > 1 {
+		return schedule, fmt.Errorf("--cache.refresh-jitter must be between 0 and 1, got %v", jitter)
+	}
+
+	checkInterval := func(flag, name, raw string) (time.Duration, error) {
+		interval, err := time.ParseDuration(raw)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s for %s: %w", flag, name, err)
+		}
+		if interval <= 0 {
+			return 0, fmt.Errorf("invalid %s for %s: must be positive", flag, name)
+		}
+		if interval+time.Duration(jitter*float64(interval)) > ttl {
+			return 0, fmt.Errorf("invalid %s for %s: %s with its jitter exceeds the cache TTL of %s", flag, name, interval, ttl)
+		}
+		return interval, nil
+	}
+
+	for service, raw := range services {
+		if !exporters.IsExporterNameValid(service) {
+			return schedule, fmt.Errorf("invalid service in --cache.refresh-interval.service: %s", service)
+		}
+		interval, err := checkInterval("--cache.refresh-interval.service", service, raw)
+		if err != nil {
+			return schedule, err
+		}
+		schedule.Services[service] = interval
+	}
+
+	for metric, raw := range metrics {
+		if _, ok := exporters.MetricService(metric); !ok {
+			return schedule, fmt.Errorf("invalid metric in --cache.refresh-interval.metric: %s", metric)
+		}
+		interval, err := checkInterval("--cache.refresh-interval.metric", metric, raw)
+		if err != nil {
+			return schedule, err
+		}
+		schedule.Metrics[metric] = interval
+	}
+
+	return schedule, nil
+}
+
+// collectScrape collects the exporters of services for cloud, sharing the
+// collection with the concurrent scrapes of the same cloud and services.
+func collectScrape(ctx context.Context, instances *exporters.ExporterInstances, cloud string, services []string) (exporters.ScrapeResult, error) {
+	return scrapeCoalescer.Do(ctx, cloud, services, func(ctx context.Context) exporters.ScrapeResult {
+		registry := prometheus.NewPedanticRegistry()
+		exps, err := instances.Get(cloud, services)
+		// The collection may run apart from the request, register without
+		// panicking.
+		for _, exp := range exps {
+			if registerErr := registry.Register(exporters.WithContext(ctx, exp)); registerErr != nil {
+				err = errors.Join(err, registerErr)
+			}
+		}
+
+		mfs, gatherErr := registry.Gather()
+		return exporters.ScrapeResult{MetricFamilies: mfs, GatherErr: gatherErr, EnableErr: err, Exporters: len(exps)}
+	})
+}
+
+// scrapeContext returns the request context bounded by the scrape timeout announced by Prometheus.
+func scrapeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	timeout := exporters.ScrapeTimeout(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), *scrapeTimeoutOffset)
+	if timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
+// observedGatherer reports every Gather of a scrape, so the common metrics can
+// account for collectors failing while the response is being written.
+type observedGatherer struct {
+	prometheus.Gatherer
+	observe func(mfs []*dto.MetricFamily, err error)
+}
+
+func (g observedGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	g.observe(mfs, err)
+	return mfs, err
+}
+
+// newObservedGatherer returns a Gatherer recording the scrape of cloud in commonMetrics.
+// Scrapes are marked as failed when enabling an exporter, gathering or any collector failed.
+func newObservedGatherer(g prometheus.Gatherer, commonMetrics *exporters.CommonMetricsExporter, cloud, mode string, start time.Time, enableFailed bool) prometheus.Gatherer {
+	return observedGatherer{
+		Gatherer: g,
+		observe: func(mfs []*dto.MetricFamily, err error) {
+			failed := enableFailed || err != nil || exporters.CollectorFailures(*prefix, mfs) > 0
+			commonMetrics.ObserveScrape(cloud, mode, time.Since(start), failed)
+		},
+	}
+}
+
+func startHTTPServer(ctx context.Context, services []string, instances *exporters.ExporterInstances, commonMetrics *exporters.CommonMetricsExporter, toolkitFlags *web.FlagConfig, cancel context.CancelCauseFunc, logger *slog.Logger) {
 	links := []web.LandingLinks{}
 
 	if *multiCloud {
-		http.HandleFunc("/probe", probeHandler(services, logger))
+		http.HandleFunc("/probe", probeHandler(services, instances, commonMetrics, logger))
 		http.Handle(*metrics, promhttp.Handler())
+		http.HandleFunc("/sd/clouds", sdCloudsHandler(services, logger))
 		logger.Info("openstack exporter started in multi cloud mode (/probe?cloud=)")
 		links = append(links, web.LandingLinks{
 			Address: *metrics,
@@ -256,16 +619,32 @@ func startHTTPServer(ctx context.Context, services []string, toolkitFlags *web.F
 		}, web.LandingLinks{
 			Address: "/probe",
 			Text:    "Probes",
+		}, web.LandingLinks{
+			Address: "/sd/clouds",
+			Text:    "Service discovery",
 		})
 	} else {
 		logger.Info("openstack exporter started in legacy mode")
-		http.HandleFunc(*metrics, metricHandler(services, logger))
+		http.HandleFunc(*metrics, metricHandler(services, instances, commonMetrics, logger))
 		links = append(links, web.LandingLinks{
 			Address: *metrics,
 			Text:    "Metrics",
 		})
 	}
 
+	var cacheCollected func() bool
+	if *cacheEnable {
+		cacheCollected = cache.Collected
+	}
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler(exporters.DefaultProviderClientPool.AuthStatuses, cacheCollected, logger))
+
+	if *cacheEnable && *enableAdminAPI {
+		http.HandleFunc("/-/cache", cacheInspectHandler(logger))
+		http.HandleFunc("/-/cache/refresh", cacheRefreshHandler(services, exporters.EnableExporter, logger))
+		logger.Info("Cache admin API enabled (/-/cache, /-/cache/refresh)")
+	}
+
 	if *metrics != "/" && *metrics != "" {
 		landingConfig := web.LandingConfig{
 			Name:        "openstack_exporter",
@@ -309,9 +688,10 @@ func startHTTPServer(ctx context.Context, services []string, toolkitFlags *web.F
 	}
 }
 
-func probeHandler(configuredServices []string, logger *slog.Logger) http.HandlerFunc {
+func probeHandler(configuredServices []string, instances *exporters.ExporterInstances, commonMetrics *exporters.CommonMetricsExporter, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		ctx, cancel := context.WithCancel(r.Context())
+		start := time.Now()
+		ctx, cancel := scrapeContext(r)
 		defer cancel()
 		r = r.WithContext(ctx)
 
@@ -321,7 +701,18 @@ func probeHandler(configuredServices []string, logger *slog.Logger) http.Handler
 			return
 		}
 
-		enabledServices, err := selectServicesForRequest(configuredServices, r)
+		if _, err := requestClouds(r, logger); err != nil {
+			logger.Error("Probe of a cloud missing from clouds.yaml", "cloud", cloud, "error", err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, errUnknownCloud) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			commonMetrics.ObserveScrape(exporters.UnknownCloud, exporters.ScrapeModeProbe, time.Since(start), true)
+			return
+		}
+
+		enabledServices, err := selectServicesForRequest(servicesForCloud(cloud, configuredServices), r)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -330,71 +721,93 @@ func probeHandler(configuredServices []string, logger *slog.Logger) http.Handler
 
 		// Get data from cache
 		if *cacheEnable {
-			if err := cache.WriteCacheToResponse(w, r, cloud, enabledServices, logger); err != nil {
+			err := cache.WriteCacheToResponse(w, r, cloud, exporterOptions(cloud).Prefix, enabledServices, logger)
+			if err != nil {
 				logger.Error("Write cache to response failed", "error", err)
 			}
+			commonMetrics.ObserveScrape(cloud, exporters.ScrapeModeProbe, time.Since(start), err != nil)
 			return
 		}
 
-		registry := prometheus.NewPedanticRegistry()
-		for _, service := range enabledServices {
-			exp, err := exporters.EnableExporter(service, *prefix, cloud, *disabledMetrics, *endpointType, *collectTime, *disableSlowMetrics, *disableDeprecatedMetrics, *disableCinderAgentUUID, *domainID, *tenantID, novaMetadataMapping, *dnsConcurrentCount, nil, logger)
-			if err != nil {
-				logger.Error("Enabling exporter for service failed", "service", service, "error", err)
-				continue
-			}
-			registry.MustRegister(*exp)
-			logger.Info("Enabled exporter for service", "service", service)
+		result, err := collectScrape(ctx, instances, cloud, enabledServices)
+		if err != nil {
+			logger.Error("Scrape abandoned while waiting for the collection", "error", err)
+			http.Error(w, "scrape abandoned", http.StatusServiceUnavailable)
+			commonMetrics.ObserveScrape(cloud, exporters.ScrapeModeProbe, time.Since(start), true)
+			return
+		}
+		if result.EnableErr != nil {
+			logger.Error("Enabling exporter for service failed", "error", result.EnableErr)
 		}
 
-		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		gatherer := newObservedGatherer(result.Gatherer(), commonMetrics, cloud, exporters.ScrapeModeProbe, start, result.EnableErr != nil)
+		h := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
 		h.ServeHTTP(w, r)
 	}
 }
 
-func metricHandler(configuredServices []string, logger *slog.Logger) http.HandlerFunc {
+func metricHandler(configuredServices []string, instances *exporters.ExporterInstances, commonMetrics *exporters.CommonMetricsExporter, logger *slog.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
 		logger.Info("Starting openstack exporter version for cloud", "version", version.Info(), "cloud", *cloud)
 		logger.Info("Build context", "build_context", version.BuildContext())
 
+		ctx, cancel := scrapeContext(r)
+		defer cancel()
+		r = r.WithContext(ctx)
+
 		if *osClientConfig != DEFAULT_OS_CLIENT_CONFIG {
 			logger.Debug("Setting Env var OS_CLIENT_CONFIG_FILE", "os_client_config_file", *osClientConfig)
 			os.Setenv("OS_CLIENT_CONFIG_FILE", *osClientConfig)
 		}
 
-		enabledServices := configuredServices
+		enabledServices := servicesForCloud(*cloud, configuredServices)
 
 		// Get data from cache
 		if *cacheEnable {
-			if err := cache.WriteCacheToResponse(w, r, *cloud, enabledServices, logger); err != nil {
+			// expose the exporter's own metrics after the cached ones, recording
+			// the scrape first so they include it
+			observed := false
+			ownMetrics := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+				observed = true
+				commonMetrics.ObserveScrape(*cloud, exporters.ScrapeModeMetrics, time.Since(start), false)
+				return prometheus.DefaultGatherer.Gather()
+			})
+			err := cache.WriteCacheToResponse(w, r, *cloud, exporterOptions(*cloud).Prefix, enabledServices, logger, ownMetrics)
+			if err != nil {
 				logger.Error("Write cache to response failed", "error", err)
+				if observed {
+					commonMetrics.ScrapeErrors().WithLabelValues(*cloud, exporters.ScrapeModeMetrics).Inc()
+				} else {
+					commonMetrics.ObserveScrape(*cloud, exporters.ScrapeModeMetrics, time.Since(start), true)
+				}
 			}
 			return
 		}
 
-		registry := prometheus.NewPedanticRegistry()
-		enabledExporters := 0
-		for _, service := range enabledServices {
-			exp, err := exporters.EnableExporter(service, *prefix, *cloud, *disabledMetrics, *endpointType, *collectTime, *disableSlowMetrics, *disableDeprecatedMetrics, *disableCinderAgentUUID, *domainID, *tenantID, novaMetadataMapping, *dnsConcurrentCount, nil, logger)
-			if err != nil {
-				// Log error and continue with enabling other exporters
-				logger.Error("enabling exporter for service failed", "service", service, "error", err)
-				continue
-			}
-			registry.MustRegister(*exp)
-			logger.Info("Enabled exporter for service", "service", service)
-			enabledExporters++
+		result, err := collectScrape(ctx, instances, *cloud, enabledServices)
+		if err != nil {
+			logger.Error("Scrape abandoned while waiting for the collection", "error", err)
+			http.Error(w, "scrape abandoned", http.StatusServiceUnavailable)
+			commonMetrics.ObserveScrape(*cloud, exporters.ScrapeModeMetrics, time.Since(start), true)
+			return
+		}
+		if result.EnableErr != nil {
+			// Log error and continue with the other exporters
+			logger.Error("enabling exporter for service failed", "error", result.EnableErr)
 		}
 
-		if enabledExporters == 0 {
+		if result.Exporters == 0 {
 			logger.Error("No exporter has been enabled, exiting")
 			os.Exit(-1)
 		}
 
-		// expose program version
-		registry.MustRegister(pver.NewCollector("openstack_exporter"))
-
-		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+		// expose the exporter's own metrics, including the program version
+		gatherer := prometheus.Gatherers{
+			newObservedGatherer(result.Gatherer(), commonMetrics, *cloud, exporters.ScrapeModeMetrics, start, result.EnableErr != nil),
+			prometheus.DefaultGatherer,
+		}
+		h := promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
 		h.ServeHTTP(w, r)
 	}
 }
@@ -430,81 +843,289 @@ func invalidExporterNames(services []string) []string {
 	return invalid
 }
 
-func parseServiceList(raw string) []string {
-	if raw == "" {
-		return nil
-	}
+// errUnknownCloud is returned for an admin request naming a cloud which isn't collected.
+var errUnknownCloud = errors.New("unknown cloud")
 
-	services := []string{}
-	for _, service := range strings.Split(raw, ",") {
-		service = strings.TrimSpace(service)
-		if service == "" {
-			continue
+// requestClouds returns the cloud named by the cloud parameter of an admin
+// request or a probe, or every collected cloud when it is missing.
+func requestClouds(r *http.Request, logger *slog.Logger) ([]string, error) {
+	clouds, err := cache.Clouds(*multiCloud, *cloud)
+	if err != nil {
+		if len(clouds) == 0 {
+			return nil, err
 		}
-		services = append(services, service)
+		logger.Warn("Failed to load the clouds, using the clouds loaded last", "error", err)
 	}
 
-	return services
+	name := r.URL.Query().Get("cloud")
+	if name == "" {
+		return clouds, nil
+	}
+	if !slices.Contains(clouds, name) {
+		return nil, fmt.Errorf("%w: %s", errUnknownCloud, name)
+	}
+	return []string{name}, nil
 }
 
-func SetPasswordIfVaultIsUsed(logger *slog.Logger) {
-	configFileData, err := os.ReadFile(*osClientConfig)
-	if err != nil {
-		logger.Error("failed to read config file", "err", err)
-		os.Exit(1)
+// writeAdminError replies to an admin request with err.
+func writeAdminError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnknownCloud) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
 
-	type VaultConfig struct {
-		UseVault                    bool   `yaml:"use_vault"`
-		VaultAddress                string `yaml:"vault_address"`
-		VaultRoleID                 string `yaml:"vault_role_id"`
-		VaultSecretID               string `yaml:"vault_secret_id"`
-		VaultSecretPath             string `yaml:"vault_secret_path"`
-		VaultSecretMountPath        string `yaml:"vault_secret_mount_path"`
-		CredentialNameInVaultSecret string `yaml:"credential_name_in_vault_secret"`
+// writeCacheInfo replies to an admin request with the content of the cache of clouds.
+func writeCacheInfo(w http.ResponseWriter, clouds []string, logger *slog.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(cache.Inspect(clouds)); err != nil {
+		logger.Error("Failed to write the cache content", "error", err)
 	}
+}
 
-	var vaultConfig VaultConfig
+// cacheInspectHandler serves the content of the cache of every cloud, or of
+// the one of the cloud parameter, as JSON.
+func cacheInspectHandler(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 
-	err = yaml.Unmarshal(configFileData, &vaultConfig)
-	if err != nil {
-		logger.Error("failed to parse config data", "err", err)
-		os.Exit(1)
+		clouds, err := requestClouds(r, logger)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+		writeCacheInfo(w, clouds, logger)
 	}
+}
 
-	if !vaultConfig.UseVault {
-		return
+// cacheRefreshHandler refreshes the cache of every cloud, or of the one of the
+// cloud parameter, for its services or the ones of the service parameters,
+// then replies with the refreshed content as JSON.
+func cacheRefreshHandler(configuredServices []string, enableExporter cache.EnableExporterFunc, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var requested []string
+		for _, raw := range r.URL.Query()["service"] {
+			requested = append(requested, parseServiceList(raw)...)
+		}
+		if invalid := invalidExporterNames(requested); len(invalid) > 0 {
+			http.Error(w, fmt.Sprintf("invalid service: %s", strings.Join(invalid, ",")), http.StatusBadRequest)
+			return
+		}
+
+		clouds, err := requestClouds(r, logger)
+		if err != nil {
+			writeAdminError(w, err)
+			return
+		}
+
+		cloudServices := func(cloud string) []string {
+			services := servicesForCloud(cloud, configuredServices)
+			if len(requested) == 0 {
+				return services
+			}
+			return slices.DeleteFunc(slices.Clone(services), func(service string) bool {
+				return !slices.Contains(requested, service)
+			})
+		}
+		cache.RefreshCache(enableExporter, clouds, cloudServices, exporterOptions, logger)
+
+		writeCacheInfo(w, clouds, logger)
 	}
-	client, err := vault.New(vault.WithAddress(vaultConfig.VaultAddress))
-	if err != nil {
-		logger.Error("failed to create Vault client", "err", err)
-		os.Exit(1)
+}
+
+// healthzHandler reports that the exporter is alive.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "OK")
+}
+
+// readiness is the state reported by readyzHandler.
+type readiness struct {
+	Ready bool `json:"ready"`
+	// CacheCollected is only set in cache mode.
+	CacheCollected *bool            `json:"cache_collected,omitempty"`
+	Clouds         []cloudReadiness `json:"clouds"`
+}
+
+// cloudReadiness is the outcome of the last authentication of a cloud.
+type cloudReadiness struct {
+	Cloud       string    `json:"cloud"`
+	AuthSuccess bool      `json:"auth_success"`
+	Catalog     bool      `json:"catalog"`
+	Time        time.Time `json:"time"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// readyzHandler reports whether the exporter is ready to serve scrapes: the
+// last authentication of every cloud, or of the ones of the cloud parameters,
+// succeeded with a service catalog and, when cacheCollected isn't nil, the
+// cache has been collected once. The clouds not authenticated yet don't make
+// the exporter unready.
+func readyzHandler(authStatuses func() map[string]exporters.AuthStatus, cacheCollected func() bool, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		state := readiness{Ready: true, Clouds: []cloudReadiness{}}
+		if cacheCollected != nil {
+			collected := cacheCollected()
+			state.CacheCollected = &collected
+			state.Ready = collected
+		}
+
+		statuses := authStatuses()
+		requested := r.URL.Query()["cloud"]
+		for _, name := range slices.Sorted(maps.Keys(statuses)) {
+			if len(requested) > 0 && !slices.Contains(requested, name) {
+				continue
+			}
+			status := statuses[name]
+			cloudState := cloudReadiness{
+				Cloud:       name,
+				AuthSuccess: status.Success,
+				Catalog:     status.Catalog,
+				Time:        status.Time,
+			}
+			if status.Err != nil {
+				cloudState.Error = status.Err.Error()
+			}
+			state.Clouds = append(state.Clouds, cloudState)
+			state.Ready = state.Ready && status.Success && status.Catalog
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if !state.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(state); err != nil {
+			logger.Error("Failed to write the readiness state", "error", err)
+		}
 	}
-	ctx := context.Background()
-	resp, err := client.Auth.AppRoleLogin(
-		ctx,
-		schema.AppRoleLoginRequest{
-			RoleId:   vaultConfig.VaultRoleID,
-			SecretId: vaultConfig.VaultSecretID,
-		},
-	)
-	if err != nil {
-		logger.Error("failed to login to Vault", "err", err)
-		os.Exit(1)
+}
+
+// sdTargetGroup is a target group of the Prometheus HTTP service discovery.
+type sdTargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// cloudTargetGroups returns the target groups probing the clouds of
+// cloudsConfig through the exporter listening on address with scheme, one per
+// cloud, or one per cloud and enabled service when perService is set.
+func cloudTargetGroups(address, scheme string, cloudsConfig map[string]clientconfigv2.Cloud, configuredServices []string, perService bool) []sdTargetGroup {
+	groups := []sdTargetGroup{}
+	for _, name := range slices.Sorted(maps.Keys(cloudsConfig)) {
+		cloudConfig := cloudsConfig[name]
+		services := servicesForCloud(name, configuredServices)
+
+		var authURLHost string
+		if cloudConfig.AuthInfo != nil {
+			if authURL, err := url.Parse(cloudConfig.AuthInfo.AuthURL); err == nil {
+				authURLHost = authURL.Host
+			}
+		}
+
+		// The labels are prefixed with openstack_ so they don't clash with
+		// the region label of the metrics of multi-region clouds.
+		labels := func() map[string]string {
+			labels := map[string]string{
+				"__scheme__":         scheme,
+				"__metrics_path__":   "/probe",
+				"__param_cloud":      name,
+				"cloud":              name,
+				"openstack_services": strings.Join(services, ","),
+			}
+			if cloudConfig.RegionName != "" {
+				labels["openstack_region"] = cloudConfig.RegionName
+			}
+			if authURLHost != "" {
+				labels["openstack_auth_url_host"] = authURLHost
+			}
+			return labels
+		}
+
+		if !perService {
+			groups = append(groups, sdTargetGroup{Targets: []string{address}, Labels: labels()})
+			continue
+		}
+		for _, service := range services {
+			group := sdTargetGroup{Targets: []string{address}, Labels: labels()}
+			group.Labels["__param_include_services"] = service
+			group.Labels["service"] = service
+			groups = append(groups, group)
+		}
 	}
-	if err := client.SetToken(resp.Auth.ClientToken); err != nil {
-		logger.Error("failed to set Vault token", "err", err)
-		os.Exit(1)
+	return groups
+}
+
+// sdCloudsHandler serves the clouds of clouds.yaml as Prometheus HTTP service
+// discovery targets probing them through this exporter, one per cloud and
+// enabled service when the per_service parameter is true.
+func sdCloudsHandler(configuredServices []string, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		perService := false
+		if raw := r.URL.Query().Get("per_service"); raw != "" {
+			var err error
+			if perService, err = strconv.ParseBool(raw); err != nil {
+				http.Error(w, fmt.Sprintf("invalid per_service: %s", raw), http.StatusBadRequest)
+				return
+			}
+		}
+
+		cloudsConfig, err := clientconfigv2.LoadCloudsYAML()
+		if err != nil {
+			logger.Error("Failed to load the clouds for service discovery", "error", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The discovery is served like the probes, over TLS when the web
+		// config enables it.
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(cloudTargetGroups(r.Host, scheme, cloudsConfig, configuredServices, perService)); err != nil {
+			logger.Error("Failed to write the service discovery targets", "error", err)
+		}
 	}
-	secret, err := client.Secrets.KvV2Read(
-		ctx,
-		vaultConfig.VaultSecretPath,
-		vault.WithMountPath(vaultConfig.VaultSecretMountPath),
-	)
-	if err != nil {
-		logger.Error("failed to get secret from Vault", "err", err)
-		os.Exit(1)
+}
+
+func parseServiceList(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+
+	services := []string{}
+	for _, service := range strings.Split(raw, ",") {
+		service = strings.TrimSpace(service)
+		if service == "" {
+			continue
+		}
+		services = append(services, service)
 	}
 
-	os.Setenv("OS_PASSWORD", secret.Data.Data[vaultConfig.CredentialNameInVaultSecret].(string))
+	return services
 }
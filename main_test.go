@@ -1,10 +1,21 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/openstack-exporter/openstack-exporter/cache"
+	"github.com/openstack-exporter/openstack-exporter/config"
 	"github.com/openstack-exporter/openstack-exporter/exporters"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -95,3 +106,261 @@ func TestSelectServicesForRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCollectTimeouts(t *testing.T) {
+	timeouts, err := parseCollectTimeouts(time.Minute, map[string]string{"compute": "20s"}, map[string]string{"nova-flavors": "5s"})
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, timeouts.Default)
+	assert.Equal(t, 20*time.Second, timeouts.Services["compute"])
+	assert.Equal(t, 5*time.Second, timeouts.Metrics["nova-flavors"])
+
+	_, err = parseCollectTimeouts(0, map[string]string{"bad": "20s"}, nil)
+	assert.ErrorContains(t, err, "invalid service")
+
+	_, err = parseCollectTimeouts(0, map[string]string{"compute": "soon"}, nil)
+	assert.ErrorContains(t, err, "invalid --collect-timeout.service")
+
+	_, err = parseCollectTimeouts(0, nil, map[string]string{"nova-flavours": "5s"})
+	assert.ErrorContains(t, err, "invalid metric in --collect-timeout.metric: nova-flavours")
+}
+
+func TestParseRefreshSchedule(t *testing.T) {
+	schedule, err := parseRefreshSchedule(10*time.Minute, 0.1, map[string]string{"volume": "5m"}, map[string]string{"nova-agent_state": "30s"})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, schedule.Default)
+	assert.Equal(t, 5*time.Minute, schedule.Services["volume"])
+	assert.Equal(t, 30*time.Second, schedule.Metrics["nova-agent_state"])
+	assert.Equal(t, 0.1, schedule.Jitter)
+
+	_, err = parseRefreshSchedule(10*time.Minute, 0.1, map[string]string{"bad": "5m"}, nil)
+	assert.ErrorContains(t, err, "invalid service")
+
+	_, err = parseRefreshSchedule(10*time.Minute, 0.1, nil, map[string]string{"agent_state": "30s"})
+	assert.ErrorContains(t, err, "invalid metric")
+
+	_, err = parseRefreshSchedule(10*time.Minute, 0.1, nil, map[string]string{"nova-agent_state": "0s"})
+	assert.ErrorContains(t, err, "must be positive")
+
+	_, err = parseRefreshSchedule(10*time.Minute, 0.1, map[string]string{"volume": "10m"}, nil)
+	assert.ErrorContains(t, err, "exceeds the cache TTL")
+
+	_, err = parseRefreshSchedule(10*time.Minute, 2, nil, nil)
+	assert.ErrorContains(t, err, "--cache.refresh-jitter")
+}
+
+func TestCacheAdminHandlers(t *testing.T) {
+	cloudsYAML := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(cloudsYAML, []byte("clouds:\n  testCloud: {}\n"), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsYAML)
+
+	logger := slog.New(slog.DiscardHandler)
+	store, err := config.NewStore("", logger)
+	require.NoError(t, err)
+	previousStore, previousCloud := configStore, *cloud
+	configStore, *cloud = store, "testCloud"
+	defer func() { configStore, *cloud = previousStore, previousCloud }()
+
+	var refreshed []string
+	enableExporter := func(service, cloud string, opts exporters.Options, logger *slog.Logger) (*exporters.OpenStackExporter, error) {
+		refreshed = append(refreshed, service)
+		return nil, errors.New("service unavailable")
+	}
+	refresh := cacheRefreshHandler([]string{"compute", "network"}, enableExporter, logger)
+	inspect := cacheInspectHandler(logger)
+
+	rr := httptest.NewRecorder()
+	refresh(rr, httptest.NewRequest(http.MethodGet, "/-/cache/refresh", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	rr = httptest.NewRecorder()
+	refresh(rr, httptest.NewRequest(http.MethodPost, "/-/cache/refresh?service=bad", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	refresh(rr, httptest.NewRequest(http.MethodPost, "/-/cache/refresh?cloud=otherCloud", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	refresh(rr, httptest.NewRequest(http.MethodPost, "/-/cache/refresh?cloud=testCloud&service=network", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"network"}, refreshed, "only the requested services are refreshed")
+
+	rr = httptest.NewRecorder()
+	inspect(rr, httptest.NewRequest(http.MethodGet, "/-/cache", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var infos []cache.CloudCacheInfo
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &infos))
+	require.Len(t, infos, 1)
+	assert.Equal(t, "testCloud", infos[0].Cloud)
+	require.Len(t, infos[0].Services, 1)
+	assert.Equal(t, "network", infos[0].Services[0].Service)
+	assert.False(t, infos[0].Services[0].Success)
+}
+
+func TestProbeHandlerUnknownCloud(t *testing.T) {
+	cloudsYAML := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(cloudsYAML, []byte("clouds:\n  testCloud: {}\n"), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsYAML)
+	previousMultiCloud := *multiCloud
+	*multiCloud = true
+	defer func() { *multiCloud = previousMultiCloud }()
+
+	logger := slog.New(slog.DiscardHandler)
+	commonMetrics := exporters.NewCommonMetricsExporter("openstack", false)
+	probe := probeHandler([]string{"compute"}, nil, commonMetrics, logger)
+
+	for _, cloud := range []string{"otherCloud", "yetAnotherCloud"} {
+		rr := httptest.NewRecorder()
+		probe(rr, httptest.NewRequest(http.MethodGet, "/probe?cloud="+cloud, nil))
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	}
+
+	assert.Equal(t, 1, testutil.CollectAndCount(commonMetrics.TotalScrapes()), "unknown clouds share one label value")
+	assert.Equal(t, 2.0, testutil.ToFloat64(commonMetrics.ScrapeErrors().WithLabelValues(exporters.UnknownCloud, exporters.ScrapeModeProbe)))
+}
+
+func TestMetricHandlerCacheServesOwnMetrics(t *testing.T) {
+	cloudsYAML := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(cloudsYAML, []byte("clouds:\n  testCloud: {}\n"), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsYAML)
+
+	logger := slog.New(slog.DiscardHandler)
+	store, err := config.NewStore("", logger)
+	require.NoError(t, err)
+	previousStore, previousCloud, previousCacheEnable := configStore, *cloud, *cacheEnable
+	configStore, *cloud, *cacheEnable = store, "testCloud", true
+	defer func() { configStore, *cloud, *cacheEnable = previousStore, previousCloud, previousCacheEnable }()
+
+	commonMetrics := exporters.NewCommonMetricsExporter("openstack", false)
+	require.NoError(t, prometheus.Register(commonMetrics))
+	defer prometheus.Unregister(commonMetrics)
+
+	handler := metricHandler([]string{"compute"}, nil, commonMetrics, logger)
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), `openstack_exporter_scrapes_total{cloud="testCloud",mode="metrics"} 1`, "the cached scrape is recorded and served")
+	assert.Equal(t, 0.0, testutil.ToFloat64(commonMetrics.ScrapeErrors().WithLabelValues("testCloud", exporters.ScrapeModeMetrics)))
+}
+
+func TestSDCloudsHandler(t *testing.T) {
+	cloudsYAML := filepath.Join(t.TempDir(), "clouds.yaml")
+	require.NoError(t, os.WriteFile(cloudsYAML, []byte(`clouds:
+  prod:
+    region_name: RegionOne
+    auth:
+      auth_url: https://keystone.example.com:5000/v3
+  dev: {}
+`), 0o600))
+	t.Setenv("OS_CLIENT_CONFIG_FILE", cloudsYAML)
+
+	logger := slog.New(slog.DiscardHandler)
+	store, err := config.NewStore("", logger)
+	require.NoError(t, err)
+	previousStore := configStore
+	configStore = store
+	defer func() { configStore = previousStore }()
+
+	handler := sdCloudsHandler([]string{"compute", "network"}, logger)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "http://exporter:9180/sd/clouds", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var groups []sdTargetGroup
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &groups))
+	require.Len(t, groups, 2)
+	assert.Equal(t, []string{"exporter:9180"}, groups[0].Targets)
+	assert.Equal(t, map[string]string{
+		"__scheme__":         "http",
+		"__metrics_path__":   "/probe",
+		"__param_cloud":      "dev",
+		"cloud":              "dev",
+		"openstack_services": "compute,network",
+	}, groups[0].Labels)
+	assert.Equal(t, "prod", groups[1].Labels["cloud"])
+	assert.Equal(t, "RegionOne", groups[1].Labels["openstack_region"])
+	assert.Equal(t, "keystone.example.com:5000", groups[1].Labels["openstack_auth_url_host"])
+
+	// Served over TLS, the probes are too.
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "https://exporter:9180/sd/clouds", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	groups = nil
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &groups))
+	require.Len(t, groups, 2)
+	assert.Equal(t, "https", groups[0].Labels["__scheme__"])
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "http://exporter:9180/sd/clouds?per_service=true", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	groups = nil
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &groups))
+	require.Len(t, groups, 4)
+	assert.Equal(t, "dev", groups[0].Labels["__param_cloud"])
+	assert.Equal(t, "compute", groups[0].Labels["__param_include_services"])
+	assert.Equal(t, "compute", groups[0].Labels["service"])
+	assert.Equal(t, "network", groups[1].Labels["__param_include_services"])
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/sd/clouds?per_service=maybe", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/sd/clouds", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestHealthHandlers(t *testing.T) {
+	rr := httptest.NewRecorder()
+	healthzHandler(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	logger := slog.New(slog.DiscardHandler)
+	statuses := map[string]exporters.AuthStatus{
+		"good": {Time: time.Now(), Success: true, Catalog: true},
+		"bad":  {Time: time.Now(), Err: errors.New("The request you have made requires authentication.")},
+	}
+	authStatuses := func() map[string]exporters.AuthStatus { return statuses }
+	collected := false
+	cacheCollected := func() bool { return collected }
+
+	readyz := func(handler http.HandlerFunc, target string) (int, readiness) {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		var state readiness
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &state))
+		return rr.Code, state
+	}
+
+	code, state := readyz(readyzHandler(authStatuses, nil, logger), "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, state.Ready)
+	assert.Nil(t, state.CacheCollected)
+	require.Len(t, state.Clouds, 2)
+	assert.Equal(t, "bad", state.Clouds[0].Cloud)
+	assert.False(t, state.Clouds[0].AuthSuccess)
+	assert.Equal(t, "The request you have made requires authentication.", state.Clouds[0].Error)
+	assert.True(t, state.Clouds[1].AuthSuccess)
+
+	code, state = readyz(readyzHandler(authStatuses, nil, logger), "/readyz?cloud=good&cloud=unknown")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, state.Ready)
+	assert.Len(t, state.Clouds, 1)
+
+	code, state = readyz(readyzHandler(authStatuses, cacheCollected, logger), "/readyz?cloud=good")
+	assert.Equal(t, http.StatusServiceUnavailable, code, "the cache hasn't been collected yet")
+	require.NotNil(t, state.CacheCollected)
+	assert.False(t, *state.CacheCollected)
+
+	collected = true
+	code, _ = readyz(readyzHandler(authStatuses, cacheCollected, logger), "/readyz?cloud=good")
+	assert.Equal(t, http.StatusOK, code)
+
+	statuses["good"] = exporters.AuthStatus{Time: time.Now(), Success: true}
+	code, _ = readyz(readyzHandler(authStatuses, cacheCollected, logger), "/readyz?cloud=good")
+	assert.Equal(t, http.StatusServiceUnavailable, code, "the token came without a service catalog")
+}